@@ -8,14 +8,178 @@ import (
 	"golang.org/x/net/context"
 	"log"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 )
 
+const (
+	// Allocations that don't say otherwise live here
+	DefaultNamespace = "default"
+
+	// Pass to List to get allocations from every namespace
+	AllNamespaces = ""
+)
+
+// namespaces end up in urls, so keep them to dns label characters
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// the server's own routes that the original GET /:name would
+// otherwise serve, so no default namespace allocation can take them
+var reservedNames = map[string]bool{
+	"overdue": true,
+	"next":    true,
+	"queue":   true,
+	"graph":   true,
+	"export":  true,
+	"secrets": true,
+}
+
 // The request object sent to the server to define how and when a Container should be run
 type AllocationSpecification struct {
-	Name      string                 `json:"Name" yaml:"Name" binding:"required"`
-	Cron      string                 `json:"Cron"  yaml:"Cron" binding:"required"`
-	Container CreateContainerOptions `json:"Container" yaml:"Container" binding:"required"`
+	Name      string            `json:"Name" yaml:"Name" binding:"required"`
+	Namespace string            `json:"Namespace,omitempty" yaml:"Namespace,omitempty"`
+	Labels    map[string]string `json:"Labels,omitempty" yaml:"Labels,omitempty"`
+	// When to run, at most one of Cron, At and Every
+	Cron string `json:"Cron,omitempty"  yaml:"Cron,omitempty"`
+	// IANA time zone Cron is evaluated in, e.g. "Europe/Berlin", default the server's
+	TimeZone string `json:"TimeZone,omitempty" yaml:"TimeZone,omitempty"`
+	// Run once at this RFC 3339 time, e.g. "2026-11-01T03:00:00Z"
+	At string `json:"At,omitempty" yaml:"At,omitempty"`
+	// Run every go duration, e.g. "90s", counted from Anchor
+	Every string `json:"Every,omitempty" yaml:"Every,omitempty"`
+	// RFC 3339 time Every counts from, default when the allocation was created
+	Anchor string `json:"Anchor,omitempty" yaml:"Anchor,omitempty"`
+	// Optional go duration. Delete an At allocation this long after its run
+	ExpireAfter string `json:"ExpireAfter,omitempty" yaml:"ExpireAfter,omitempty"`
+	// Optional RFC 3339 times. Runs scheduled outside them are not started
+	ActiveFrom  string `json:"ActiveFrom,omitempty" yaml:"ActiveFrom,omitempty"`
+	ActiveUntil string `json:"ActiveUntil,omitempty" yaml:"ActiveUntil,omitempty"`
+	// Names of blackout calendars loaded on the server. Runs
+	// scheduled during any of their blackouts are skipped
+	Blackouts []string `json:"Blackouts,omitempty" yaml:"Blackouts,omitempty"`
+	// The container to run, or leave this out and give Steps
+	Container CreateContainerOptions `json:"Container" yaml:"Container"`
+	// Containers to run one after another, stopping at the first that fails.
+	// They share a volume, created for each run, mounted at Workspace
+	Steps []Step `json:"Steps,omitempty" yaml:"Steps,omitempty"`
+	// Where the shared volume is mounted in each step, default /workspace
+	Workspace string `json:"Workspace,omitempty" yaml:"Workspace,omitempty"`
+	// Optional go duration, e.g. "10m", after which a running container is stopped
+	Timeout       string                    `json:"Timeout,omitempty" yaml:"Timeout,omitempty"`
+	Notifications NotificationSpecification `json:"Notifications" yaml:"Notifications"`
+	// Optional go duration, e.g. "25h". If there's been no successful run for this
+	// long the allocation is reported as overdue
+	ExpectSuccessWithin string `json:"ExpectSuccessWithin,omitempty" yaml:"ExpectSuccessWithin,omitempty"`
+	// When to pull the image before a run, defaults to Always
+	PullPolicy PullPolicy `json:"PullPolicy,omitempty" yaml:"PullPolicy,omitempty"`
+	// Optional go duration. With IfNotPresent, re-pull an image that's
+	// present locally if it was last pulled longer ago than this
+	PullRefresh string `json:"PullRefresh,omitempty" yaml:"PullRefresh,omitempty"`
+	// Name of a registry credential loaded on the server. If empty,
+	// a credential matching the image's registry is used if there is one
+	RegistryCredential string `json:"RegistryCredential,omitempty" yaml:"RegistryCredential,omitempty"`
+	// Secrets from the server's secret store to expose to the container
+	Secrets []SecretReference `json:"Secrets,omitempty" yaml:"Secrets,omitempty"`
+	// Optional go duration. Start each run after a delay of up to this long,
+	// so allocations sharing a schedule don't all start at once
+	Jitter string `json:"Jitter,omitempty" yaml:"Jitter,omitempty"`
+	// Use a fixed delay derived from the allocation's name
+	// instead of a random one, so the start time is predictable
+	Splay bool `json:"Splay,omitempty" yaml:"Splay,omitempty"`
+	// Run when other allocations finish, instead of or as well as on Cron
+	Trigger *Trigger `json:"Trigger,omitempty" yaml:"Trigger,omitempty"`
+	// Runs with a higher priority leave the run queue first, default 0
+	Priority int `json:"Priority,omitempty" yaml:"Priority,omitempty"`
+	// Optional overrides of the server's history retention settings
+	Retention *Retention `json:"Retention,omitempty" yaml:"Retention,omitempty"`
+	// Optional precondition. If set, the update only succeeds if the stored
+	// allocation is still at this ResourceVersion, otherwise it's a conflict
+	ResourceVersion uint64 `json:"ResourceVersion,omitempty" yaml:"ResourceVersion,omitempty"`
+}
+
+// Expose the secret Name to the container, either as the environment
+// variable Env or as a file at the absolute path File. The value is
+// looked up when the container is created and never stored on the allocation
+type SecretReference struct {
+	Name string `json:"Name" yaml:"Name"`
+	Env  string `json:"Env,omitempty" yaml:"Env,omitempty"`
+	File string `json:"File,omitempty" yaml:"File,omitempty"`
+	// the File's permissions in octal, 0400 by default
+	Mode string `json:"Mode,omitempty" yaml:"Mode,omitempty"`
+}
+
+type PullPolicy string
+
+const (
+	PullAlways       PullPolicy = "Always"
+	PullIfNotPresent PullPolicy = "IfNotPresent"
+	PullNever        PullPolicy = "Never"
+)
+
+type NotificationEvent string
+
+const (
+	EventFailure   NotificationEvent = "failure"
+	EventSuccess   NotificationEvent = "success"
+	EventTimeout   NotificationEvent = "timeout"
+	EventMissed    NotificationEvent = "missed"
+	EventRecovered NotificationEvent = "recovered"
+	EventOverdue   NotificationEvent = "overdue"
+)
+
+var notificationEvents = []NotificationEvent{
+	EventFailure,
+	EventSuccess,
+	EventTimeout,
+	EventMissed,
+	EventRecovered,
+	EventOverdue,
+}
+
+// Where to send notifications about an allocation's runs
+type NotificationSpecification struct {
+	Webhooks []Webhook `json:"Webhooks,omitempty" yaml:"Webhooks,omitempty"`
+	Email    *Email    `json:"Email,omitempty" yaml:"Email,omitempty"`
+}
+
+// Mail sent through the server's SMTP relay to Recipients whenever one of
+// Events occurs. Subject and Body are optional text/template overrides
+// for the server's default templates, executed against a notify.Event.
+// Digests have a subject of their own, so only Body applies to them
+type Email struct {
+	Recipients []string            `json:"Recipients" yaml:"Recipients"`
+	Events     []NotificationEvent `json:"Events" yaml:"Events"`
+	Subject    string              `json:"Subject,omitempty" yaml:"Subject,omitempty"`
+	Body       string              `json:"Body,omitempty" yaml:"Body,omitempty"`
+}
+
+func (email Email) Wants(event NotificationEvent) bool {
+	return wantsEvent(email.Events, event)
+}
+
+// A URL to POST a signed json payload to whenever one of Events occurs
+type Webhook struct {
+	URL    string              `json:"URL" yaml:"URL"`
+	Events []NotificationEvent `json:"Events" yaml:"Events"`
+}
+
+func (webhook Webhook) Wants(event NotificationEvent) bool {
+	return wantsEvent(webhook.Events, event)
+}
+
+func wantsEvent(events []NotificationEvent, event NotificationEvent) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
 }
 
 // copy of docker.CreateContainerOptions,
@@ -27,6 +191,20 @@ type CreateContainerOptions struct {
 	NetworkingConfig *docker.NetworkingConfig `qs:"-" json:"NetworkingConfig" yaml:"NetworkingConfig"`
 }
 
+func (opts *CreateContainerOptions) provisionDefaults() {
+	if opts.Config == nil {
+		opts.Config = &docker.Config{}
+	}
+
+	if opts.HostConfig == nil {
+		opts.HostConfig = &docker.HostConfig{}
+	}
+
+	if opts.NetworkingConfig == nil {
+		opts.NetworkingConfig = &docker.NetworkingConfig{}
+	}
+}
+
 func (opts CreateContainerOptions) ToOptions() docker.CreateContainerOptions {
 	return docker.CreateContainerOptions{
 		Config:           opts.Config,
@@ -36,32 +214,195 @@ func (opts CreateContainerOptions) ToOptions() docker.CreateContainerOptions {
 	}
 }
 
-// The internal structure used to track and configure scheduled containers
+// The internal structure used to track and configure scheduled containers.
+// Paused allocations stay in the store but aren't scheduled
 type Allocation struct {
-	Name      string                 `json:"Name" `
-	Logs      []interface{}          `json:"Logs"`
-	Cron      string                 `json:"Cron"`
-	CronExpr  *cronexpr.Expression   `json:"-"`
-	Container CreateContainerOptions `json:"Container"`
+	Name                string                    `json:"Name" `
+	Namespace           string                    `json:"Namespace"`
+	ResourceVersion     uint64                    `json:"ResourceVersion"`
+	Revision            int                       `json:"Revision"`
+	Labels              map[string]string         `json:"Labels,omitempty"`
+	Paused              bool                      `json:"Paused"`
+	Created             time.Time                 `json:"Created"`
+	Logs                []interface{}             `json:"Logs"`
+	Runs                []*Run                    `json:"Runs"`
+	Cron                string                    `json:"Cron"`
+	CronExpr            *cronexpr.Expression      `json:"-"`
+	TimeZone            string                    `json:"TimeZone,omitempty"`
+	At                  time.Time                 `json:"At,omitempty"`
+	Every               time.Duration             `json:"Every,omitempty"`
+	Anchor              time.Time                 `json:"Anchor,omitempty"`
+	ExpireAfter         time.Duration             `json:"ExpireAfter,omitempty"`
+	ActiveFrom          time.Time                 `json:"ActiveFrom,omitempty"`
+	ActiveUntil         time.Time                 `json:"ActiveUntil,omitempty"`
+	Blackouts           []string                  `json:"Blackouts,omitempty"`
+	Container           CreateContainerOptions    `json:"Container"`
+	Steps               []Step                    `json:"Steps,omitempty"`
+	Workspace           string                    `json:"Workspace,omitempty"`
+	Timeout             time.Duration             `json:"Timeout"`
+	Notifications       NotificationSpecification `json:"Notifications"`
+	ExpectSuccessWithin time.Duration             `json:"ExpectSuccessWithin"`
+	PullPolicy          PullPolicy                `json:"PullPolicy"`
+	PullRefresh         time.Duration             `json:"PullRefresh"`
+	RegistryCredential  string                    `json:"RegistryCredential,omitempty"`
+	Secrets             []SecretReference         `json:"Secrets,omitempty"`
+	Retention           *Retention                `json:"Retention,omitempty"`
+	Jitter              time.Duration             `json:"Jitter"`
+	Splay               bool                      `json:"Splay"`
+	Priority            int                       `json:"Priority"`
+	Trigger             *Trigger                  `json:"Trigger,omitempty"`
 }
 
 type Allocations []*Allocation
 
 func (allocation AllocationSpecification) Validate(errors *binding.Errors, req *http.Request) {
-	_, err := cronexpr.Parse(allocation.Cron)
+	for field, problem := range allocation.Check() {
+		errors.Fields[field] = problem
+	}
+}
+
+// Every problem with the specification that would make the server
+// refuse it, keyed by field. Empty if it's valid. A Trigger that loops
+// back through other allocations is only found against the store, by
+// CreateOrUpdate, and the server answers it as a problem with Trigger too
+func (allocation AllocationSpecification) Check() map[string]string {
+	problems := map[string]string{}
+	if allocation.Name == "" {
+		problems["Name"] = "Name is required"
+	}
+	if reservedNames[allocation.Name] && NamespaceOrDefault(allocation.Namespace) == DefaultNamespace {
+		problems["Name"] = fmt.Sprintf("%v is reserved in the default namespace, as GET /%v is one of the server's own routes", allocation.Name, allocation.Name)
+	}
+
+	if allocation.Namespace != "" && !ValidNamespace(allocation.Namespace) {
+		problems["Namespace"] = "Namespace must be lower case letters, digits and dashes"
+	}
+
+	if labels := validateLabels(allocation.Labels); len(labels) > 0 {
+		problems["Labels"] = strings.Join(labels, ", ")
+	}
+
+	for field, problem := range validateSchedule(&allocation) {
+		problems[field] = problem
+	}
+
+	if allocation.Trigger != nil {
+		if trigger := validateTrigger(&allocation); len(trigger) > 0 {
+			problems["Trigger"] = strings.Join(trigger, ", ")
+		}
+	}
+
+	if allocation.Timeout != "" {
+		if _, err := time.ParseDuration(allocation.Timeout); err != nil {
+			problems["Timeout"] = fmt.Sprintf("%v", err)
+		}
+	}
+
+	if allocation.ExpectSuccessWithin != "" {
+		if _, err := time.ParseDuration(allocation.ExpectSuccessWithin); err != nil {
+			problems["ExpectSuccessWithin"] = fmt.Sprintf("%v", err)
+		}
+	}
+
+	if allocation.Jitter != "" {
+		if jitter, err := time.ParseDuration(allocation.Jitter); err != nil {
+			problems["Jitter"] = fmt.Sprintf("%v", err)
+		} else if jitter < 0 {
+			problems["Jitter"] = "Jitter can't be negative"
+		}
+	}
+	if allocation.Splay && allocation.Jitter == "" {
+		problems["Splay"] = "Splay needs a Jitter to spread runs over"
+	}
+
+	switch allocation.PullPolicy {
+	case "", PullAlways, PullIfNotPresent, PullNever:
+	default:
+		problems["PullPolicy"] = fmt.Sprintf("unknown pull policy %v, expected one of Always, IfNotPresent, Never", allocation.PullPolicy)
+	}
+
+	if allocation.PullRefresh != "" {
+		if _, err := time.ParseDuration(allocation.PullRefresh); err != nil {
+			problems["PullRefresh"] = fmt.Sprintf("%v", err)
+		}
+	}
+
+	for i, secret := range allocation.Secrets {
+		field := fmt.Sprintf("Secrets[%v]", i)
+		if secret.Name == "" {
+			problems[field+".Name"] = "Name is required"
+		}
+		if (secret.Env == "") == (secret.File == "") {
+			problems[field] = "exactly one of Env or File is required"
+		}
+		if secret.File != "" && !path.IsAbs(secret.File) {
+			problems[field+".File"] = "File must be an absolute path"
+		}
+		if secret.Mode != "" {
+			if secret.File == "" {
+				problems[field+".Mode"] = "Mode only applies to File"
+			} else if mode, err := strconv.ParseUint(secret.Mode, 8, 32); err != nil || mode > 0777 {
+				problems[field+".Mode"] = "Mode must be octal permissions, e.g. 0440"
+			}
+		}
+	}
+
+	if retention := allocation.Retention; retention != nil {
+		if retention.KeepRuns < 0 || retention.KeepDays < 0 || retention.KeepFailureDays < 0 || retention.KeepLogs < 0 {
+			problems["Retention"] = "retention settings can't be negative"
+		}
+	}
 
-	if err != nil {
-		errors.Fields["Cron"] = fmt.Sprintf("%v", err)
+	for i, webhook := range allocation.Notifications.Webhooks {
+		field := fmt.Sprintf("Notifications.Webhooks[%v]", i)
+		if _, err := url.ParseRequestURI(webhook.URL); err != nil {
+			problems[field+".URL"] = fmt.Sprintf("%v", err)
+		}
+		validateEvents(problems, field+".Events", webhook.Events)
+	}
+
+	if email := allocation.Notifications.Email; email != nil {
+		if len(email.Recipients) == 0 {
+			problems["Notifications.Email.Recipients"] = "at least one recipient is required"
+		}
+		for _, recipient := range email.Recipients {
+			if _, err := mail.ParseAddress(recipient); err != nil {
+				problems["Notifications.Email.Recipients"] = fmt.Sprintf("%v: %v", recipient, err)
+			}
+		}
+		validateEvents(problems, "Notifications.Email.Events", email.Events)
+		if _, err := template.New("subject").Parse(email.Subject); err != nil {
+			problems["Notifications.Email.Subject"] = fmt.Sprintf("%v", err)
+		}
+		if _, err := template.New("body").Parse(email.Body); err != nil {
+			problems["Notifications.Email.Body"] = fmt.Sprintf("%v", err)
+		}
+	}
+
+	if len(allocation.Steps) > 0 {
+		for field, problem := range validateSteps(&allocation) {
+			problems[field] = problem
+		}
+		return problems
 	}
 
 	if allocation.Container.Config == nil {
-		errors.Fields["Container.Config"] = "Config is required"
-		return
+		problems["Container.Config"] = "Config is required"
+		return problems
 	}
 
 	// Having issues getting AutoRemove to work. Coming soon
 	if allocation.Container.Config.Image == "" {
-		errors.Fields["Container.Config.Image"] = "Image is required"
+		problems["Container.Config.Image"] = "Image is required"
+	}
+	return problems
+}
+
+func validateEvents(problems map[string]string, field string, events []NotificationEvent) {
+	for _, event := range events {
+		if !wantsEvent(notificationEvents, event) {
+			problems[field] = fmt.Sprintf("unknown event %v", event)
+		}
 	}
 }
 
@@ -71,46 +412,181 @@ func (allocation AllocationSpecification) Validate(errors *binding.Errors, req *
 // to gkvlite or etcd or redis or whatever
 // These are allowed to return error
 // because other implementations may include IO calls
+//
+// Allocation names are only unique within a namespace,
+// so every lookup is by namespace and name
 type AllocationStore interface {
-	// Get a list of all allocations
-	List() (Allocations, error)
+	// Get a list of the allocations in a namespace whose labels
+	// match selector. Use AllNamespaces to search every namespace
+	// and Everything() to match every allocation.
+	// The allocations are copies, see Get
+	List(namespace string, selector Selector) (Allocations, error)
 
-	// Get the allocation by name.
-	// will return an error if it can't be found
-	Get(name string) (*Allocation, error)
+	// Get the allocation by namespace and name.
+	// will return an error if it can't be found.
+	// The allocation is a copy, so it can be read without the store's
+	// lock, and later writes to the store don't change it. Get it again
+	// to see them
+	Get(namespace string, name string) (*Allocation, error)
 
-	// Delete an allocation by name
+	// Delete an allocation by namespace and name
 	// will return an error if it can't be found
-	Delete(name string) error
+	Delete(namespace string, name string) error
 
-	// If an allocation exists with the given name,
+	// If an allocation exists with the given namespace and name,
 	// update the values of that allocation.
 	// Otherwise create a new one. Returns whether a
 	// new allocation was created.
-	CreateOrUpdate(allocation *AllocationSpecification) (bool, error)
+	//
+	// A specification whose Trigger would make a loop of allocations
+	// triggering each other is refused with a *CycleError.
+	//
+	// Every change gives the allocation a new, higher ResourceVersion.
+	// If the specification has a ResourceVersion the stored allocation
+	// must exist and be at that version, checked atomically with the
+	// write, or a *ConflictError is returned and nothing is changed.
+	// So is a change that's CreateOnly if the allocation exists, and
+	// a *QuotaError for a new one over the change's MaxAllocations.
+	// An At that has already passed is refused with a *PassedAtError
+	// when the allocation is created or its At changes, as it would
+	// never run, but not when an unchanged At is written again.
+	//
+	// A write that changes the specification is recorded as a new
+	// Revision, attributed to change. Returns whether the allocation
+	// was created, and its new ResourceVersion
+	CreateOrUpdate(allocation *AllocationSpecification, change Change) (bool, uint64, error)
+
+	// Get every revision of an allocation, oldest first
+	// will return an error if it can't be found
+	Revisions(namespace string, name string) ([]*Revision, error)
+
+	// Pause or resume scheduling of an allocation
+	// will return an error if it can't be found
+	SetPaused(namespace string, name string, paused bool) error
 
 	// Log an event regarding an exiting specification
 	Log(allocation *Allocation, events ...interface{}) error
+
+	// Append a completed run to the allocation's history. Runs
+	// with a FencingToken older than the newest the store has
+	// seen are refused with a *StaleTokenError
+	RecordRun(allocation *Allocation, run *Run) error
+
+	// Claim the store for the holder of a scheduler lease's token,
+	// failing with a *StaleTokenError if a newer token has been seen.
+	// Token 0 means unfenced, e.g. a single server or a manual trigger
+	Fence(token uint64) error
+
+	// Prune the runs and logs of every allocation, using each allocation's
+	// own Retention where it has one and defaults otherwise
+	Compact(defaults Retention, now time.Time) (Compaction, error)
+
+	// Store an allocation exactly as recorded, history included,
+	// replacing any existing allocation with the same namespace and name.
+	// Used to import allocations exported from another server
+	Restore(record *Record) error
+}
+
+// Returned when there's no allocation with the name
+type NotFoundError struct {
+	Namespace string
+	Name      string
+}
+
+func (err *NotFoundError) Error() string {
+	return fmt.Sprintf("Allocation with name %v not found in namespace %v", err.Name, err.Namespace)
+}
+
+// Returned when a write's ResourceVersion precondition
+// doesn't match the stored allocation
+type ConflictError struct {
+	Namespace string
+	Name      string
+	// the version the write expected, 0 if it expected
+	// there to be no such allocation
+	Expected uint64
+	// the stored version, 0 if there's no such allocation
+	Actual uint64
+}
+
+func (err *ConflictError) Error() string {
+	if err.Expected == 0 {
+		return fmt.Sprintf("allocation %v/%v was expected not to exist but is at version %v, it was created by someone else", err.Namespace, err.Name, err.Actual)
+	}
+	if err.Actual == 0 {
+		return fmt.Sprintf("allocation %v/%v was expected at version %v but does not exist", err.Namespace, err.Name, err.Expected)
+	}
+	return fmt.Sprintf("allocation %v/%v was expected at version %v but is at version %v, it was changed by someone else", err.Namespace, err.Name, err.Expected, err.Actual)
+}
+
+// Returned when creating an allocation would take its
+// namespace over the change's MaxAllocations
+type QuotaError struct {
+	Namespace      string
+	MaxAllocations int
+}
+
+func (err *QuotaError) Error() string {
+	return fmt.Sprintf("namespace %v already has its maximum of %v allocations", err.Namespace, err.MaxAllocations)
+}
+
+// Returned when an allocation is given an At that has already passed
+type PassedAtError struct {
+	Namespace string
+	Name      string
+	At        time.Time
+}
+
+func (err *PassedAtError) Error() string {
+	return fmt.Sprintf("At %v has already passed", err.At.Format(time.RFC3339))
+}
+
+// Returned when a deposed scheduler tries to act
+// after a newer one has claimed the store
+type StaleTokenError struct {
+	Token   uint64
+	Current uint64
+}
+
+func (err *StaleTokenError) Error() string {
+	return fmt.Sprintf("fencing token %v is stale, the store has seen %v, another server is scheduling", err.Token, err.Current)
 }
 
 func (allocation *Allocation) ShouldRunAt(atTime time.Time) bool {
-	nextExecution := allocation.CronExpr.Next(atTime)
+	schedule := allocation.Schedule()
+	if schedule == nil {
+		// only runs when triggered
+		return false
+	}
+	nextExecution := schedule.Next(atTime)
+	if nextExecution.IsZero() || !allocation.ActiveAt(nextExecution) {
+		// an At allocation that has had its run, or
+		// outside the allocation's active window
+		return false
+	}
 	log.Printf("Allocation %v would next run at %v", allocation.Name, nextExecution)
 	oneMinute, _ := time.ParseDuration("1m")
 	return nextExecution.Before(atTime.Add(oneMinute))
 }
 
 func (newAllocation *AllocationSpecification) ProvisionDefaults() {
-	if newAllocation.Container.Config == nil {
-		newAllocation.Container.Config = &docker.Config{}
+	if len(newAllocation.Steps) > 0 {
+		for i := range newAllocation.Steps {
+			newAllocation.Steps[i].Container.provisionDefaults()
+		}
+		if newAllocation.Workspace == "" {
+			newAllocation.Workspace = DefaultWorkspace
+		}
+	} else {
+		newAllocation.Container.provisionDefaults()
 	}
 
-	if newAllocation.Container.HostConfig == nil {
-		newAllocation.Container.HostConfig = &docker.HostConfig{}
+	if newAllocation.Namespace == "" {
+		newAllocation.Namespace = DefaultNamespace
 	}
 
-	if newAllocation.Container.NetworkingConfig == nil {
-		newAllocation.Container.NetworkingConfig = &docker.NetworkingConfig{}
+	if newAllocation.PullPolicy == "" {
+		newAllocation.PullPolicy = PullAlways
 	}
 
 }
@@ -119,10 +595,57 @@ func NewAllocation(newAllocation *AllocationSpecification) *Allocation {
 
 	allocation := &Allocation{
 		Name:      newAllocation.Name,
-		Cron:      newAllocation.Cron,
-		Container: newAllocation.Container,
-		CronExpr:  cronexpr.MustParse(newAllocation.Cron), // we can MustParse because this was validated during request binding
+		Namespace: NamespaceOrDefault(newAllocation.Namespace),
+		Created:   time.Now(),
 	}
-
+	allocation.apply(newAllocation)
 	return allocation
 }
+
+func ValidNamespace(namespace string) bool {
+	return namespacePattern.MatchString(namespace)
+}
+
+func NamespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return DefaultNamespace
+	}
+	return namespace
+}
+
+// copy the user-specified fields of a specification onto an allocation,
+// leaving its logs and run history alone.
+func (allocation *Allocation) apply(spec *AllocationSpecification) {
+	allocation.Labels = spec.Labels
+	allocation.Container = spec.Container
+	allocation.Steps = spec.Steps
+	allocation.Workspace = spec.Workspace
+	allocation.Cron = spec.Cron
+	allocation.CronExpr = nil
+	if spec.Cron != "" {
+		allocation.CronExpr = cronexpr.MustParse(spec.Cron) // we can MustParse because this was validated during request binding
+	}
+	allocation.TimeZone = spec.TimeZone
+	allocation.At, _ = time.Parse(time.RFC3339, spec.At)
+	allocation.Every, _ = time.ParseDuration(spec.Every)
+	allocation.Anchor, _ = time.Parse(time.RFC3339, spec.Anchor)
+	if allocation.Anchor.IsZero() {
+		allocation.Anchor = allocation.Created
+	}
+	allocation.ExpireAfter, _ = time.ParseDuration(spec.ExpireAfter)
+	allocation.ActiveFrom, _ = time.Parse(time.RFC3339, spec.ActiveFrom)
+	allocation.ActiveUntil, _ = time.Parse(time.RFC3339, spec.ActiveUntil)
+	allocation.Blackouts = spec.Blackouts
+	allocation.Timeout, _ = time.ParseDuration(spec.Timeout)
+	allocation.Notifications = spec.Notifications
+	allocation.ExpectSuccessWithin, _ = time.ParseDuration(spec.ExpectSuccessWithin)
+	allocation.PullPolicy = spec.PullPolicy
+	allocation.PullRefresh, _ = time.ParseDuration(spec.PullRefresh)
+	allocation.RegistryCredential = spec.RegistryCredential
+	allocation.Secrets = spec.Secrets
+	allocation.Retention = spec.Retention
+	allocation.Jitter, _ = time.ParseDuration(spec.Jitter)
+	allocation.Splay = spec.Splay
+	allocation.Priority = spec.Priority
+	allocation.Trigger = spec.Trigger
+}
@@ -48,3 +48,209 @@ func testAt(cron string, atTime time.Time, expected bool, t *testing.T) {
 	}
 
 }
+
+func TestOverdueSince(t *testing.T) {
+	created, _ := time.Parse(time.RFC3339, "2016-12-11T22:00:00+00:00")
+	a := &Allocation{
+		Name:                "foo",
+		Created:             created,
+		ExpectSuccessWithin: time.Hour,
+	}
+
+	if !a.OverdueSince(created.Add(30 * time.Minute)).IsZero() {
+		t.Error("expected allocation not to be overdue before its first deadline")
+	}
+
+	if !a.OverdueSince(created.Add(2 * time.Hour)).Equal(created.Add(time.Hour)) {
+		t.Errorf("expected a never-run allocation to be overdue an hour after creation")
+	}
+
+	a.Runs = []*Run{
+		{Status: RunSucceeded, FinishedAt: created.Add(90 * time.Minute)},
+		{Status: RunFailed, FinishedAt: created.Add(100 * time.Minute)},
+	}
+	if !a.OverdueSince(created.Add(2 * time.Hour)).IsZero() {
+		t.Error("expected allocation not to be overdue within an hour of its last success")
+	}
+
+	if !a.OverdueSince(created.Add(3 * time.Hour)).Equal(created.Add(150 * time.Minute)) {
+		t.Errorf("expected allocation to be overdue an hour after its last success, failures don't count")
+	}
+}
+
+func TestOffset(t *testing.T) {
+	a := &Allocation{Namespace: "default", Name: "foo", Jitter: 30 * time.Second, Splay: true}
+	first := a.Offset()
+	if first < 0 || first >= a.Jitter {
+		t.Errorf("expected splay within [0, %v) but was %v", a.Jitter, first)
+	}
+	if a.Offset() != first {
+		t.Error("expected splay to be the same every time")
+	}
+	b := &Allocation{Namespace: "default", Name: "bar", Jitter: 30 * time.Second, Splay: true}
+	if b.Offset() == first {
+		t.Error("expected different allocations to be splayed differently")
+	}
+
+	a.Splay = false
+	for i := 0; i < 100; i++ {
+		if offset := a.Offset(); offset < 0 || offset >= a.Jitter {
+			t.Fatalf("expected jitter within [0, %v) but was %v", a.Jitter, offset)
+		}
+	}
+
+	a.Jitter = 0
+	if a.Offset() != 0 {
+		t.Error("expected no offset without Jitter")
+	}
+}
+
+func TestNextRuns(t *testing.T) {
+	after, _ := time.Parse(time.RFC3339, "2016-12-11T22:00:30+00:00")
+	a := &Allocation{
+		Namespace: "default",
+		Name:      "foo",
+		CronExpr:  cronexpr.MustParse("1 * * * * *"),
+		Jitter:    time.Minute,
+	}
+
+	planned := a.NextRuns(after, 2)
+	if len(planned) != 2 {
+		t.Fatalf("expected 2 planned runs but got %v", len(planned))
+	}
+	if !planned[0].Scheduled.Equal(after.Add(30*time.Second)) || !planned[0].Latest.Equal(planned[0].Scheduled.Add(time.Minute)) {
+		t.Errorf("unexpected jittered run %+v", planned[0])
+	}
+
+	a.Splay = true
+	planned = a.NextRuns(after, 1)
+	if !planned[0].Earliest.Equal(planned[0].Latest) || !planned[0].Earliest.Equal(planned[0].Scheduled.Add(a.Offset())) {
+		t.Errorf("expected splayed run to start at exactly its offset but got %+v", planned[0])
+	}
+}
+
+func TestScheduleKinds(t *testing.T) {
+	created, _ := time.Parse(time.RFC3339, "2016-12-11T22:00:00+00:00")
+
+	every := NewAllocation(&AllocationSpecification{Name: "every", Every: "90s"})
+	every.Anchor = created
+	planned := every.NextRuns(created.Add(time.Minute), 2)
+	if len(planned) != 2 || !planned[0].Scheduled.Equal(created.Add(90*time.Second)) || !planned[1].Scheduled.Equal(created.Add(180*time.Second)) {
+		t.Errorf("unexpected Every runs %+v", planned)
+	}
+	if !every.ShouldRunAt(created.Add(45 * time.Second)) {
+		t.Errorf("expected Every allocation to run in the minute after 45s")
+	}
+
+	at := NewAllocation(&AllocationSpecification{Name: "at", At: "2016-12-11T23:00:00Z", ExpireAfter: "1h"})
+	if at.Kind() != ScheduleAt {
+		t.Fatalf("expected kind At but was %q", at.Kind())
+	}
+	if !at.ShouldRunAt(created.Add(59*time.Minute + 30*time.Second)) {
+		t.Errorf("expected At allocation to run in the minute before its time")
+	}
+	if planned := at.NextRuns(created, 5); len(planned) != 1 {
+		t.Errorf("expected one At run but got %+v", planned)
+	}
+	if at.ShouldRunAt(created.Add(2 * time.Hour)) {
+		t.Errorf("expected At allocation not to run after its time")
+	}
+
+	if !at.CompletedAt().IsZero() {
+		t.Errorf("expected At allocation not to be complete before it runs")
+	}
+	at.Runs = []*Run{{StartedAt: at.At, FinishedAt: at.At.Add(time.Minute), Status: RunSucceeded}}
+	if !at.CompletedAt().Equal(at.At.Add(time.Minute)) {
+		t.Errorf("expected At allocation to complete when its run finished but was %v", at.CompletedAt())
+	}
+	if at.Expired(at.At.Add(30*time.Minute)) || !at.Expired(at.At.Add(2*time.Hour)) {
+		t.Errorf("expected At allocation to expire an hour after completing")
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	cases := map[string]AllocationSpecification{
+		"":            {Cron: "* * * * * *"},
+		"Cron":        {Cron: "* * * * * *", Every: "1m"},
+		"At":          {At: "tomorrow"},
+		"Every":       {Every: "10ms"},
+		"Anchor":      {Cron: "* * * * * *", Anchor: "2016-12-11T22:00:00Z"},
+		"ExpireAfter": {Every: "1m", ExpireAfter: "1h"},
+		"TimeZone":    {Cron: "* * * * * *", TimeZone: "Mars/Olympus_Mons"},
+	}
+	for field, spec := range cases {
+		problems := validateSchedule(&spec)
+		if field == "" && len(problems) > 0 {
+			t.Errorf("expected no problems but got %v", problems)
+		}
+		if _, ok := problems[field]; field != "" && (!ok || len(problems) != 1) {
+			t.Errorf("expected only a problem with %v but got %v", field, problems)
+		}
+	}
+
+	if problems := validateSchedule(&AllocationSpecification{At: time.Now().Add(-time.Hour).Format(time.RFC3339)}); len(problems) > 0 {
+		t.Errorf("expected an At in the past to be left to the store but got %v", problems)
+	}
+	if problems := validateSchedule(&AllocationSpecification{At: time.Now().Add(time.Hour).Format(time.RFC3339)}); len(problems) > 0 {
+		t.Errorf("expected an At in the future to be fine but got %v", problems)
+	}
+	if problems := validateSchedule(&AllocationSpecification{}); problems["Cron"] == "" {
+		t.Errorf("expected an allocation without a schedule or trigger to be refused")
+	}
+	if problems := validateSchedule(&AllocationSpecification{Trigger: &Trigger{After: []string{"foo"}}}); len(problems) > 0 {
+		t.Errorf("expected a trigger-only allocation to be fine but got %v", problems)
+	}
+}
+
+func TestTimeZone(t *testing.T) {
+	// 2am every day, in New York
+	a := NewAllocation(&AllocationSpecification{Name: "nightly", Cron: "0 2 * * *", TimeZone: "America/New_York"})
+	before, _ := time.Parse(time.RFC3339, "2016-12-11T12:00:00Z")
+
+	planned := a.NextRuns(before, 1)
+	if len(planned) != 1 || !planned[0].Scheduled.Equal(before.Add(19*time.Hour)) {
+		t.Errorf("expected a run at 07:00 UTC but got %+v", planned)
+	}
+
+	if problems := validateSchedule(&AllocationSpecification{Every: "1h", TimeZone: "UTC"}); problems["TimeZone"] == "" {
+		t.Errorf("expected a TimeZone without a Cron to be refused")
+	}
+}
+
+func TestActiveWindow(t *testing.T) {
+	a := NewAllocation(&AllocationSpecification{
+		Name:        "window",
+		Every:       "1h",
+		Anchor:      "2016-12-11T00:00:00Z",
+		ActiveFrom:  "2016-12-12T00:00:00Z",
+		ActiveUntil: "2016-12-12T03:00:00Z",
+	})
+	before, _ := time.Parse(time.RFC3339, "2016-12-11T12:00:00Z")
+
+	planned := a.NextRuns(before, 10)
+	if len(planned) != 4 || !planned[0].Scheduled.Equal(a.ActiveFrom) || !planned[3].Scheduled.Equal(a.ActiveUntil) {
+		t.Errorf("expected the 4 runs in the active window but got %+v", planned)
+	}
+	if a.ShouldRunAt(before) {
+		t.Errorf("expected no run before ActiveFrom")
+	}
+	if a.ShouldRunAt(a.ActiveUntil.Add(time.Minute)) {
+		t.Errorf("expected no run after ActiveUntil")
+	}
+
+	problems := validateSchedule(&AllocationSpecification{Every: "1h", ActiveFrom: "2016-12-12T00:00:00Z", ActiveUntil: "2016-12-11T00:00:00Z"})
+	if problems["ActiveUntil"] == "" {
+		t.Errorf("expected ActiveUntil before ActiveFrom to be refused but got %v", problems)
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	spec := AllocationSpecification{Name: "queue", Cron: "* * * * * *"}
+	if problems := spec.Check(); problems["Name"] == "" {
+		t.Error("expected queue to be reserved in the default namespace")
+	}
+	spec.Namespace = "data"
+	if problems := spec.Check(); problems["Name"] != "" {
+		t.Errorf("expected queue to be fine in another namespace but got %v", problems["Name"])
+	}
+}
@@ -0,0 +1,359 @@
+package allocations
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorhill/cronexpr"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File creates an allocation store kept in the file at path, which several
+// servers can share, e.g. on NFS. The scheduler lease and the newest fencing
+// token are kept in the same file, so a server deposed as scheduler finds
+// out from the store it writes runs to.
+//
+// Each change locks the file, reads it, makes the change and rewrites the
+// whole file, so every server sees every other's changes. That's fine for
+// a few servers and the allocations a docket deployment has, not for a
+// busy store. Servers must have reasonably synchronised clocks, as lease
+// expiry is compared against each server's own clock
+func File(path string) (*FileAllocations, error) {
+	store := &FileAllocations{
+		path:         path,
+		memory:       InMemory(),
+		mutex:        &sync.Mutex{},
+		pollInterval: time.Second,
+	}
+
+	state, err := store.read()
+	if err != nil {
+		return nil, err
+	}
+	memory := store.memory
+	memory.allocations = state.Allocations
+	memory.revisions = state.Revisions
+	memory.version = state.Version
+	memory.fence = state.Fence
+	// watches can't replay what happened before we started
+	memory.expired = state.Version
+	store.lease = state.Lease
+	return store, nil
+}
+
+// An AllocationStore kept in a file shared by several servers. The file is
+// loaded into an InMemoryAllocations, which does the work and sends this
+// server's watchers the changes, whichever server made them
+type FileAllocations struct {
+	path   string
+	memory *InMemoryAllocations
+	lease  fileLease
+
+	// held while the file is loaded, changed and saved,
+	// as the file lock only keeps other servers out
+	mutex *sync.Mutex
+	// memory has changes that couldn't be saved, so
+	// reload it even if the file's version matches
+	dirty bool
+
+	// how often the file is checked for other servers'
+	// changes while this server has watchers
+	pollInterval time.Duration
+	polling      bool
+}
+
+// what's kept in the file
+type fileState struct {
+	Version     uint64                 `json:"Version"`
+	Fence       uint64                 `json:"Fence"`
+	Lease       fileLease              `json:"Lease"`
+	Allocations Allocations            `json:"Allocations"`
+	Revisions   map[string][]*Revision `json:"Revisions"`
+}
+
+type fileLease struct {
+	Holder  string    `json:"Holder"`
+	Token   uint64    `json:"Token"`
+	Expires time.Time `json:"Expires"`
+}
+
+func (f *FileAllocations) List(namespace string, selector Selector) (list Allocations, err error) {
+	err = f.view(func() error {
+		list, err = f.memory.List(namespace, selector)
+		return err
+	})
+	return list, err
+}
+
+func (f *FileAllocations) Get(namespace string, name string) (allocation *Allocation, err error) {
+	err = f.view(func() error {
+		allocation, err = f.memory.Get(namespace, name)
+		return err
+	})
+	return allocation, err
+}
+
+func (f *FileAllocations) Revisions(namespace string, name string) (revisions []*Revision, err error) {
+	err = f.view(func() error {
+		revisions, err = f.memory.Revisions(namespace, name)
+		return err
+	})
+	return revisions, err
+}
+
+func (f *FileAllocations) CreateOrUpdate(spec *AllocationSpecification, change Change) (created bool, version uint64, err error) {
+	err = f.update(func() error {
+		created, version, err = f.memory.CreateOrUpdate(spec, change)
+		return err
+	})
+	return created, version, err
+}
+
+func (f *FileAllocations) Delete(namespace string, name string) error {
+	return f.update(func() error {
+		return f.memory.Delete(namespace, name)
+	})
+}
+
+func (f *FileAllocations) SetPaused(namespace string, name string, paused bool) error {
+	return f.update(func() error {
+		return f.memory.SetPaused(namespace, name, paused)
+	})
+}
+
+func (f *FileAllocations) Log(allocation *Allocation, events ...interface{}) error {
+	return f.update(func() error {
+		return f.memory.Log(allocation, events...)
+	})
+}
+
+func (f *FileAllocations) RecordRun(allocation *Allocation, run *Run) error {
+	return f.update(func() error {
+		return f.memory.RecordRun(allocation, run)
+	})
+}
+
+func (f *FileAllocations) Fence(token uint64) error {
+	return f.update(func() error {
+		return f.memory.Fence(token)
+	})
+}
+
+func (f *FileAllocations) Compact(defaults Retention, now time.Time) (compaction Compaction, err error) {
+	err = f.update(func() error {
+		compaction, err = f.memory.Compact(defaults, now)
+		return err
+	})
+	return compaction, err
+}
+
+func (f *FileAllocations) Restore(record *Record) error {
+	return f.update(func() error {
+		return f.memory.Restore(record)
+	})
+}
+
+// Changes other servers make are picked up by checking the
+// file every pollInterval for as long as anyone is watching
+func (f *FileAllocations) Watch(sinceVersion uint64) (events <-chan WatchEvent, cancel func(), err error) {
+	err = f.view(func() error {
+		events, cancel, err = f.memory.Watch(sinceVersion)
+		if err == nil && !f.polling {
+			f.polling = true
+			go f.poll()
+		}
+		return err
+	})
+	return events, cancel, err
+}
+
+func (f *FileAllocations) poll() {
+	for {
+		time.Sleep(f.pollInterval)
+
+		f.mutex.Lock()
+		if !f.memory.watched() {
+			f.polling = false
+			f.mutex.Unlock()
+			return
+		}
+		err := f.load()
+		f.mutex.Unlock()
+		if err != nil {
+			log.Printf("Couldn't check %v for changes, error was %v", f.path, err)
+		}
+	}
+}
+
+// Become the holder of the scheduler lease, or stay the holder, for
+// ttl. A new holder gets a token above any the store has seen and
+// fences the store with it straight away, so the runs of the server
+// it replaced are refused from then on
+func (f *FileAllocations) TryAcquire(holder string, ttl time.Duration) (token uint64, acquired bool, err error) {
+	err = f.update(func() error {
+		now := time.Now()
+		if f.lease.Holder != holder && now.Before(f.lease.Expires) {
+			return nil
+		}
+		if f.lease.Holder != holder {
+			// a new term
+			if f.memory.fence > f.lease.Token {
+				f.lease.Token = f.memory.fence
+			}
+			f.lease.Holder = holder
+			f.lease.Token++
+			if err := f.memory.Fence(f.lease.Token); err != nil {
+				return err
+			}
+		}
+		f.lease.Expires = now.Add(ttl)
+		token = f.lease.Token
+		acquired = true
+		return nil
+	})
+	return token, acquired, err
+}
+
+// Give up the scheduler lease early, if holder has it
+func (f *FileAllocations) Release(holder string) error {
+	return f.update(func() error {
+		if f.lease.Holder == holder {
+			// keep the holder and token so the next holder's token is higher
+			f.lease.Expires = time.Time{}
+		}
+		return nil
+	})
+}
+
+// Read with the latest the file has
+func (f *FileAllocations) view(read func() error) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.load(); err != nil {
+		return err
+	}
+	return read()
+}
+
+// Make a change to the latest the file has, and save it if anything changed
+func (f *FileAllocations) update(change func() error) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	unlock, err := lockFile(f.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := f.load(); err != nil {
+		return err
+	}
+	before := f.stamp()
+	if err := change(); err != nil {
+		return err
+	}
+	if f.stamp() == before {
+		return nil
+	}
+
+	if err := f.save(); err != nil {
+		// undo the change in memory the next time we look
+		f.dirty = true
+		return err
+	}
+	return nil
+}
+
+// what a change can move on
+type fileStamp struct {
+	version uint64
+	fence   uint64
+	lease   fileLease
+}
+
+// must be called while f is locked
+func (f *FileAllocations) stamp() fileStamp {
+	f.memory.lockFor("stamp")
+	defer f.memory.unlock()
+	return fileStamp{version: f.memory.version, fence: f.memory.fence, lease: f.lease}
+}
+
+// Bring memory up to date with the file, sending watchers whatever
+// changed since it was last loaded. must be called while f is locked
+func (f *FileAllocations) load() error {
+	state, err := f.read()
+	if err != nil {
+		return err
+	}
+	f.lease = state.Lease
+	f.memory.replace(state, f.dirty)
+	f.dirty = false
+	return nil
+}
+
+func (f *FileAllocations) read() (*fileState, error) {
+	state := &fileState{Allocations: Allocations{}, Revisions: map[string][]*Revision{}}
+	raw, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, state); err != nil {
+		return nil, fmt.Errorf("couldn't read allocations from %v: %v", f.path, err)
+	}
+	if state.Revisions == nil {
+		state.Revisions = map[string][]*Revision{}
+	}
+
+	for _, allocation := range state.Allocations {
+		if allocation.Cron == "" {
+			continue
+		}
+		allocation.CronExpr, err = cronexpr.Parse(allocation.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron %q for allocation %v/%v in %v: %v", allocation.Cron, allocation.Namespace, allocation.Name, f.path, err)
+		}
+	}
+	return state, nil
+}
+
+// write to a temp file and rename it into place, so neither a
+// crash mid-write nor a server reading mid-write sees half a store.
+// must be called while f is locked
+func (f *FileAllocations) save() error {
+	memory := f.memory
+	memory.lockFor("saving to " + f.path)
+	raw, err := json.Marshal(fileState{
+		Version:     memory.version,
+		Fence:       memory.fence,
+		Lease:       f.lease,
+		Allocations: memory.allocations,
+		Revisions:   memory.revisions,
+	})
+	memory.unlock()
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(f.path), ".allocations")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(raw)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), f.path)
+}
@@ -0,0 +1,214 @@
+package allocations
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tempStorePath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "docket-allocations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "allocations.json"), func() { os.RemoveAll(dir) }
+}
+
+func fileSpec(name string, cron string) *AllocationSpecification {
+	return &AllocationSpecification{
+		Name: name,
+		Cron: cron,
+		Container: CreateContainerOptions{
+			Config: &docker.Config{
+				Image: "busybox:latest",
+			},
+		},
+	}
+}
+
+func TestFileAllocationsShared(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	first, err := File(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := File(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.CreateOrUpdate(fileSpec("foo", "* * * * * *"), Change{Author: "alice"})
+	a, err := second.Get(DefaultNamespace, "foo")
+	if err != nil || a.Cron != "* * * * * *" || a.CronExpr == nil {
+		t.Fatalf("expected the second server to see foo but got %+v, %v", a, err)
+	}
+
+	second.RecordRun(a, &Run{ID: "run-1"})
+	second.Log(a, "hello")
+	if a, _ := first.Get(DefaultNamespace, "foo"); len(a.Runs) != 1 || len(a.Logs) != 1 {
+		t.Errorf("expected the first server to see the second's run and log but got %+v", a)
+	}
+
+	// a version read from one server guards a write through the other
+	spec := fileSpec("foo", "1 * * * * *")
+	spec.ResourceVersion = a.ResourceVersion
+	first.CreateOrUpdate(spec, Change{})
+	second.SetPaused(DefaultNamespace, "foo", true)
+	if _, _, err := second.CreateOrUpdate(spec, Change{}); err == nil {
+		t.Error("expected a write with a version another server has moved on from to conflict")
+	}
+
+	reopened, err := File(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ = reopened.Get(DefaultNamespace, "foo")
+	if a.Cron != "1 * * * * *" || !a.Paused || len(a.Runs) != 1 {
+		t.Errorf("expected foo to survive a reopen but got %+v", a)
+	}
+	if revisions, _ := reopened.Revisions(DefaultNamespace, "foo"); len(revisions) != 2 || revisions[0].Author != "alice" {
+		t.Errorf("expected both revisions to survive a reopen but got %+v", revisions)
+	}
+}
+
+func TestFileAllocationsLease(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+	a, _ := File(path)
+	b, _ := File(path)
+	a.CreateOrUpdate(fileSpec("foo", "* * * * * *"), Change{})
+	foo, _ := a.Get(DefaultNamespace, "foo")
+
+	first, acquired, err := a.TryAcquire("a", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("expected a to acquire a free lease, got %v %v", acquired, err)
+	}
+	if _, acquired, _ := b.TryAcquire("b", time.Minute); acquired {
+		t.Error("expected b not to acquire a lease held by a")
+	}
+	renewed, acquired, _ := a.TryAcquire("a", time.Minute)
+	if !acquired || renewed != first {
+		t.Errorf("expected a to renew with token %v but got %v %v", first, renewed, acquired)
+	}
+
+	a.Release("a")
+	second, acquired, _ := b.TryAcquire("b", time.Minute)
+	if !acquired || second <= first {
+		t.Errorf("expected b to acquire a released lease with a token above %v but got %v %v", first, second, acquired)
+	}
+
+	// taking the lease fences the store, so a's runs are refused
+	// by every server's view of it, not just b's
+	for _, store := range []*FileAllocations{a, b} {
+		if _, ok := store.RecordRun(foo, &Run{ID: "late", FencingToken: first}).(*StaleTokenError); !ok {
+			t.Error("expected the deposed leader's run to be refused")
+		}
+		if _, ok := store.Fence(first).(*StaleTokenError); !ok {
+			t.Error("expected the deposed leader's token to be refused")
+		}
+	}
+	if err := a.RecordRun(foo, &Run{ID: "current", FencingToken: second}); err != nil {
+		t.Errorf("expected the new leader's run to be recorded through any server but got %v", err)
+	}
+
+	// an expired lease is free too
+	b.TryAcquire("b", -time.Second)
+	third, acquired, _ := a.TryAcquire("a", time.Minute)
+	if !acquired || third <= second {
+		t.Errorf("expected a to take over an expired lease with a token above %v but got %v %v", second, third, acquired)
+	}
+}
+
+func TestFileAllocationsWatch(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+	writer, _ := File(path)
+	watched, _ := File(path)
+	watched.pollInterval = 10 * time.Millisecond
+	writer.CreateOrUpdate(fileSpec("foo", "* * * * * *"), Change{})
+	writer.CreateOrUpdate(fileSpec("bar", "* * * * * *"), Change{})
+
+	events, cancel, err := watched.Watch(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if event := <-events; event.Type != Added {
+			t.Errorf("expected the watch to start with the existing allocations but got %+v", event)
+		}
+	}
+
+	foo, _ := writer.Get(DefaultNamespace, "foo")
+	writer.RecordRun(foo, &Run{ID: "run-1"})
+	writer.CreateOrUpdate(fileSpec("baz", "* * * * * *"), Change{})
+	writer.Delete(DefaultNamespace, "bar")
+
+	// the writer's changes may be loaded together, so in any order
+	seen := map[WatchEventType]bool{}
+	for !seen[Added] || !seen[RunRecorded] || !seen[Deleted] {
+		select {
+		case event := <-events:
+			seen[event.Type] = true
+			if event.Type == RunRecorded && event.Run.ID != "run-1" {
+				t.Errorf("expected run-1 to be recorded but got %+v", event.Run)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected Added, RunRecorded and Deleted events from the other server but got %v", seen)
+		}
+	}
+}
+
+func TestFileLock(t *testing.T) {
+	path, cleanup := tempStorePath(t)
+	defer cleanup()
+
+	// a lock left behind by a server that died
+	stale := lockName(path, 7)
+	ioutil.WriteFile(stale, nil, 0600)
+	old := time.Now().Add(-2 * staleLock)
+	os.Chtimes(stale, old, old)
+
+	holding := 0
+	most := 0
+	mutex := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				unlock, err := lockFile(path)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mutex.Lock()
+				holding++
+				if holding > most {
+					most = holding
+				}
+				mutex.Unlock()
+				time.Sleep(time.Millisecond)
+				mutex.Lock()
+				holding--
+				mutex.Unlock()
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if most != 1 {
+		t.Errorf("expected the lock to be held by one at a time but %v held it at once", most)
+	}
+	if generations, _ := lockGenerations(path); len(generations) != 1 || generations[0] <= 7 {
+		t.Errorf("expected only a generation after the stale one to be left but got %v", generations)
+	}
+}
@@ -0,0 +1,115 @@
+package allocations
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how old a lock has to be before we assume
+// its holder died and take it over
+const staleLock = 10 * time.Second
+
+// how long to wait for another server to finish with the store
+const lockTimeout = 5 * time.Second
+
+// Take the lock on the file at path, shared with every other server
+// that can reach it, returning a func that releases it.
+//
+// Each time the lock is taken it gets a new generation, a file named
+// path.lock.N created with O_EXCL, so of the servers that find the
+// newest generation free or stale only one can take the next. Nothing
+// ever removes the newest generation, only the ones before it, so a
+// server that lists the directory late and creates an older generation
+// finds it isn't the newest and gives up. Releasing the lock backdates
+// its file, so a released lock and one whose holder died are both stale
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		generation, latest, err := latestLock(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if latest == nil || time.Since(latest.ModTime()) > staleLock {
+			name := lockName(path, generation+1)
+			file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err == nil {
+				file.Close()
+				if newest, _, err := latestLock(path); err == nil && newest == generation+1 {
+					removeLocksBefore(path, generation+1)
+					return func() { os.Chtimes(name, time.Unix(0, 0), time.Unix(0, 0)) }, nil
+				}
+				os.Remove(name)
+			} else if !os.IsExist(err) {
+				return nil, err
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for the lock on " + path)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func lockName(path string, generation uint64) string {
+	return fmt.Sprintf("%v.lock.%v", path, generation)
+}
+
+// The newest generation of the lock on path and its file, nil if
+// the lock has never been taken
+func latestLock(path string) (uint64, os.FileInfo, error) {
+	for {
+		generations, err := lockGenerations(path)
+		if err != nil || len(generations) == 0 {
+			return 0, nil, err
+		}
+		newest := generations[0]
+		for _, generation := range generations {
+			if generation > newest {
+				newest = generation
+			}
+		}
+		info, err := os.Stat(lockName(path, newest))
+		if os.IsNotExist(err) {
+			// a late server giving up on a generation it just made, look again
+			continue
+		}
+		return newest, info, err
+	}
+}
+
+func lockGenerations(path string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(path) + ".lock."
+	generations := []uint64{}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), prefix) {
+			continue
+		}
+		generation, err := strconv.ParseUint(strings.TrimPrefix(file.Name(), prefix), 10, 64)
+		if err == nil {
+			generations = append(generations, generation)
+		}
+	}
+	return generations, nil
+}
+
+// tidy up the generations before the one we hold
+func removeLocksBefore(path string, generation uint64) {
+	generations, _ := lockGenerations(path)
+	for _, older := range generations {
+		if older < generation {
+			os.Remove(lockName(path, older))
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/gorhill/cronexpr"
 	"log"
+	"reflect"
 	"sync"
 	"time"
 )
@@ -13,16 +14,34 @@ import (
 func InMemory() *InMemoryAllocations {
 	return &InMemoryAllocations{
 		allocations: Allocations{},
+		revisions:   map[string][]*Revision{},
+		watchers:    map[int]chan WatchEvent{},
 		mutex:       &sync.Mutex{},
 	}
 }
 
 type InMemoryAllocations struct {
 	allocations Allocations
+	// revision history keyed by namespace/name
+	revisions map[string][]*Revision
 	// mutex to prevent client calls from modifying Allocations while they
 	// are being inspected and run
 	mutex        *sync.Mutex
 	lockedReason string
+	// the last ResourceVersion handed out, shared by all
+	// allocations so versions only ever go up, even across
+	// a delete and re-create
+	version uint64
+
+	// recent events for watches resuming from a version, and
+	// the newest version that has fallen out of that history
+	history  []WatchEvent
+	expired  uint64
+	watchers map[int]chan WatchEvent
+	nextID   int
+
+	// the newest scheduler fencing token seen
+	fence uint64
 }
 
 func (a *InMemoryAllocations) lockFor(reason string) {
@@ -39,74 +58,410 @@ func (a *InMemoryAllocations) unlock() {
 
 }
 
-func (a *InMemoryAllocations) List() (Allocations, error) {
+func (a *InMemoryAllocations) List(namespace string, selector Selector) (Allocations, error) {
 	a.lockFor("list")
 	defer a.unlock()
-	return a.allocations, nil
+
+	matching := Allocations{}
+	for _, allocation := range a.allocations {
+		if namespace != AllNamespaces && allocation.Namespace != namespace {
+			continue
+		}
+		if selector.Matches(allocation.Labels) {
+			matching = append(matching, snapshot(allocation))
+		}
+	}
+	return matching, nil
 }
-func (a *InMemoryAllocations) Get(name string) (*Allocation, error) {
+
+func (a *InMemoryAllocations) Get(namespace string, name string) (*Allocation, error) {
 	a.lockFor("get")
 	defer a.unlock()
+	index := a.indexOf(namespace, name)
+	if index < 0 {
+		return nil, &NotFoundError{Namespace: namespace, Name: name}
+	}
+	return snapshot(a.allocations[index]), nil
+}
+
+// how many allocations are in the namespace. must be called while locked
+func (a *InMemoryAllocations) count(namespace string) int {
+	count := 0
 	for _, allocation := range a.allocations {
-		if allocation.Name == name {
-			return allocation, nil
+		if allocation.Namespace == namespace {
+			count++
+		}
+	}
+	return count
+}
+
+// must be called while locked. Returns -1 if there's no such allocation
+func (a *InMemoryAllocations) indexOf(namespace string, name string) int {
+	for i, allocation := range a.allocations {
+		if allocation.Namespace == namespace && allocation.Name == name {
+			return i
 		}
 	}
-	return nil, fmt.Errorf("Allocation with name %v not found", name)
+	return -1
 }
 
-func (a *InMemoryAllocations) CreateOrUpdate(newAllocation *AllocationSpecification) (bool, error) {
+func (a *InMemoryAllocations) CreateOrUpdate(newAllocation *AllocationSpecification, change Change) (bool, uint64, error) {
 	a.lockFor("create or update")
 	defer a.unlock()
 
-	for _, allocation := range a.allocations {
-		if allocation.Name == newAllocation.Name {
-			// update
-			allocation.Container = newAllocation.Container
-			allocation.Cron = newAllocation.Cron
-			allocation.CronExpr = cronexpr.MustParse(newAllocation.Cron) // we can MustParse because this was validated during request binding
-			return false, nil
+	namespace := NamespaceOrDefault(newAllocation.Namespace)
+	index := a.indexOf(namespace, newAllocation.Name)
+
+	if change.CreateOnly && index >= 0 {
+		return false, 0, &ConflictError{Namespace: namespace, Name: newAllocation.Name, Actual: a.allocations[index].ResourceVersion}
+	}
+	if newAllocation.ResourceVersion != 0 {
+		conflict := &ConflictError{Namespace: namespace, Name: newAllocation.Name, Expected: newAllocation.ResourceVersion}
+		if index >= 0 {
+			conflict.Actual = a.allocations[index].ResourceVersion
+		}
+		if conflict.Actual != conflict.Expected {
+			return false, 0, conflict
+		}
+	}
+
+	if index < 0 && change.MaxAllocations > 0 && a.count(namespace) >= change.MaxAllocations {
+		return false, 0, &QuotaError{Namespace: namespace, MaxAllocations: change.MaxAllocations}
+	}
+
+	if err := CheckCycles(a.allocations, newAllocation); err != nil {
+		return false, 0, err
+	}
+
+	if at, err := time.Parse(time.RFC3339, newAllocation.At); err == nil && !at.After(time.Now()) {
+		// it would never run, so never complete or expire
+		if index < 0 || !a.allocations[index].At.Equal(at) {
+			return false, 0, &PassedAtError{Namespace: namespace, Name: newAllocation.Name, At: at}
 		}
 	}
 
-	// create a new one
-	a.allocations = append(a.allocations, NewAllocation(newAllocation))
-	return true, nil
+	created := index < 0
+	var allocation *Allocation
+	if created {
+		allocation = NewAllocation(newAllocation)
+		a.allocations = append(a.allocations, allocation)
+	} else {
+		allocation = a.allocations[index]
+		allocation.apply(newAllocation)
+	}
+	allocation.ResourceVersion = a.nextVersion()
+	a.recordRevision(allocation, newAllocation, change)
+	if created {
+		a.emit(Added, allocation, nil)
+	} else {
+		a.emit(Modified, allocation, nil)
+	}
+	return created, allocation.ResourceVersion, nil
 }
 
-func (a *InMemoryAllocations) Delete(name string) error {
-	a.lockFor("delete")
+// Add a revision unless the spec is the same as the latest one.
+// must be called while locked
+func (a *InMemoryAllocations) recordRevision(allocation *Allocation, spec *AllocationSpecification, change Change) {
+	key := revisionKey(allocation.Namespace, allocation.Name)
+	history := a.revisions[key]
+
+	var latest *Revision
+	if len(history) > 0 {
+		latest = history[len(history)-1]
+	}
+	revision := newRevision(latest, spec, change)
+	if latest != nil && revision.Diff == "" {
+		return
+	}
+
+	a.revisions[key] = append(history, revision)
+	allocation.Revision = revision.Number
+}
+
+func revisionKey(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+func (a *InMemoryAllocations) Revisions(namespace string, name string) ([]*Revision, error) {
+	a.lockFor("revisions")
 	defer a.unlock()
-	var index int
-	found := false
-	for i, allocation := range a.allocations {
-		if allocation.Name == name {
-			index = i
-			found = true
-			break
-		}
+	if a.indexOf(namespace, name) < 0 {
+		return nil, &NotFoundError{Namespace: namespace, Name: name}
 	}
+	// copy so callers can't append to the history
+	return append([]*Revision{}, a.revisions[revisionKey(namespace, name)]...), nil
+}
+
+// must be called while locked
+func (a *InMemoryAllocations) nextVersion() uint64 {
+	a.version++
+	return a.version
+}
 
-	if !found {
-		return fmt.Errorf("Allocation with name %v not found", name)
+func (a *InMemoryAllocations) Delete(namespace string, name string) error {
+	a.lockFor("delete")
+	defer a.unlock()
+	index := a.indexOf(namespace, name)
+	if index < 0 {
+		return &NotFoundError{Namespace: namespace, Name: name}
 	}
 
+	deleted := a.allocations[index]
 	a.removeAt(index)
+	delete(a.revisions, revisionKey(namespace, name))
+	a.nextVersion()
+	a.emit(Deleted, deleted, nil)
+	return nil
+}
+
+func (a *InMemoryAllocations) SetPaused(namespace string, name string, paused bool) error {
+	a.lockFor(fmt.Sprintf("pausing %v/%v", namespace, name))
+	defer a.unlock()
+	index := a.indexOf(namespace, name)
+	if index < 0 {
+		return &NotFoundError{Namespace: namespace, Name: name}
+	}
+	a.allocations[index].Paused = paused
+	a.allocations[index].ResourceVersion = a.nextVersion()
+	a.emit(Modified, a.allocations[index], nil)
 	return nil
 }
 
 func (a *InMemoryAllocations) Log(allocation *Allocation, events ...interface{}) error {
-	a.lockFor(fmt.Sprintf("logging to %v", allocation.Name))
+	a.lockFor(fmt.Sprintf("logging to %v/%v", allocation.Namespace, allocation.Name))
+	defer a.unlock()
+
+	index := a.indexOf(allocation.Namespace, allocation.Name)
+	if index < 0 {
+		return fmt.Errorf("allocation %v not found in namespace %v", allocation.Name, allocation.Namespace)
+	}
+	found := a.allocations[index]
+	found.Logs = append(found.Logs, fmt.Sprintf("%v, %v", time.Now(), events))
+	// like runs, logs move the store's version on but
+	// aren't a change to the allocation's spec
+	a.nextVersion()
+	a.emit(Modified, found, nil)
+	return nil
+}
+
+func (a *InMemoryAllocations) RecordRun(allocation *Allocation, run *Run) error {
+	a.lockFor(fmt.Sprintf("recording run %v of %v/%v", run.ID, allocation.Namespace, allocation.Name))
+	defer a.unlock()
+
+	index := a.indexOf(allocation.Namespace, allocation.Name)
+	if index < 0 {
+		return fmt.Errorf("allocation %v not found in namespace %v", allocation.Name, allocation.Namespace)
+	}
+	if err := a.checkFence(run.FencingToken); err != nil {
+		return err
+	}
+	found := a.allocations[index]
+	found.Runs = append(found.Runs, run)
+	// runs move the store's version on, so watches can resume after
+	// them, but aren't a change to the allocation's spec
+	a.nextVersion()
+	a.emit(RunRecorded, found, run)
+	return nil
+}
+
+func (a *InMemoryAllocations) Compact(defaults Retention, now time.Time) (Compaction, error) {
+	a.lockFor("compaction")
+	defer a.unlock()
+
+	total := Compaction{}
+	for _, allocation := range a.allocations {
+		removed := defaults.Apply(allocation, now)
+		total.Runs += removed.Runs
+		total.Logs += removed.Logs
+		if removed.Runs > 0 || removed.Logs > 0 {
+			a.nextVersion()
+			a.emit(Modified, allocation, nil)
+		}
+	}
+	return total, nil
+}
+
+func (a *InMemoryAllocations) Watch(sinceVersion uint64) (<-chan WatchEvent, func(), error) {
+	a.lockFor("watch")
+	defer a.unlock()
+
+	backlog := []WatchEvent{}
+	if sinceVersion == 0 {
+		for _, allocation := range a.allocations {
+			backlog = append(backlog, WatchEvent{Type: Added, ResourceVersion: a.version, Allocation: eventSnapshot(allocation)})
+		}
+	} else {
+		if sinceVersion < a.expired {
+			return nil, nil, ErrWatchExpired
+		}
+		for _, event := range a.history {
+			if event.ResourceVersion > sinceVersion {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	events := make(chan WatchEvent, len(backlog)+watchBuffer)
+	for _, event := range backlog {
+		events <- event
+	}
+
+	id := a.nextID
+	a.nextID++
+	a.watchers[id] = events
+
+	cancel := func() {
+		a.lockFor("cancel watch")
+		defer a.unlock()
+		a.stopWatching(id)
+	}
+	return events, cancel, nil
+}
+
+// Record an event and send it to every watcher, dropping
+// watchers that have fallen behind. must be called while locked
+func (a *InMemoryAllocations) emit(eventType WatchEventType, allocation *Allocation, run *Run) {
+	event := WatchEvent{Type: eventType, ResourceVersion: a.version, Allocation: eventSnapshot(allocation), Run: run}
+
+	a.history = append(a.history, event)
+	if len(a.history) > watchHistory {
+		a.expired = a.history[0].ResourceVersion
+		a.history = a.history[1:]
+	}
+
+	for id, events := range a.watchers {
+		select {
+		case events <- event:
+		default:
+			log.Printf("Watcher %v fell behind at version %v, closing it", id, event.ResourceVersion)
+			a.stopWatching(id)
+		}
+	}
+}
+
+// must be called while locked
+func (a *InMemoryAllocations) stopWatching(id int) {
+	if events, ok := a.watchers[id]; ok {
+		close(events)
+		delete(a.watchers, id)
+	}
+}
+
+func (a *InMemoryAllocations) Restore(record *Record) error {
+	if err := record.Validate(); err != nil {
+		return err
+	}
+	restored := *record.Allocation
+	restored.CronExpr = nil
+	if restored.Cron != "" {
+		restored.CronExpr = cronexpr.MustParse(restored.Cron)
+	}
+
+	a.lockFor(fmt.Sprintf("restoring %v/%v", restored.Namespace, restored.Name))
 	defer a.unlock()
 
-	for _, a := range a.allocations {
-		if a.Name == allocation.Name {
-			a.Logs = append(allocation.Logs, fmt.Sprintf("%v, %v", time.Now(), events))
-			return nil
+	// versions from another server mean nothing here
+	restored.ResourceVersion = a.nextVersion()
+	a.revisions[revisionKey(restored.Namespace, restored.Name)] = append([]*Revision{}, record.Revisions...)
+
+	index := a.indexOf(restored.Namespace, restored.Name)
+	if index >= 0 {
+		a.allocations[index] = &restored
+		a.emit(Modified, &restored, nil)
+	} else {
+		a.allocations = append(a.allocations, &restored)
+		a.emit(Added, &restored, nil)
+	}
+	return nil
+}
+
+// Replace everything with what FileAllocations loaded, which other servers
+// may have changed, and send watchers an event for each change. Events for
+// changes made elsewhere all have the version they were loaded at. Nothing
+// is replaced if the version hasn't moved on, unless force is set
+func (a *InMemoryAllocations) replace(state *fileState, force bool) {
+	a.lockFor("loading")
+	defer a.unlock()
+
+	a.fence = state.Fence
+	if state.Version == a.version && !force {
+		return
+	}
+
+	previous := a.allocations
+	before := map[string]*Allocation{}
+	for _, allocation := range previous {
+		before[revisionKey(allocation.Namespace, allocation.Name)] = allocation
+	}
+	a.allocations = state.Allocations
+	a.revisions = state.Revisions
+	a.version = state.Version
+
+	after := map[string]bool{}
+	for _, allocation := range a.allocations {
+		key := revisionKey(allocation.Namespace, allocation.Name)
+		after[key] = true
+		a.emitChanges(before[key], allocation)
+	}
+	for _, allocation := range previous {
+		if !after[revisionKey(allocation.Namespace, allocation.Name)] {
+			a.emit(Deleted, allocation, nil)
+		}
+	}
+}
+
+// The events between two loaded states of an allocation, old is nil if it's
+// new. Runs are told apart by ID. must be called while locked
+func (a *InMemoryAllocations) emitChanges(old *Allocation, allocation *Allocation) {
+	if old == nil {
+		a.emit(Added, allocation, nil)
+		return
+	}
+	if old.ResourceVersion != allocation.ResourceVersion {
+		a.emit(Modified, allocation, nil)
+		return
+	}
+
+	recorded := map[string]bool{}
+	for _, run := range old.Runs {
+		recorded[run.ID] = true
+	}
+	newRuns := 0
+	for _, run := range allocation.Runs {
+		if !recorded[run.ID] {
+			a.emit(RunRecorded, allocation, run)
+			newRuns++
 		}
 	}
+	// logged to or compacted
+	if len(old.Runs)+newRuns != len(allocation.Runs) || !reflect.DeepEqual(old.Logs, allocation.Logs) {
+		a.emit(Modified, allocation, nil)
+	}
+}
+
+// Whether anyone is watching
+func (a *InMemoryAllocations) watched() bool {
+	a.lockFor("checking for watchers")
+	defer a.unlock()
+	return len(a.watchers) > 0
+}
+
+func (a *InMemoryAllocations) Fence(token uint64) error {
+	a.lockFor("fence")
+	defer a.unlock()
+	return a.checkFence(token)
+}
 
-	return fmt.Errorf("allocation %v not found", allocation.Name)
+// must be called while locked
+func (a *InMemoryAllocations) checkFence(token uint64) error {
+	if token == 0 {
+		return nil
+	}
+	if token < a.fence {
+		return &StaleTokenError{Token: token, Current: a.fence}
+	}
+	a.fence = token
+	return nil
 }
 
 // Stolen from http://stackoverflow.com/questions/37334119/how-to-delete-an-element-from-array-in-golang
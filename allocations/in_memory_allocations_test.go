@@ -2,7 +2,9 @@ package allocations
 
 import (
 	"github.com/fsouza/go-dockerclient"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestInMemory(t *testing.T) {
@@ -16,9 +18,9 @@ func TestInMemory(t *testing.T) {
 				Image: "busybox:latest",
 			},
 		},
-	})
+	}, Change{})
 
-	a, _ := allocations.Get("foo")
+	a, _ := allocations.Get(DefaultNamespace, "foo")
 	if a.Cron != "* * * * * *" {
 		t.Errorf("expected cron to be \"* * * * * * \" but was %v", a.Cron)
 	}
@@ -31,12 +33,16 @@ func TestInMemory(t *testing.T) {
 				Image: "busybox:latest",
 			},
 		},
-	})
+	}, Change{})
+	if a.Cron != "* * * * * *" {
+		t.Errorf("expected the allocation Get returned not to change, cron was %v", a.Cron)
+	}
+	a, _ = allocations.Get(DefaultNamespace, "foo")
 	if a.Cron != "1 * * * * *" {
 		t.Errorf("expected cron to be \"1 * * * * * \" but was %v", a.Cron)
 	}
 
-	list, _ := allocations.List()
+	list, _ := allocations.List(DefaultNamespace, Everything())
 
 	if len(list) != 1 {
 		t.Errorf("expected list to return exactly 1 item but returned %v", len(list))
@@ -46,18 +52,418 @@ func TestInMemory(t *testing.T) {
 		t.Errorf("expected list contain 1 item with anme \"foo\" but name was %v", list[0].Name)
 	}
 
-	err := allocations.Delete("bar")
+	err := allocations.Delete(DefaultNamespace, "bar")
 
 	if err == nil {
 		t.Error("Expected err on deleting non existent allocation bar")
 	}
 
-	allocations.Delete("foo")
+	allocations.Delete(DefaultNamespace, "foo")
 
-	list, _ = allocations.List()
+	list, _ = allocations.List(DefaultNamespace, Everything())
 
 	if len(list) != 0 {
 		t.Errorf("expected list to return 0 items but returned %v", len(list))
 	}
 
 }
+
+func TestInMemoryNamespaces(t *testing.T) {
+	allocations := InMemory()
+
+	for _, namespace := range []string{"", "data"} {
+		allocations.CreateOrUpdate(&AllocationSpecification{
+			Name:      "foo",
+			Namespace: namespace,
+			Cron:      "* * * * * *",
+			Container: CreateContainerOptions{
+				Config: &docker.Config{
+					Image: "busybox:latest",
+				},
+			},
+		}, Change{})
+	}
+
+	all, _ := allocations.List(AllNamespaces, Everything())
+	if len(all) != 2 {
+		t.Errorf("expected two allocations named foo in different namespaces but got %v", len(all))
+	}
+
+	data, _ := allocations.List("data", Everything())
+	if len(data) != 1 || data[0].Namespace != "data" {
+		t.Errorf("expected exactly one allocation in namespace data but got %v", data)
+	}
+
+	a, _ := allocations.Get(DefaultNamespace, "foo")
+	if a == nil || a.Namespace != DefaultNamespace {
+		t.Errorf("expected allocation without a namespace to be in %v", DefaultNamespace)
+	}
+
+	allocations.Delete("data", "foo")
+	_, err := allocations.Get(DefaultNamespace, "foo")
+	if err != nil {
+		t.Errorf("expected deleting data/foo to leave default/foo alone")
+	}
+}
+
+func TestInMemorySelector(t *testing.T) {
+	allocations := InMemory()
+
+	for name, team := range map[string]string{"foo": "data", "bar": "web"} {
+		allocations.CreateOrUpdate(&AllocationSpecification{
+			Name:   name,
+			Cron:   "* * * * * *",
+			Labels: map[string]string{"team": team},
+			Container: CreateContainerOptions{
+				Config: &docker.Config{
+					Image: "busybox:latest",
+				},
+			},
+		}, Change{})
+	}
+
+	selector, _ := ParseSelector("team=data")
+	list, _ := allocations.List(DefaultNamespace, selector)
+	if len(list) != 1 || list[0].Name != "foo" {
+		t.Errorf("expected only foo to match team=data but got %v", list)
+	}
+
+	allocations.SetPaused(DefaultNamespace, "foo", true)
+	a, _ := allocations.Get(DefaultNamespace, "foo")
+	if !a.Paused {
+		t.Error("expected foo to be paused")
+	}
+}
+
+func TestInMemoryResourceVersion(t *testing.T) {
+	allocations := InMemory()
+	spec := &AllocationSpecification{
+		Name: "foo",
+		Cron: "* * * * * *",
+		Container: CreateContainerOptions{
+			Config: &docker.Config{
+				Image: "busybox:latest",
+			},
+		},
+	}
+
+	spec.ResourceVersion = 1
+	if _, _, err := allocations.CreateOrUpdate(spec, Change{}); err == nil {
+		t.Error("expected a conflict updating an allocation that doesn't exist")
+	}
+
+	spec.ResourceVersion = 0
+	allocations.CreateOrUpdate(spec, Change{})
+	a, _ := allocations.Get(DefaultNamespace, "foo")
+	first := a.ResourceVersion
+	if first == 0 {
+		t.Fatal("expected a new allocation to have a ResourceVersion")
+	}
+
+	spec.ResourceVersion = first
+	spec.Cron = "1 * * * * *"
+	_, version, err := allocations.CreateOrUpdate(spec, Change{})
+	if err != nil {
+		t.Errorf("expected update at the current version to succeed but got %v", err)
+	}
+	a, _ = allocations.Get(DefaultNamespace, "foo")
+	if a.ResourceVersion <= first {
+		t.Errorf("expected ResourceVersion to increase from %v but was %v", first, a.ResourceVersion)
+	}
+	if version != a.ResourceVersion {
+		t.Errorf("expected the write to return version %v but got %v", a.ResourceVersion, version)
+	}
+
+	// a second writer still holding the first version
+	spec.Cron = "2 * * * * *"
+	_, _, err = allocations.CreateOrUpdate(spec, Change{})
+	conflict, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("expected a *ConflictError for a stale version but got %v", err)
+	}
+	a, _ = allocations.Get(DefaultNamespace, "foo")
+	if conflict.Expected != first || conflict.Actual != a.ResourceVersion {
+		t.Errorf("expected conflict between %v and %v but got %+v", first, a.ResourceVersion, conflict)
+	}
+	if a.Cron != "1 * * * * *" {
+		t.Errorf("expected a conflicting write not to change the allocation, cron was %v", a.Cron)
+	}
+
+	// a writer that expected to create it
+	spec.ResourceVersion = 0
+	_, _, err = allocations.CreateOrUpdate(spec, Change{CreateOnly: true})
+	if conflict, ok := err.(*ConflictError); !ok || conflict.Expected != 0 || conflict.Actual != a.ResourceVersion {
+		t.Errorf("expected a *ConflictError creating an allocation that exists but got %v", err)
+	}
+	a, _ = allocations.Get(DefaultNamespace, "foo")
+	if a.Cron != "1 * * * * *" {
+		t.Errorf("expected a create only write not to change the allocation, cron was %v", a.Cron)
+	}
+}
+
+func TestInMemoryRevisions(t *testing.T) {
+	allocations := InMemory()
+	spec := &AllocationSpecification{
+		Name: "foo",
+		Cron: "* * * * * *",
+		Container: CreateContainerOptions{
+			Config: &docker.Config{
+				Image: "busybox:latest",
+			},
+		},
+	}
+
+	allocations.CreateOrUpdate(spec, Change{Author: "alice"})
+	spec.Cron = "1 * * * * *"
+	allocations.CreateOrUpdate(spec, Change{Author: "bob"})
+	// pushing the same spec again isn't a new revision
+	allocations.CreateOrUpdate(spec, Change{Author: "bob"})
+
+	revisions, _ := allocations.Revisions(DefaultNamespace, "foo")
+	if len(revisions) != 2 {
+		t.Fatalf("expected 2 revisions but got %v", len(revisions))
+	}
+	if revisions[0].Number != 1 || revisions[0].Author != "alice" || revisions[0].Spec.Cron != "* * * * * *" {
+		t.Errorf("unexpected first revision %+v", revisions[0])
+	}
+	if revisions[1].Number != 2 || revisions[1].Author != "bob" {
+		t.Errorf("unexpected second revision %+v", revisions[1])
+	}
+	if !strings.Contains(revisions[1].Diff, `-   "Cron": "* * * * * *",`) || !strings.Contains(revisions[1].Diff, `+   "Cron": "1 * * * * *",`) {
+		t.Errorf("expected diff to show the cron change but was\n%v", revisions[1].Diff)
+	}
+
+	a, _ := allocations.Get(DefaultNamespace, "foo")
+	if a.Revision != 2 {
+		t.Errorf("expected allocation to be at revision 2 but was %v", a.Revision)
+	}
+
+	// the first revision's spec is a copy, it doesn't change with the allocation
+	spec.Cron = "2 * * * * *"
+	if revisions[0].Spec.Cron != "* * * * * *" {
+		t.Errorf("expected revision 1 to be unchanged but cron was %v", revisions[0].Spec.Cron)
+	}
+
+	// and so are the pointers, maps and slices in it
+	spec.Container.Config.Image = "alpine:latest"
+	a.Container.Config.Cmd = append(a.Container.Config.Cmd, "echo")
+	for _, revision := range revisions {
+		if config := revision.Spec.Container.Config; config.Image != "busybox:latest" || len(config.Cmd) != 0 {
+			t.Errorf("expected revision %v's container to be unchanged but was %+v", revision.Number, config)
+		}
+	}
+}
+
+func TestInMemoryWatch(t *testing.T) {
+	allocations := InMemory()
+	spec := &AllocationSpecification{
+		Name: "foo",
+		Cron: "* * * * * *",
+		Container: CreateContainerOptions{
+			Config: &docker.Config{
+				Image: "busybox:latest",
+			},
+		},
+	}
+	allocations.CreateOrUpdate(spec, Change{})
+
+	events, cancel, err := allocations.Watch(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := <-events
+	if existing.Type != Added || existing.Allocation.Name != "foo" {
+		t.Errorf("expected watch from 0 to start with foo being added but got %+v", existing)
+	}
+
+	spec.Cron = "1 * * * * *"
+	allocations.CreateOrUpdate(spec, Change{})
+	a, _ := allocations.Get(DefaultNamespace, "foo")
+	allocations.RecordRun(a, &Run{ID: "run-1"})
+	allocations.Delete(DefaultNamespace, "foo")
+
+	expected := []WatchEventType{Modified, RunRecorded, Deleted}
+	var last WatchEvent
+	for _, eventType := range expected {
+		event := <-events
+		if event.Type != eventType {
+			t.Errorf("expected %v event but got %v", eventType, event.Type)
+		}
+		if event.ResourceVersion <= last.ResourceVersion {
+			t.Errorf("expected versions to increase but got %v after %v", event.ResourceVersion, last.ResourceVersion)
+		}
+		last = event
+	}
+	if last.Allocation.Cron != "1 * * * * *" {
+		t.Errorf("expected the deleted allocation's last state but cron was %v", last.Allocation.Cron)
+	}
+
+	cancel()
+	if _, open := <-events; open {
+		t.Error("expected cancel to close the channel")
+	}
+
+	// resuming replays only what happened after the version
+	resumed, cancel, _ := allocations.Watch(existing.ResourceVersion + 1)
+	defer cancel()
+	if event := <-resumed; event.Type != RunRecorded {
+		t.Errorf("expected a resumed watch to start with the run but got %v", event.Type)
+	}
+}
+
+func TestInMemoryWatchLogsAndCompaction(t *testing.T) {
+	allocations := InMemory()
+	allocations.CreateOrUpdate(&AllocationSpecification{
+		Name: "foo",
+		Cron: "* * * * * *",
+		Container: CreateContainerOptions{
+			Config: &docker.Config{
+				Image: "busybox:latest",
+			},
+		},
+	}, Change{})
+	a, _ := allocations.Get(DefaultNamespace, "foo")
+	version := a.ResourceVersion
+
+	events, cancel, _ := allocations.Watch(0)
+	defer cancel()
+	added := <-events
+
+	allocations.Log(a, "hello")
+	logged := <-events
+	if logged.Type != Modified || len(logged.Allocation.Logs) != 0 {
+		t.Errorf("expected a Modified event without the logs but got %+v", logged)
+	}
+	if stored, _ := allocations.Get(DefaultNamespace, "foo"); len(stored.Logs) != 1 {
+		t.Errorf("expected the log to be stored but got %v", stored.Logs)
+	}
+	if logged.Allocation.ResourceVersion != version {
+		t.Errorf("expected logging not to change the allocation's ResourceVersion but got %v", logged.Allocation.ResourceVersion)
+	}
+
+	allocations.Compact(Retention{KeepLogs: 1}, time.Now())
+	allocations.Log(a, "again")
+	<-events
+	allocations.Compact(Retention{KeepLogs: 1}, time.Now())
+	compacted := <-events
+	if compacted.Type != Modified {
+		t.Errorf("expected a Modified event after compaction but got %+v", compacted)
+	}
+
+	// events carry a run without the rest of the history
+	allocations.RecordRun(a, &Run{ID: "run-1"})
+	recorded := <-events
+	if recorded.Run == nil || recorded.Run.ID != "run-1" || len(recorded.Allocation.Runs) != 0 {
+		t.Errorf("expected a RunRecorded event with just its run but got %+v", recorded)
+	}
+
+	// events are snapshots, later changes don't reach them
+	allocations.CreateOrUpdate(&AllocationSpecification{
+		Name: "foo",
+		Cron: "* * * * * *",
+		Container: CreateContainerOptions{
+			Config: &docker.Config{
+				Image: "alpine:latest",
+			},
+		},
+	}, Change{})
+	if added.Allocation.Container.Config.Image != "busybox:latest" {
+		t.Errorf("expected the Added event's allocation to be unchanged but got %+v", added.Allocation)
+	}
+}
+
+func TestInMemoryFence(t *testing.T) {
+	allocations := InMemory()
+	allocations.CreateOrUpdate(&AllocationSpecification{
+		Name: "foo",
+		Cron: "* * * * * *",
+		Container: CreateContainerOptions{
+			Config: &docker.Config{
+				Image: "busybox:latest",
+			},
+		},
+	}, Change{})
+	a, _ := allocations.Get(DefaultNamespace, "foo")
+
+	if err := allocations.RecordRun(a, &Run{ID: "old-leader", FencingToken: 1}); err != nil {
+		t.Errorf("expected first token to be accepted but got %v", err)
+	}
+	if err := allocations.Fence(2); err != nil {
+		t.Errorf("expected newer token to be accepted but got %v", err)
+	}
+
+	if _, ok := allocations.Fence(1).(*StaleTokenError); !ok {
+		t.Error("expected deposed leader's token to be refused")
+	}
+	if _, ok := allocations.RecordRun(a, &Run{ID: "late", FencingToken: 1}).(*StaleTokenError); !ok {
+		t.Error("expected deposed leader's run to be refused")
+	}
+	if err := allocations.RecordRun(a, &Run{ID: "manual"}); err != nil {
+		t.Errorf("expected unfenced run to be accepted but got %v", err)
+	}
+	a, _ = allocations.Get(DefaultNamespace, "foo")
+	if len(a.Runs) != 2 {
+		t.Errorf("expected 2 runs recorded but got %v", len(a.Runs))
+	}
+}
+
+func TestInMemoryMaxAllocations(t *testing.T) {
+	allocations := InMemory()
+	spec := func(name string) *AllocationSpecification {
+		return &AllocationSpecification{
+			Name: name,
+			Cron: "* * * * * *",
+			Container: CreateContainerOptions{
+				Config: &docker.Config{Image: "busybox:latest"},
+			},
+		}
+	}
+	limited := Change{MaxAllocations: 1}
+
+	if _, _, err := allocations.CreateOrUpdate(spec("foo"), limited); err != nil {
+		t.Fatalf("expected the first allocation to fit the quota but got %v", err)
+	}
+	if _, _, err := allocations.CreateOrUpdate(spec("foo"), limited); err != nil {
+		t.Errorf("expected updates to be allowed at the quota but got %v", err)
+	}
+	_, _, err := allocations.CreateOrUpdate(spec("bar"), limited)
+	if _, ok := err.(*QuotaError); !ok {
+		t.Errorf("expected a *QuotaError creating a second allocation but got %v", err)
+	}
+	other := spec("bar")
+	other.Namespace = "other"
+	if _, _, err := allocations.CreateOrUpdate(other, limited); err != nil {
+		t.Errorf("expected the quota to only count its own namespace but got %v", err)
+	}
+}
+
+func TestInMemoryPassedAt(t *testing.T) {
+	allocations := InMemory()
+	spec := func(at time.Time) *AllocationSpecification {
+		return &AllocationSpecification{
+			Name: "once",
+			At:   at.Format(time.RFC3339),
+			Container: CreateContainerOptions{
+				Config: &docker.Config{Image: "busybox:latest"},
+			},
+		}
+	}
+	past := time.Now().Add(-time.Hour)
+
+	if _, _, err := allocations.CreateOrUpdate(spec(past), Change{}); err == nil {
+		t.Error("expected creating an allocation with a passed At to be refused")
+	}
+	if _, _, err := allocations.CreateOrUpdate(spec(time.Now().Add(time.Hour)), Change{}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := allocations.CreateOrUpdate(spec(past), Change{})
+	if _, ok := err.(*PassedAtError); !ok {
+		t.Errorf("expected a *PassedAtError changing At to a passed time but got %v", err)
+	}
+
+	// once the At has passed the allocation can still be pushed as it is
+	allocations.allocations[0].At, _ = time.Parse(time.RFC3339, past.Format(time.RFC3339))
+	if _, _, err := allocations.CreateOrUpdate(spec(past), Change{}); err != nil {
+		t.Errorf("expected pushing an unchanged, passed At again to be fine but got %v", err)
+	}
+}
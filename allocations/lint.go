@@ -0,0 +1,84 @@
+package allocations
+
+import (
+	"fmt"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorhill/cronexpr"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// the repository part of an image reference, with an optional registry host
+var repositoryPattern = regexp.MustCompile(`^(?:[a-zA-Z0-9.-]+(?::[0-9]+)?/)?[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+var tagPattern = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+// Things in the specification the server accepts but that are probably
+// mistakes, keyed by field. Only meaningful once Check finds no problems
+func (allocation AllocationSpecification) Lint() map[string]string {
+	warnings := map[string]string{}
+
+	if allocation.Cron != "" {
+		if expr, err := cronexpr.Parse(allocation.Cron); err == nil {
+			next := expr.NextN(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), 2)
+			if len(next) == 2 && next[1].Sub(next[0]) <= time.Second {
+				warnings["Cron"] = "Cron fires every second"
+			}
+		}
+	}
+	if at, err := time.Parse(time.RFC3339, allocation.At); err == nil && !at.After(time.Now()) {
+		warnings["At"] = "At has already passed, so the server refuses it unless the allocation already has this At"
+	}
+	if every, err := time.ParseDuration(allocation.Every); err == nil && every == time.Second {
+		warnings["Every"] = "Every fires every second"
+	}
+
+	if len(allocation.Steps) > 0 {
+		// step containers are removed with the run's workspace
+		for i, step := range allocation.Steps {
+			lintContainer(warnings, fmt.Sprintf("Steps[%v].Container", i), step.Container)
+		}
+		return warnings
+	}
+
+	lintContainer(warnings, "Container", allocation.Container)
+	if allocation.Container.HostConfig == nil || !allocation.Container.HostConfig.AutoRemove {
+		warnings["Container.HostConfig.AutoRemove"] = "AutoRemove isn't set, so exited containers are left behind"
+	}
+	return warnings
+}
+
+func lintContainer(warnings map[string]string, field string, options CreateContainerOptions) {
+	if options.Config == nil {
+		return
+	}
+
+	image := options.Config.Image
+	if problem := lintImage(image); problem != "" {
+		warnings[field+".Config.Image"] = problem
+	}
+
+	host := options.HostConfig
+	if options.Config.Memory == 0 && (host == nil || host.Memory == 0 && host.NanoCPUs == 0 && host.CPUQuota == 0 && host.CPUShares == 0) {
+		warnings[field+".HostConfig"] = "no memory or cpu limits are set"
+	}
+}
+
+func lintImage(image string) string {
+	if image == "" {
+		return ""
+	}
+	repository, tag := docker.ParseRepositoryTag(image)
+	if !repositoryPattern.MatchString(repository) || tag != "" && !tagPattern.MatchString(tag) || strings.HasSuffix(image, ":") {
+		return fmt.Sprintf("%v isn't a valid image reference", image)
+	}
+	if strings.Contains(image, "@") {
+		// pinned to a digest
+		return ""
+	}
+	if tag == "" || tag == "latest" {
+		return fmt.Sprintf("%v uses the latest tag, pin a version so runs are repeatable", image)
+	}
+	return ""
+}
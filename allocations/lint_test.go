@@ -0,0 +1,51 @@
+package allocations
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	spec := AllocationSpecification{
+		Name: "report",
+		Cron: "0 0 * * * * *",
+		Container: CreateContainerOptions{
+			Config:     &docker.Config{Image: "quay.io/acme/report:1.4.2"},
+			HostConfig: &docker.HostConfig{AutoRemove: true, Memory: 256 << 20},
+		},
+	}
+	if warnings := spec.Lint(); len(warnings) != 0 {
+		t.Errorf("expected no warnings but got %v", warnings)
+	}
+
+	spec.Cron = "* * * * * * *"
+	spec.Container.Config.Image = "busybox"
+	spec.Container.HostConfig = nil
+	expected := map[string]string{
+		"Cron":                            "Cron fires every second",
+		"Container.Config.Image":          "busybox uses the latest tag, pin a version so runs are repeatable",
+		"Container.HostConfig":            "no memory or cpu limits are set",
+		"Container.HostConfig.AutoRemove": "AutoRemove isn't set, so exited containers are left behind",
+	}
+	if warnings := spec.Lint(); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("expected %v but got %v", expected, warnings)
+	}
+}
+
+func TestLintImage(t *testing.T) {
+	for image, expected := range map[string]string{
+		"alpine:3.4":                     "",
+		"localhost:5000/team/job:v2":     "",
+		"alpine@sha256:0123456789abcdef": "",
+		"alpine:latest":                  "alpine:latest uses the latest tag, pin a version so runs are repeatable",
+		"localhost:5000/job":             "localhost:5000/job uses the latest tag, pin a version so runs are repeatable",
+		"Alpine:3.4":                     "Alpine:3.4 isn't a valid image reference",
+		"alpine:3.4 --rm":                "alpine:3.4 --rm isn't a valid image reference",
+		"alpine:":                        "alpine: isn't a valid image reference",
+	} {
+		if problem := lintImage(image); problem != expected {
+			t.Errorf("%v: expected %q but got %q", image, expected, problem)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package allocations
+
+import (
+	"time"
+)
+
+// How much history to keep for an allocation. Zero means no limit.
+// Set server-wide, and per allocation to override the server's settings
+type Retention struct {
+	// Keep at most this many runs
+	KeepRuns int `json:"KeepRuns,omitempty" yaml:"KeepRuns,omitempty"`
+	// Drop successful runs older than this many days
+	KeepDays int `json:"KeepDays,omitempty" yaml:"KeepDays,omitempty"`
+	// Drop failed and timed out runs older than this many days,
+	// usually longer than KeepDays so failures can still be investigated
+	KeepFailureDays int `json:"KeepFailureDays,omitempty" yaml:"KeepFailureDays,omitempty"`
+	// Keep at most this many log lines
+	KeepLogs int `json:"KeepLogs,omitempty" yaml:"KeepLogs,omitempty"`
+}
+
+// What a compaction removed
+type Compaction struct {
+	Runs int
+	Logs int
+}
+
+// The settings that apply to an allocation, its own where
+// set and the server's defaults otherwise
+func (retention Retention) For(allocation *Allocation) Retention {
+	if allocation.Retention == nil {
+		return retention
+	}
+	own := *allocation.Retention
+	if own.KeepRuns == 0 {
+		own.KeepRuns = retention.KeepRuns
+	}
+	if own.KeepDays == 0 {
+		own.KeepDays = retention.KeepDays
+	}
+	if own.KeepFailureDays == 0 {
+		own.KeepFailureDays = retention.KeepFailureDays
+	}
+	if own.KeepLogs == 0 {
+		own.KeepLogs = retention.KeepLogs
+	}
+	return own
+}
+
+// The runs to keep, oldest first as they're stored. The latest run and the
+// latest success are always kept, so recovery and overdue detection still
+// work for allocations that haven't run for longer than the retention period
+func (retention Retention) PruneRuns(runs []*Run, now time.Time) []*Run {
+	latestSuccess := -1
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Succeeded() {
+			latestSuccess = i
+			break
+		}
+	}
+
+	kept := []*Run{}
+	for i, run := range runs {
+		if i == len(runs)-1 || i == latestSuccess || retention.keepRun(run, len(runs)-1-i, now) {
+			kept = append(kept, run)
+		}
+	}
+	return kept
+}
+
+// newer is how many runs there are after this one
+func (retention Retention) keepRun(run *Run, newer int, now time.Time) bool {
+	if retention.KeepRuns > 0 && newer >= retention.KeepRuns {
+		return false
+	}
+
+	days := retention.KeepDays
+	if !run.Succeeded() {
+		days = retention.KeepFailureDays
+	}
+	return days <= 0 || now.Sub(run.FinishedAt) <= time.Duration(days)*24*time.Hour
+}
+
+// The most recent log lines to keep
+func (retention Retention) PruneLogs(logs []interface{}) []interface{} {
+	if retention.KeepLogs <= 0 || len(logs) <= retention.KeepLogs {
+		return logs
+	}
+	return append([]interface{}{}, logs[len(logs)-retention.KeepLogs:]...)
+}
+
+// Prune one allocation's history in place, returning what was removed.
+// Stores call this on each allocation from Compact, while locked
+func (retention Retention) Apply(allocation *Allocation, now time.Time) Compaction {
+	settings := retention.For(allocation)
+	runs := settings.PruneRuns(allocation.Runs, now)
+	logs := settings.PruneLogs(allocation.Logs)
+
+	removed := Compaction{Runs: len(allocation.Runs) - len(runs), Logs: len(allocation.Logs) - len(logs)}
+	allocation.Runs = runs
+	allocation.Logs = logs
+	return removed
+}
@@ -0,0 +1,69 @@
+package allocations
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPruneRuns(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	run := func(id string, age time.Duration, status RunStatus) *Run {
+		return &Run{ID: id, FinishedAt: now.Add(-age), Status: status}
+	}
+
+	runs := []*Run{
+		run("old-failure", 20*day, RunFailed),
+		run("old-success", 10*day, RunSucceeded),
+		run("failure", 5*day, RunTimedOut),
+		run("success", 2*day, RunSucceeded),
+		run("latest", 1*day, RunFailed),
+	}
+
+	retention := Retention{KeepDays: 3, KeepFailureDays: 14}
+	assertRuns(t, retention.PruneRuns(runs, now), "failure", "success", "latest")
+
+	retention = Retention{KeepRuns: 2}
+	assertRuns(t, retention.PruneRuns(runs, now), "success", "latest")
+
+	// the latest run and latest success survive any retention
+	retention = Retention{KeepRuns: 1, KeepDays: 1, KeepFailureDays: 1}
+	assertRuns(t, retention.PruneRuns(runs[:4], now), "success")
+	assertRuns(t, retention.PruneRuns(runs, now), "success", "latest")
+
+	assertRuns(t, Retention{}.PruneRuns(runs, now), "old-failure", "old-success", "failure", "success", "latest")
+}
+
+func assertRuns(t *testing.T, runs []*Run, expected ...string) {
+	ids := []string{}
+	for _, run := range runs {
+		ids = append(ids, run.ID)
+	}
+	if len(ids) != len(expected) {
+		t.Errorf("expected runs %v but got %v", expected, ids)
+		return
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Errorf("expected runs %v but got %v", expected, ids)
+			return
+		}
+	}
+}
+
+func TestRetentionFor(t *testing.T) {
+	defaults := Retention{KeepRuns: 100, KeepDays: 7, KeepFailureDays: 30, KeepLogs: 500}
+	allocation := &Allocation{Retention: &Retention{KeepRuns: 5}}
+
+	settings := defaults.For(allocation)
+	if settings.KeepRuns != 5 || settings.KeepDays != 7 || settings.KeepLogs != 500 {
+		t.Errorf("expected allocation's KeepRuns with the default days and logs but got %+v", settings)
+	}
+
+	allocation.Logs = []interface{}{"a", "b", "c", "d", "e", "f"}
+	allocation.Retention.KeepLogs = 2
+	removed := defaults.Apply(allocation, time.Now())
+	if removed.Logs != 4 || len(allocation.Logs) != 2 || allocation.Logs[0] != "e" {
+		t.Errorf("expected to keep the last 2 logs but kept %v", allocation.Logs)
+	}
+}
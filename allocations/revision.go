@@ -0,0 +1,185 @@
+package allocations
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorhill/cronexpr"
+	"strings"
+	"time"
+)
+
+// Who made a change to an allocation, and why
+type Change struct {
+	Author  string
+	Message string
+	// refuse the change if the allocation already exists
+	CreateOnly bool
+	// refuse to create the allocation if its namespace already
+	// has this many, 0 means no limit. Updates are always allowed
+	MaxAllocations int
+}
+
+// An immutable record of one version of an allocation's specification
+type Revision struct {
+	Number  int                     `json:"Number"`
+	Author  string                  `json:"Author"`
+	Message string                  `json:"Message,omitempty"`
+	Created time.Time               `json:"Created"`
+	Spec    AllocationSpecification `json:"Spec"`
+	// line diff of Spec against the previous revision
+	Diff string `json:"Diff,omitempty"`
+}
+
+// Everything a store keeps about one allocation, as
+// exported from one server and restored into another
+type Record struct {
+	Allocation *Allocation `json:"Allocation"`
+	Revisions  []*Revision `json:"Revisions"`
+}
+
+// Check a record can be restored
+func (record *Record) Validate() error {
+	allocation := record.Allocation
+	if allocation == nil || allocation.Name == "" {
+		return fmt.Errorf("record has no allocation name")
+	}
+	if !ValidNamespace(allocation.Namespace) {
+		return fmt.Errorf("invalid namespace %q for allocation %v", allocation.Namespace, allocation.Name)
+	}
+	if allocation.Cron == "" {
+		return nil
+	}
+	if _, err := cronexpr.Parse(allocation.Cron); err != nil {
+		return fmt.Errorf("invalid cron %q for allocation %v/%v: %v", allocation.Cron, allocation.Namespace, allocation.Name, err)
+	}
+	return nil
+}
+
+// Build the revision after previous, which is nil for a new allocation
+func newRevision(previous *Revision, spec *AllocationSpecification, change Change) *Revision {
+	revision := &Revision{
+		Number:  1,
+		Author:  change.Author,
+		Message: change.Message,
+		Created: time.Now(),
+		Spec:    spec.DeepCopy(),
+	}
+	// the precondition was for this write, not part of the spec
+	revision.Spec.ResourceVersion = 0
+	revision.Spec.Namespace = NamespaceOrDefault(spec.Namespace)
+
+	before := ""
+	if previous != nil {
+		revision.Number = previous.Number + 1
+		before = previous.render()
+	}
+	revision.Diff = diffLines(before, revision.render())
+	return revision
+}
+
+// A copy of spec that shares no pointers, maps or slices with it, so
+// later changes to the live allocation can't rewrite its history
+func (spec *AllocationSpecification) DeepCopy() AllocationSpecification {
+	var copied AllocationSpecification
+	encoded, err := json.Marshal(spec)
+	if err == nil {
+		err = json.Unmarshal(encoded, &copied)
+	}
+	if err != nil {
+		// specs arrive as json, so this would be a bug
+		panic(fmt.Sprintf("couldn't copy the specification of %v: %v", spec.Name, err))
+	}
+	return copied
+}
+
+func (revision *Revision) render() string {
+	pretty, err := json.MarshalIndent(revision.Spec, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%+v", revision.Spec)
+	}
+	return string(pretty)
+}
+
+// how many unchanged lines to show around each change
+const diffContext = 2
+
+// A minimal line diff, "- " for removed lines, "+ " for added lines
+// and a little unchanged context around them. Specs are a few dozen
+// lines, so the quadratic longest common subsequence is fine
+func diffLines(before string, after string) string {
+	a := splitLines(before)
+	b := splitLines(after)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := []string{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			lines = append(lines, "+ "+b[j])
+			j++
+		default:
+			lines = append(lines, "- "+a[i])
+			i++
+		}
+	}
+
+	return strings.Join(withContext(lines), "\n")
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
+
+// drop unchanged lines that aren't near a change
+func withContext(lines []string) []string {
+	keep := make([]bool, len(lines))
+	for i, line := range lines {
+		if strings.HasPrefix(line, "  ") {
+			continue
+		}
+		for k := i - diffContext; k <= i+diffContext; k++ {
+			if k >= 0 && k < len(lines) {
+				keep[k] = true
+			}
+		}
+	}
+
+	kept := []string{}
+	skipped := false
+	for i, line := range lines {
+		if !keep[i] {
+			skipped = true
+			continue
+		}
+		if skipped && len(kept) > 0 {
+			kept = append(kept, "  ...")
+		}
+		skipped = false
+		kept = append(kept, line)
+	}
+	return kept
+}
@@ -0,0 +1,91 @@
+package allocations
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+type RunStatus string
+
+const (
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	RunTimedOut  RunStatus = "timedout"
+)
+
+// A record of a single execution of an allocation's container,
+// and of the revision of the allocation's spec it executed
+type Run struct {
+	ID          string    `json:"ID"`
+	Revision    int       `json:"Revision"`
+	StartedAt   time.Time `json:"StartedAt"`
+	FinishedAt  time.Time `json:"FinishedAt"`
+	ContainerID string    `json:"ContainerID,omitempty"`
+	Status      RunStatus `json:"Status"`
+	ExitCode    int       `json:"ExitCode"`
+	// the last few lines of the container's combined stdout/stderr
+	Output string `json:"Output,omitempty"`
+	// set when the run never got as far as an exit code, e.g. a failed pull
+	Error string `json:"Error,omitempty"`
+	// the scheduler lease token of the server that started the run
+	FencingToken uint64 `json:"FencingToken,omitempty"`
+	// the upstream run that started this one, for runs started by a Trigger
+	TriggeredBy *Upstream `json:"TriggeredBy,omitempty"`
+	// for allocations with Steps, the outcome of each step that was
+	// attempted. The run's own outcome is that of the last of them
+	Steps []*StepResult `json:"Steps,omitempty"`
+}
+
+func NewRun() *Run {
+	id := make([]byte, 8)
+	// crypto/rand only fails if the OS entropy source is broken,
+	// fall back to the clock rather than refusing to run
+	if _, err := rand.Read(id); err != nil {
+		return &Run{ID: fmt.Sprintf("%x", time.Now().UnixNano()), StartedAt: time.Now()}
+	}
+	return &Run{ID: hex.EncodeToString(id), StartedAt: time.Now()}
+}
+
+func (run *Run) Succeeded() bool {
+	return run.Status == RunSucceeded
+}
+
+// The most recent recorded run, or nil if the allocation has never run
+func (allocation *Allocation) LastRun() *Run {
+	if len(allocation.Runs) == 0 {
+		return nil
+	}
+	return allocation.Runs[len(allocation.Runs)-1]
+}
+
+// The most recent successful run, or nil if there hasn't been one
+func (allocation *Allocation) LastSuccess() *Run {
+	for i := len(allocation.Runs) - 1; i >= 0; i-- {
+		if allocation.Runs[i].Succeeded() {
+			return allocation.Runs[i]
+		}
+	}
+	return nil
+}
+
+// When the allocation became overdue for a successful run, or the zero
+// time if it isn't overdue or doesn't set ExpectSuccessWithin. An
+// allocation that has never succeeded is measured from its creation
+func (allocation *Allocation) OverdueSince(now time.Time) time.Time {
+	if allocation.ExpectSuccessWithin <= 0 {
+		return time.Time{}
+	}
+
+	since := allocation.Created
+	if success := allocation.LastSuccess(); success != nil {
+		since = success.FinishedAt
+	}
+
+	deadline := since.Add(allocation.ExpectSuccessWithin)
+	if now.After(deadline) {
+		return deadline
+	}
+	return time.Time{}
+}
@@ -0,0 +1,293 @@
+package allocations
+
+import (
+	"fmt"
+	"github.com/gorhill/cronexpr"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+type ScheduleKind string
+
+const (
+	// runs on a cron expression
+	ScheduleCron ScheduleKind = "Cron"
+	// runs once, at a fixed time
+	ScheduleAt ScheduleKind = "At"
+	// runs at a fixed interval from an anchor time
+	ScheduleEvery ScheduleKind = "Every"
+	// only runs when triggered
+	ScheduleNone ScheduleKind = ""
+)
+
+// When an allocation runs. *cronexpr.Expression is one
+type Schedule interface {
+	// The first run strictly after a time, or the zero
+	// time if there are no more runs
+	Next(after time.Time) time.Time
+}
+
+type atSchedule struct {
+	at time.Time
+}
+
+func (schedule atSchedule) Next(after time.Time) time.Time {
+	if schedule.at.After(after) {
+		return schedule.at
+	}
+	return time.Time{}
+}
+
+type everySchedule struct {
+	interval time.Duration
+	anchor   time.Time
+}
+
+func (schedule everySchedule) Next(after time.Time) time.Time {
+	if after.Before(schedule.anchor) {
+		return schedule.anchor
+	}
+	elapsed := after.Sub(schedule.anchor)
+	return schedule.anchor.Add((elapsed/schedule.interval + 1) * schedule.interval)
+}
+
+// a cron expression evaluated in a time zone
+type zonedSchedule struct {
+	expr     *cronexpr.Expression
+	location *time.Location
+}
+
+func (schedule zonedSchedule) Next(after time.Time) time.Time {
+	return schedule.expr.Next(after.In(schedule.location))
+}
+
+func (allocation *Allocation) Kind() ScheduleKind {
+	switch {
+	case allocation.CronExpr != nil:
+		return ScheduleCron
+	case !allocation.At.IsZero():
+		return ScheduleAt
+	case allocation.Every > 0:
+		return ScheduleEvery
+	}
+	return ScheduleNone
+}
+
+// The allocation's schedule, or nil if it only runs when triggered
+func (allocation *Allocation) Schedule() Schedule {
+	switch allocation.Kind() {
+	case ScheduleCron:
+		if allocation.TimeZone != "" {
+			// validated when the allocation was stored
+			if location, err := time.LoadLocation(allocation.TimeZone); err == nil {
+				return zonedSchedule{expr: allocation.CronExpr, location: location}
+			}
+		}
+		return allocation.CronExpr
+	case ScheduleAt:
+		return atSchedule{at: allocation.At}
+	case ScheduleEvery:
+		return everySchedule{interval: allocation.Every, anchor: allocation.Anchor}
+	}
+	return nil
+}
+
+// An At allocation is complete once it has run. Returns when
+// its run finished, or the zero time if it isn't complete
+func (allocation *Allocation) CompletedAt() time.Time {
+	if allocation.Kind() != ScheduleAt {
+		return time.Time{}
+	}
+	last := allocation.LastRun()
+	if last == nil || last.StartedAt.Before(allocation.At) {
+		return time.Time{}
+	}
+	return last.FinishedAt
+}
+
+// Whether a time is within the allocation's ActiveFrom and ActiveUntil
+func (allocation *Allocation) ActiveAt(t time.Time) bool {
+	if !allocation.ActiveFrom.IsZero() && t.Before(allocation.ActiveFrom) {
+		return false
+	}
+	if !allocation.ActiveUntil.IsZero() && t.After(allocation.ActiveUntil) {
+		return false
+	}
+	return true
+}
+
+// Whether a complete At allocation has outlived its ExpireAfter
+func (allocation *Allocation) Expired(now time.Time) bool {
+	completed := allocation.CompletedAt()
+	return allocation.ExpireAfter > 0 && !completed.IsZero() && now.Sub(completed) >= allocation.ExpireAfter
+}
+
+func validateSchedule(spec *AllocationSpecification) map[string]string {
+	problems := map[string]string{}
+
+	kinds := 0
+	for _, set := range []string{spec.Cron, spec.At, spec.Every} {
+		if set != "" {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		problems["Cron"] = "only one of Cron, At and Every can be set"
+	}
+	if kinds == 0 && spec.Trigger == nil {
+		problems["Cron"] = "one of Cron, At or Every, or a Trigger, is required"
+	}
+
+	if spec.Cron != "" {
+		if _, err := cronexpr.Parse(spec.Cron); err != nil {
+			problems["Cron"] = fmt.Sprintf("%v", err)
+		}
+	}
+	if spec.TimeZone != "" {
+		if spec.Cron == "" {
+			problems["TimeZone"] = "TimeZone only applies to Cron"
+		} else if _, err := time.LoadLocation(spec.TimeZone); err != nil {
+			problems["TimeZone"] = fmt.Sprintf("%v", err)
+		}
+	}
+	if spec.At != "" {
+		// one that has passed is refused by the store, and only when it's
+		// set, so a complete At allocation can still be pushed again
+		if _, err := time.Parse(time.RFC3339, spec.At); err != nil {
+			problems["At"] = fmt.Sprintf("At must be an RFC 3339 time, e.g. 2026-11-01T03:00:00Z: %v", err)
+		}
+	}
+	if spec.Every != "" {
+		every, err := time.ParseDuration(spec.Every)
+		if err != nil {
+			problems["Every"] = fmt.Sprintf("%v", err)
+		} else if every < time.Second {
+			problems["Every"] = "Every must be at least 1s"
+		}
+	}
+	if spec.Anchor != "" {
+		if spec.Every == "" {
+			problems["Anchor"] = "Anchor only applies to Every"
+		} else if _, err := time.Parse(time.RFC3339, spec.Anchor); err != nil {
+			problems["Anchor"] = fmt.Sprintf("Anchor must be an RFC 3339 time: %v", err)
+		}
+	}
+	var activeFrom, activeUntil time.Time
+	for field, raw := range map[string]string{"ActiveFrom": spec.ActiveFrom, "ActiveUntil": spec.ActiveUntil} {
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			problems[field] = fmt.Sprintf("%v must be an RFC 3339 time: %v", field, err)
+		}
+		if field == "ActiveFrom" {
+			activeFrom = parsed
+		} else {
+			activeUntil = parsed
+		}
+	}
+	if !activeFrom.IsZero() && !activeUntil.IsZero() && !activeUntil.After(activeFrom) {
+		problems["ActiveUntil"] = "ActiveUntil must be after ActiveFrom"
+	}
+
+	if spec.ExpireAfter != "" {
+		expireAfter, err := time.ParseDuration(spec.ExpireAfter)
+		if err != nil {
+			problems["ExpireAfter"] = fmt.Sprintf("%v", err)
+		} else if expireAfter < 0 {
+			problems["ExpireAfter"] = "ExpireAfter can't be negative"
+		} else if spec.At == "" {
+			problems["ExpireAfter"] = "ExpireAfter only applies to At"
+		}
+	}
+	return problems
+}
+
+// When an upcoming run will start. Without Jitter all three times are
+// the same, with Splay Earliest and Latest are the exact start time,
+// otherwise the run starts at a random time between them
+type PlannedRun struct {
+	Scheduled time.Time `json:"Scheduled"`
+	Earliest  time.Time `json:"Earliest"`
+	Latest    time.Time `json:"Latest"`
+	// why the run will be skipped, if it falls in a blackout
+	Skipped string `json:"Skipped,omitempty"`
+}
+
+// The delay to add to a scheduled run. Splay offsets are the same
+// on every run and every server, Jitter offsets are random each time
+func (allocation *Allocation) Offset() time.Duration {
+	if allocation.Jitter <= 0 {
+		return 0
+	}
+	if allocation.Splay {
+		return allocation.splay()
+	}
+	return time.Duration(rand.Int63n(int64(allocation.Jitter)))
+}
+
+func (allocation *Allocation) splay() time.Duration {
+	hash := fnv.New64a()
+	hash.Write([]byte(allocation.Namespace + "/" + allocation.Name))
+	return time.Duration(hash.Sum64() % uint64(allocation.Jitter))
+}
+
+// The next count runs after a time within the allocation's
+// active window, with their offsets applied
+func (allocation *Allocation) NextRuns(after time.Time, count int) []PlannedRun {
+	planned := []PlannedRun{}
+	schedule := allocation.Schedule()
+	if schedule == nil {
+		return planned
+	}
+	if allocation.ActiveFrom.After(after) {
+		after = allocation.ActiveFrom.Add(-1 * time.Nanosecond)
+	}
+	for scheduled := schedule.Next(after); !scheduled.IsZero() && len(planned) < count; scheduled = schedule.Next(scheduled) {
+		if !allocation.ActiveAt(scheduled) {
+			break
+		}
+		run := PlannedRun{Scheduled: scheduled, Earliest: scheduled, Latest: scheduled}
+		if allocation.Jitter > 0 && allocation.Splay {
+			run.Earliest = scheduled.Add(allocation.splay())
+			run.Latest = run.Earliest
+		} else if allocation.Jitter > 0 {
+			run.Latest = scheduled.Add(allocation.Jitter)
+		}
+		planned = append(planned, run)
+	}
+	return planned
+}
+
+// An allocation's upcoming runs, as returned by GET /next
+type Preview struct {
+	Namespace string       `json:"Namespace"`
+	Name      string       `json:"Name"`
+	Kind      ScheduleKind `json:"Kind,omitempty"`
+	Paused    bool         `json:"Paused"`
+	// set once an At allocation has had its run
+	Completed *time.Time   `json:"Completed,omitempty"`
+	Jitter    string       `json:"Jitter,omitempty"`
+	Splay     bool         `json:"Splay,omitempty"`
+	Runs      []PlannedRun `json:"Runs"`
+}
+
+func (allocation *Allocation) Preview(now time.Time, count int) Preview {
+	preview := Preview{
+		Namespace: allocation.Namespace,
+		Name:      allocation.Name,
+		Kind:      allocation.Kind(),
+		Paused:    allocation.Paused,
+		Splay:     allocation.Splay,
+		Runs:      allocation.NextRuns(now, count),
+	}
+	if allocation.Jitter > 0 {
+		preview.Jitter = allocation.Jitter.String()
+	}
+	if completed := allocation.CompletedAt(); !completed.IsZero() {
+		preview.Completed = &completed
+	}
+	return preview
+}
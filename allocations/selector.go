@@ -0,0 +1,202 @@
+package allocations
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^([a-z0-9]([-a-z0-9.]*[a-z0-9])?/)?[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+)
+
+type Operator string
+
+const (
+	Equals       Operator = "="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+// One clause of a selector, e.g. team=data or env notin (prod,staging)
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// A label query, all of whose requirements must match.
+// The zero value matches everything
+type Selector struct {
+	Requirements []Requirement
+}
+
+// A selector that matches every allocation
+func Everything() Selector {
+	return Selector{}
+}
+
+func (selector Selector) Empty() bool {
+	return len(selector.Requirements) == 0
+}
+
+func (selector Selector) Matches(labels map[string]string) bool {
+	for _, requirement := range selector.Requirements {
+		if !requirement.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (requirement Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[requirement.Key]
+	switch requirement.Operator {
+	case Equals, In:
+		return ok && contains(requirement.Values, value)
+	case NotEquals, NotIn:
+		return !ok || !contains(requirement.Values, value)
+	case Exists:
+		return ok
+	case DoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (selector Selector) String() string {
+	clauses := []string{}
+	for _, requirement := range selector.Requirements {
+		clauses = append(clauses, requirement.String())
+	}
+	return strings.Join(clauses, ",")
+}
+
+func (requirement Requirement) String() string {
+	switch requirement.Operator {
+	case Equals, NotEquals:
+		return requirement.Key + string(requirement.Operator) + requirement.Values[0]
+	case In, NotIn:
+		return fmt.Sprintf("%v %v (%v)", requirement.Key, requirement.Operator, strings.Join(requirement.Values, ","))
+	case DoesNotExist:
+		return "!" + requirement.Key
+	}
+	return requirement.Key
+}
+
+// Parse a selector like "team=data,env!=prod,tier in (web,worker),!legacy".
+// Supported clauses are key=value (or ==), key!=value, key in (a,b),
+// key notin (a,b), key (the label exists) and !key (it doesn't)
+func ParseSelector(raw string) (Selector, error) {
+	selector := Selector{}
+	for _, clause := range splitClauses(raw) {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+		requirement, err := parseRequirement(clause)
+		if err != nil {
+			return Selector{}, err
+		}
+		selector.Requirements = append(selector.Requirements, requirement)
+	}
+	return selector, nil
+}
+
+// split on commas that aren't inside a (set)
+func splitClauses(raw string) []string {
+	clauses := []string{}
+	depth := 0
+	start := 0
+	for i, c := range raw {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				clauses = append(clauses, raw[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(clauses, raw[start:])
+}
+
+func parseRequirement(clause string) (Requirement, error) {
+	if strings.HasPrefix(clause, "!") && !strings.Contains(clause, "=") {
+		return newRequirement(strings.TrimSpace(clause[1:]), DoesNotExist, nil)
+	}
+
+	if index := strings.Index(clause, "!="); index >= 0 {
+		return newRequirement(clause[:index], NotEquals, []string{clause[index+2:]})
+	}
+	if index := strings.Index(clause, "=="); index >= 0 {
+		return newRequirement(clause[:index], Equals, []string{clause[index+2:]})
+	}
+	if index := strings.Index(clause, "="); index >= 0 {
+		return newRequirement(clause[:index], Equals, []string{clause[index+1:]})
+	}
+
+	if open := strings.Index(clause, "("); open >= 0 {
+		if !strings.HasSuffix(clause, ")") {
+			return Requirement{}, fmt.Errorf("invalid selector %q: missing )", clause)
+		}
+		fields := strings.Fields(clause[:open])
+		if len(fields) != 2 || (fields[1] != string(In) && fields[1] != string(NotIn)) {
+			return Requirement{}, fmt.Errorf("invalid selector %q: expected \"key in (...)\" or \"key notin (...)\"", clause)
+		}
+		values := []string{}
+		for _, value := range strings.Split(clause[open+1:len(clause)-1], ",") {
+			values = append(values, strings.TrimSpace(value))
+		}
+		return newRequirement(fields[0], Operator(fields[1]), values)
+	}
+
+	return newRequirement(clause, Exists, nil)
+}
+
+func newRequirement(key string, operator Operator, values []string) (Requirement, error) {
+	key = strings.TrimSpace(key)
+	if !labelKeyPattern.MatchString(key) {
+		return Requirement{}, fmt.Errorf("invalid label key %q in selector", key)
+	}
+	for i, value := range values {
+		values[i] = strings.TrimSpace(value)
+		if !labelValuePattern.MatchString(values[i]) {
+			return Requirement{}, fmt.Errorf("invalid label value %q in selector", values[i])
+		}
+	}
+	sort.Strings(values)
+	return Requirement{Key: key, Operator: operator, Values: values}, nil
+}
+
+// Check a set of labels is well formed, returning a message per bad label
+func validateLabels(labels map[string]string) []string {
+	problems := []string{}
+	for key, value := range labels {
+		if !labelKeyPattern.MatchString(key) {
+			problems = append(problems, fmt.Sprintf("invalid label key %q", key))
+		}
+		if !labelValuePattern.MatchString(value) {
+			problems = append(problems, fmt.Sprintf("invalid value %q for label %v", value, key))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
@@ -0,0 +1,43 @@
+package allocations
+
+import (
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+	labels := map[string]string{"team": "data", "env": "staging", "tier": "worker"}
+
+	cases := map[string]bool{
+		"":                                true,
+		"team=data":                       true,
+		"team==data":                      true,
+		"team=web":                        false,
+		"team=data,env!=prod":             true,
+		"team=data,env!=staging":          false,
+		"tier in (web, worker)":           true,
+		"tier notin (web,worker)":         false,
+		"env in (prod),team=data":         false,
+		"team":                            true,
+		"!legacy":                         true,
+		"!team":                           false,
+		"missing!=anything":               true,
+		"team=data, tier in (worker),env": true,
+	}
+
+	for raw, expected := range cases {
+		selector, err := ParseSelector(raw)
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", raw, err)
+			continue
+		}
+		if selector.Matches(labels) != expected {
+			t.Errorf("expected %q matching %v to be %v", raw, labels, expected)
+		}
+	}
+
+	for _, raw := range []string{"team=da ta", "tier in (web", "tier within (web)", "=data"} {
+		if _, err := ParseSelector(raw); err == nil {
+			t.Errorf("expected %q not to parse", raw)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package allocations
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// where the shared workspace volume is mounted in each step's container
+const DefaultWorkspace = "/workspace"
+
+// One container of a multi-step allocation
+type Step struct {
+	Name      string                 `json:"Name" yaml:"Name"`
+	Container CreateContainerOptions `json:"Container" yaml:"Container"`
+}
+
+// The outcome of one step of a run
+type StepResult struct {
+	Name        string    `json:"Name"`
+	StartedAt   time.Time `json:"StartedAt"`
+	FinishedAt  time.Time `json:"FinishedAt"`
+	ContainerID string    `json:"ContainerID,omitempty"`
+	Status      RunStatus `json:"Status"`
+	ExitCode    int       `json:"ExitCode"`
+	Output      string    `json:"Output,omitempty"`
+	Error       string    `json:"Error,omitempty"`
+}
+
+// The images the allocation runs, one per step or just its Container's
+func (allocation *Allocation) Images() []string {
+	if len(allocation.Steps) == 0 {
+		if allocation.Container.Config == nil {
+			return nil
+		}
+		return []string{allocation.Container.Config.Image}
+	}
+	images := []string{}
+	for _, step := range allocation.Steps {
+		images = append(images, step.Container.Config.Image)
+	}
+	return images
+}
+
+func validateSteps(spec *AllocationSpecification) map[string]string {
+	problems := map[string]string{}
+	if spec.Container.Config != nil {
+		problems["Container"] = "an allocation with Steps can't also have a Container"
+	}
+	if spec.Workspace != "" && !path.IsAbs(spec.Workspace) {
+		problems["Workspace"] = "Workspace must be an absolute path"
+	}
+
+	names := map[string]bool{}
+	for i, step := range spec.Steps {
+		field := fmt.Sprintf("Steps[%v]", i)
+		switch {
+		case step.Name == "":
+			problems[field+".Name"] = "Name is required"
+		case names[step.Name]:
+			problems[field+".Name"] = fmt.Sprintf("there's already a step named %v", step.Name)
+		}
+		names[step.Name] = true
+
+		if step.Container.Config == nil {
+			problems[field+".Container.Config"] = "Config is required"
+		} else if step.Container.Config.Image == "" {
+			problems[field+".Container.Config.Image"] = "Image is required"
+		}
+	}
+	return problems
+}
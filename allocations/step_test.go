@@ -0,0 +1,53 @@
+package allocations
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"reflect"
+	"testing"
+)
+
+func step(name string, image string) Step {
+	return Step{Name: name, Container: CreateContainerOptions{Config: &docker.Config{Image: image}}}
+}
+
+func TestValidateSteps(t *testing.T) {
+	spec := &AllocationSpecification{
+		Name:  "etl",
+		Steps: []Step{step("fetch", "curl"), step("transform", "python"), step("upload", "aws-cli")},
+	}
+	if problems := validateSteps(spec); len(problems) != 0 {
+		t.Errorf("expected no problems but got %v", problems)
+	}
+
+	spec.Steps = append(spec.Steps, step("fetch", ""), Step{})
+	spec.Workspace = "workspace"
+	expected := map[string]string{
+		"Workspace":                       "Workspace must be an absolute path",
+		"Steps[3].Name":                   "there's already a step named fetch",
+		"Steps[3].Container.Config.Image": "Image is required",
+		"Steps[4].Name":                   "Name is required",
+		"Steps[4].Container.Config":       "Config is required",
+	}
+	if problems := validateSteps(spec); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected %v but got %v", expected, problems)
+	}
+}
+
+func TestImages(t *testing.T) {
+	spec := &AllocationSpecification{
+		Name:  "etl",
+		Steps: []Step{step("fetch", "curl"), step("upload", "aws-cli")},
+	}
+	spec.ProvisionDefaults()
+	allocation := NewAllocation(spec)
+
+	if !reflect.DeepEqual(allocation.Images(), []string{"curl", "aws-cli"}) {
+		t.Errorf("unexpected images %v", allocation.Images())
+	}
+	if allocation.Workspace != DefaultWorkspace {
+		t.Errorf("expected workspace %v but was %v", DefaultWorkspace, allocation.Workspace)
+	}
+	if allocation.Container.Config != nil {
+		t.Errorf("expected an allocation with steps to have no container config")
+	}
+}
@@ -0,0 +1,195 @@
+package allocations
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type TriggerCondition string
+
+const (
+	OnSuccess    TriggerCondition = "success"
+	OnFailure    TriggerCondition = "failure"
+	OnCompletion TriggerCondition = "completion"
+)
+
+// Run an allocation when other allocations in its namespace finish
+type Trigger struct {
+	// names of the upstream allocations, any one of them finishing
+	// with the right outcome starts a run
+	After []string `json:"After" yaml:"After"`
+	// which outcome of an upstream run starts a run, default success.
+	// Timeouts count as failures
+	On TriggerCondition `json:"On,omitempty" yaml:"On,omitempty"`
+}
+
+// The run that triggered a downstream run
+type Upstream struct {
+	Namespace  string `json:"Namespace"`
+	Allocation string `json:"Allocation"`
+	RunID      string `json:"RunID"`
+}
+
+func (trigger *Trigger) condition() TriggerCondition {
+	if trigger.On == "" {
+		return OnSuccess
+	}
+	return trigger.On
+}
+
+// Whether a finished run of upstream should start a run of this allocation
+func (allocation *Allocation) TriggeredBy(upstream *Allocation, succeeded bool) bool {
+	trigger := allocation.Trigger
+	if trigger == nil || allocation.Namespace != upstream.Namespace || !contains(trigger.After, upstream.Name) {
+		return false
+	}
+	switch trigger.condition() {
+	case OnSuccess:
+		return succeeded
+	case OnFailure:
+		return !succeeded
+	}
+	return true
+}
+
+func validateTrigger(spec *AllocationSpecification) []string {
+	trigger := spec.Trigger
+	problems := []string{}
+	if len(trigger.After) == 0 {
+		problems = append(problems, "After needs at least one allocation")
+	}
+	for _, name := range trigger.After {
+		if name == "" {
+			problems = append(problems, "After can't contain an empty name")
+		}
+		if name == spec.Name {
+			problems = append(problems, fmt.Sprintf("%v can't trigger itself", name))
+		}
+	}
+	switch trigger.On {
+	case "", OnSuccess, OnFailure, OnCompletion:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown condition %v, expected one of success, failure, completion", trigger.On))
+	}
+	return problems
+}
+
+// An edge from an upstream allocation to one it triggers
+type Edge struct {
+	From string           `json:"From"`
+	To   string           `json:"To"`
+	On   TriggerCondition `json:"On"`
+}
+
+// The trigger dependencies between allocations, as returned by GET /graph.
+// Nodes are namespace/name, and include upstream names that
+// nothing is stored under yet
+type Graph struct {
+	Nodes []string `json:"Nodes"`
+	Edges []Edge   `json:"Edges"`
+}
+
+func NewGraph(list Allocations) Graph {
+	graph := Graph{Nodes: []string{}, Edges: []Edge{}}
+	nodes := map[string]bool{}
+	for _, allocation := range list {
+		to := allocation.Namespace + "/" + allocation.Name
+		nodes[to] = true
+		if allocation.Trigger == nil {
+			continue
+		}
+		for _, upstream := range allocation.Trigger.After {
+			from := allocation.Namespace + "/" + upstream
+			nodes[from] = true
+			graph.Edges = append(graph.Edges, Edge{From: from, To: to, On: allocation.Trigger.condition()})
+		}
+	}
+	for node := range nodes {
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	sort.Strings(graph.Nodes)
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+	return graph
+}
+
+// Returned when storing an allocation would make its
+// triggers run in a loop
+type CycleError struct {
+	Namespace string
+	// the allocations in the loop, in the order they'd run,
+	// starting and ending with the allocation being stored
+	Cycle []string
+}
+
+func (err *CycleError) Error() string {
+	return fmt.Sprintf("trigger cycle in namespace %v: %v", err.Namespace, strings.Join(err.Cycle, " -> "))
+}
+
+// Check that storing spec alongside the existing allocations wouldn't
+// create a trigger cycle, which would run its allocations forever.
+// Returns a *CycleError if it would
+func CheckCycles(existing []*Allocation, spec *AllocationSpecification) error {
+	namespace := NamespaceOrDefault(spec.Namespace)
+
+	// upstream names of each allocation in the namespace, with spec
+	// replacing whatever is stored under its name
+	after := map[string][]string{}
+	for _, allocation := range existing {
+		if allocation.Namespace == namespace && allocation.Trigger != nil {
+			after[allocation.Name] = allocation.Trigger.After
+		}
+	}
+	delete(after, spec.Name)
+	if spec.Trigger != nil {
+		after[spec.Name] = spec.Trigger.After
+	}
+
+	// depth first search upstream from spec, a path back to spec is a cycle
+	var visit func(name string, path []string) []string
+	visited := map[string]bool{}
+	visit = func(name string, path []string) []string {
+		for _, upstream := range after[name] {
+			if upstream == spec.Name {
+				return append(path, upstream)
+			}
+			if visited[upstream] {
+				continue
+			}
+			visited[upstream] = true
+			if cycle := visit(upstream, append(path, upstream)); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+
+	if cycle := visit(spec.Name, []string{spec.Name}); cycle != nil {
+		// reverse so the cycle reads in the order runs would happen
+		for i, j := 0, len(cycle)-1; i < j; i, j = i+1, j-1 {
+			cycle[i], cycle[j] = cycle[j], cycle[i]
+		}
+		return &CycleError{Namespace: namespace, Cycle: cycle}
+	}
+	return nil
+}
+
+// Check a whole set of allocations, e.g. what a store would hold after an
+// import, for trigger cycles. Returns a *CycleError for the first one found
+func CheckAllCycles(all []*Allocation) error {
+	for _, allocation := range all {
+		if allocation.Trigger == nil {
+			continue
+		}
+		spec := &AllocationSpecification{Name: allocation.Name, Namespace: allocation.Namespace, Trigger: allocation.Trigger}
+		if err := CheckCycles(all, spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
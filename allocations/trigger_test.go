@@ -0,0 +1,104 @@
+package allocations
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"reflect"
+	"testing"
+)
+
+func triggeredSpec(name string, after ...string) *AllocationSpecification {
+	return &AllocationSpecification{
+		Name:    name,
+		Trigger: &Trigger{After: after},
+		Container: CreateContainerOptions{
+			Config: &docker.Config{
+				Image: "busybox:latest",
+			},
+		},
+	}
+}
+
+func TestTriggeredBy(t *testing.T) {
+	upstream := &Allocation{Namespace: DefaultNamespace, Name: "extract"}
+
+	cases := []struct {
+		on        TriggerCondition
+		succeeded bool
+		expected  bool
+	}{
+		{"", true, true},
+		{"", false, false},
+		{OnFailure, false, true},
+		{OnFailure, true, false},
+		{OnCompletion, true, true},
+		{OnCompletion, false, true},
+	}
+	for _, c := range cases {
+		downstream := &Allocation{Namespace: DefaultNamespace, Name: "load", Trigger: &Trigger{After: []string{"extract"}, On: c.on}}
+		if downstream.TriggeredBy(upstream, c.succeeded) != c.expected {
+			t.Errorf("expected on %q with succeeded %v to trigger: %v", c.on, c.succeeded, c.expected)
+		}
+	}
+
+	other := &Allocation{Namespace: "data", Name: "extract"}
+	downstream := &Allocation{Namespace: DefaultNamespace, Name: "load", Trigger: &Trigger{After: []string{"extract"}}}
+	if downstream.TriggeredBy(other, true) {
+		t.Errorf("expected an allocation in another namespace not to trigger")
+	}
+}
+
+func TestCheckCycles(t *testing.T) {
+	store := InMemory()
+	store.CreateOrUpdate(triggeredSpec("transform", "extract"), Change{})
+	store.CreateOrUpdate(triggeredSpec("load", "transform"), Change{})
+
+	existing, _ := store.List(AllNamespaces, Everything())
+	if err := CheckCycles(existing, triggeredSpec("report", "load")); err != nil {
+		t.Errorf("expected no cycle but got %v", err)
+	}
+
+	err := CheckCycles(existing, triggeredSpec("extract", "load"))
+	cycle, ok := err.(*CycleError)
+	if !ok {
+		t.Fatalf("expected a *CycleError but got %v", err)
+	}
+	expected := []string{"extract", "transform", "load", "extract"}
+	if !reflect.DeepEqual(cycle.Cycle, expected) {
+		t.Errorf("expected cycle %v but got %v", expected, cycle.Cycle)
+	}
+
+	// the store refuses it too, and keeps what it had
+	if _, _, err := store.CreateOrUpdate(triggeredSpec("extract", "load"), Change{}); err == nil {
+		t.Errorf("expected the store to refuse a cycle")
+	}
+	if _, err := store.Get(DefaultNamespace, "extract"); err == nil {
+		t.Errorf("expected extract not to have been stored")
+	}
+
+	// replacing the trigger that made the cycle is fine
+	store.CreateOrUpdate(triggeredSpec("transform", "other"), Change{})
+	existing, _ = store.List(AllNamespaces, Everything())
+	if err := CheckCycles(existing, triggeredSpec("extract", "load")); err != nil {
+		t.Errorf("expected no cycle once transform stopped waiting on extract but got %v", err)
+	}
+}
+
+func TestNewGraph(t *testing.T) {
+	store := InMemory()
+	store.CreateOrUpdate(triggeredSpec("load", "transform"), Change{})
+	store.CreateOrUpdate(triggeredSpec("transform", "extract"), Change{})
+
+	list, _ := store.List(AllNamespaces, Everything())
+	graph := NewGraph(list)
+
+	if !reflect.DeepEqual(graph.Nodes, []string{"default/extract", "default/load", "default/transform"}) {
+		t.Errorf("unexpected nodes %v", graph.Nodes)
+	}
+	expected := []Edge{
+		{From: "default/extract", To: "default/transform", On: OnSuccess},
+		{From: "default/transform", To: "default/load", On: OnSuccess},
+	}
+	if !reflect.DeepEqual(graph.Edges, expected) {
+		t.Errorf("expected edges %v but got %v", expected, graph.Edges)
+	}
+}
@@ -0,0 +1,80 @@
+package allocations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type WatchEventType string
+
+const (
+	Added       WatchEventType = "Added"
+	Modified    WatchEventType = "Modified"
+	Deleted     WatchEventType = "Deleted"
+	RunRecorded WatchEventType = "RunRecorded"
+)
+
+// A change to the store. Allocation is a snapshot taken when the
+// change was made, for Deleted it's the allocation as it was deleted.
+// The snapshot leaves out the allocation's Runs and Logs, Get it for those
+type WatchEvent struct {
+	Type WatchEventType `json:"Type"`
+	// the store's version after the change. Pass the last version
+	// seen to Watch to pick up where a previous watch left off
+	ResourceVersion uint64      `json:"ResourceVersion"`
+	Allocation      *Allocation `json:"Allocation"`
+	// set for RunRecorded
+	Run *Run `json:"Run,omitempty"`
+}
+
+// Returned by Watch when sinceVersion is older than
+// the history the store keeps. List and watch again from 0
+var ErrWatchExpired = errors.New("watch version is too old, the events since then are no longer available")
+
+// Implemented by stores that can stream their changes
+// instead of making callers poll List
+type Watcher interface {
+	// Stream every change made after sinceVersion. With a sinceVersion of 0
+	// the stream starts with an Added event for each existing allocation.
+	//
+	// Call cancel to stop watching. The channel is also closed if the
+	// receiver falls too far behind, watch again from the last
+	// ResourceVersion received to resume
+	Watch(sinceVersion uint64) (events <-chan WatchEvent, cancel func(), err error)
+}
+
+// how many events a watcher can fall behind before it's dropped
+const watchBuffer = 256
+
+// how many past events stores keep for watches resuming from a version
+const watchHistory = 1024
+
+// A copy of an allocation that later changes to the stored allocation don't
+// affect, so what Get and List return can be read without holding the
+// store's lock. Runs, logs and containers are changed in place, so
+// nothing can be shared
+func snapshot(allocation *Allocation) *Allocation {
+	copied := &Allocation{}
+	encoded, err := json.Marshal(allocation)
+	if err == nil {
+		err = json.Unmarshal(encoded, copied)
+	}
+	if err != nil {
+		// allocations are built from json, so this would be a bug
+		panic(fmt.Sprintf("couldn't snapshot allocation %v/%v: %v", allocation.Namespace, allocation.Name, err))
+	}
+	// parsed once and never changed
+	copied.CronExpr = allocation.CronExpr
+	return copied
+}
+
+// The snapshot a watch event carries. Copying every run and log line on every
+// change, and keeping those copies in the history, would make each event as
+// big as the allocation's whole history, so they're left out
+func eventSnapshot(allocation *Allocation) *Allocation {
+	trimmed := *allocation
+	trimmed.Runs = nil
+	trimmed.Logs = nil
+	return snapshot(&trimmed)
+}
@@ -0,0 +1,242 @@
+// Package archive moves a docket server's state between hosts as a
+// gzipped tarball: a manifest, the server's settings (never secret
+// values) and one file per allocation with its revisions and run history.
+//
+// Archives are written and restored through the AllocationStore
+// interface, so they work with any store backend.
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/horthy/docket/allocations"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+// The archive format version this package writes. Bump it when
+// the layout changes, and keep reading the older versions
+const Version = 1
+
+const (
+	manifestFile   = "manifest.json"
+	settingsFile   = "settings.json"
+	allocationsDir = "allocations"
+)
+
+type Manifest struct {
+	Version     int       `json:"Version"`
+	Exported    time.Time `json:"Exported"`
+	Allocations int       `json:"Allocations"`
+}
+
+type Archive struct {
+	Manifest Manifest
+	// the exporting server's settings, for reference. Restoring
+	// doesn't change the importing server's settings
+	Settings json.RawMessage
+	Records  []*allocations.Record
+}
+
+type Mode string
+
+const (
+	// Add or overwrite the archived allocations, leave the rest alone
+	Merge Mode = "merge"
+	// Make the server's allocations exactly the archived ones
+	Replace Mode = "replace"
+)
+
+// What an import did
+type Result struct {
+	Imported []string `json:"Imported"`
+	Deleted  []string `json:"Deleted,omitempty"`
+}
+
+// Write every allocation in store, across all namespaces, and settings
+func Write(w io.Writer, store allocations.AllocationStore, settings interface{}) error {
+	all, err := store.List(allocations.AllNamespaces, allocations.Everything())
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	now := time.Now()
+
+	err = writeJSON(archive, manifestFile, now, Manifest{Version: Version, Exported: now, Allocations: len(all)})
+	if err != nil {
+		return err
+	}
+	err = writeJSON(archive, settingsFile, now, settings)
+	if err != nil {
+		return err
+	}
+
+	for _, allocation := range all {
+		revisions, err := store.Revisions(allocation.Namespace, allocation.Name)
+		if err != nil {
+			return err
+		}
+		name := path.Join(allocationsDir, allocation.Namespace, allocation.Name+".json")
+		err = writeJSON(archive, name, now, allocations.Record{Allocation: allocation, Revisions: revisions})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeJSON(archive *tar.Writer, name string, modified time.Time, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = archive.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = archive.Write(data)
+	return err
+}
+
+// Read an archive, checking it's a version this package understands
+func Read(r io.Reader) (*Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a docket export: %v", err)
+	}
+	defer gz.Close()
+
+	result := &Archive{}
+	sawManifest := false
+	files := tar.NewReader(gz)
+	for {
+		header, err := files.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(files)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case header.Name == manifestFile:
+			if err := json.Unmarshal(data, &result.Manifest); err != nil {
+				return nil, fmt.Errorf("%v: %v", header.Name, err)
+			}
+			if result.Manifest.Version < 1 || result.Manifest.Version > Version {
+				return nil, fmt.Errorf("archive version %v isn't supported, this docket reads versions up to %v", result.Manifest.Version, Version)
+			}
+			sawManifest = true
+		case header.Name == settingsFile:
+			result.Settings = json.RawMessage(data)
+		case strings.HasPrefix(header.Name, allocationsDir+"/") && strings.HasSuffix(header.Name, ".json"):
+			record := &allocations.Record{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return nil, fmt.Errorf("%v: %v", header.Name, err)
+			}
+			result.Records = append(result.Records, record)
+		}
+	}
+
+	if !sawManifest {
+		return nil, fmt.Errorf("not a docket export: no %v", manifestFile)
+	}
+	if len(result.Records) != result.Manifest.Allocations {
+		return nil, fmt.Errorf("archive is incomplete, manifest lists %v allocations but found %v", result.Manifest.Allocations, len(result.Records))
+	}
+	return result, nil
+}
+
+// Restore the archived allocations into store. With Replace,
+// allocations that aren't in the archive are deleted afterwards
+func (archive *Archive) Restore(store allocations.AllocationStore, mode Mode) (*Result, error) {
+	if mode != Merge && mode != Replace {
+		return nil, fmt.Errorf("unknown import mode %q, expected merge or replace", mode)
+	}
+
+	// check everything first so a bad record doesn't leave a half finished import
+	for _, record := range archive.Records {
+		if err := record.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	final, err := archive.after(store, mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := allocations.CheckAllCycles(final); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Imported: []string{}}
+	archived := map[string]bool{}
+	for _, record := range archive.Records {
+		key := record.Allocation.Namespace + "/" + record.Allocation.Name
+		if err := store.Restore(record); err != nil {
+			return result, err
+		}
+		archived[key] = true
+		result.Imported = append(result.Imported, key)
+	}
+
+	if mode == Replace {
+		existing, err := store.List(allocations.AllNamespaces, allocations.Everything())
+		if err != nil {
+			return result, err
+		}
+		for _, allocation := range existing {
+			key := allocation.Namespace + "/" + allocation.Name
+			if archived[key] {
+				continue
+			}
+			if err := store.Delete(allocation.Namespace, allocation.Name); err != nil {
+				return result, err
+			}
+			result.Deleted = append(result.Deleted, key)
+		}
+	}
+	return result, nil
+}
+
+// The allocations store will hold once the archive is restored
+func (archive *Archive) after(store allocations.AllocationStore, mode Mode) ([]*allocations.Allocation, error) {
+	final := map[string]*allocations.Allocation{}
+	if mode == Merge {
+		existing, err := store.List(allocations.AllNamespaces, allocations.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, allocation := range existing {
+			final[allocation.Namespace+"/"+allocation.Name] = allocation
+		}
+	}
+	for _, record := range archive.Records {
+		final[record.Allocation.Namespace+"/"+record.Allocation.Name] = record.Allocation
+	}
+
+	all := []*allocations.Allocation{}
+	for _, allocation := range final {
+		all = append(all, allocation)
+	}
+	return all, nil
+}
@@ -0,0 +1,136 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/allocations"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	source := allocations.InMemory()
+	for _, name := range []string{"foo", "bar"} {
+		source.CreateOrUpdate(&allocations.AllocationSpecification{
+			Name:      name,
+			Namespace: "data",
+			Cron:      "* * * * * *",
+			Container: allocations.CreateContainerOptions{
+				Config: &docker.Config{
+					Image: "busybox:latest",
+				},
+			},
+		}, allocations.Change{Author: "alice"})
+	}
+	foo, _ := source.Get("data", "foo")
+	source.RecordRun(foo, &allocations.Run{ID: "run-1", Status: allocations.RunSucceeded})
+	source.SetPaused("data", "bar", true)
+
+	buffer := new(bytes.Buffer)
+	err := Write(buffer, source, map[string]string{"setting": "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.Manifest.Version != Version || len(archive.Records) != 2 {
+		t.Fatalf("unexpected archive %+v", archive.Manifest)
+	}
+
+	target := allocations.InMemory()
+	target.CreateOrUpdate(&allocations.AllocationSpecification{
+		Name: "baz",
+		Cron: "* * * * * *",
+		Container: allocations.CreateContainerOptions{
+			Config: &docker.Config{
+				Image: "busybox:latest",
+			},
+		},
+	}, allocations.Change{})
+
+	result, err := archive.Restore(target, Merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Imported) != 2 || len(result.Deleted) != 0 {
+		t.Errorf("unexpected merge result %+v", result)
+	}
+
+	restored, err := target.Get("data", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Runs) != 1 || restored.Runs[0].ID != "run-1" || restored.CronExpr == nil {
+		t.Errorf("expected foo's run history and schedule to be restored but got %+v", restored)
+	}
+	if revisions, _ := target.Revisions("data", "foo"); len(revisions) != 1 || revisions[0].Author != "alice" {
+		t.Errorf("expected foo's revision by alice to be restored but got %v", revisions)
+	}
+	if bar, _ := target.Get("data", "bar"); bar == nil || !bar.Paused {
+		t.Error("expected bar to be restored paused")
+	}
+
+	result, err = archive.Restore(target, Replace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Deleted) != 1 || result.Deleted[0] != "default/baz" {
+		t.Errorf("expected replace to delete default/baz but got %+v", result)
+	}
+}
+
+func TestReadRejectsNewerVersions(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	gz := gzip.NewWriter(buffer)
+	files := tar.NewWriter(gz)
+	writeJSON(files, manifestFile, time.Now(), Manifest{Version: Version + 1})
+	files.Close()
+	gz.Close()
+
+	if _, err := Read(buffer); err == nil {
+		t.Error("expected an error reading an archive from a newer docket")
+	}
+	if _, err := Read(bytes.NewBufferString("not an archive")); err == nil {
+		t.Error("expected an error reading something that isn't an archive")
+	}
+}
+
+func TestRestoreRejectsCycles(t *testing.T) {
+	spec := func(name string, after string) *allocations.AllocationSpecification {
+		return &allocations.AllocationSpecification{
+			Name:    name,
+			Trigger: &allocations.Trigger{After: []string{after}},
+			Container: allocations.CreateContainerOptions{
+				Config: &docker.Config{Image: "busybox:latest"},
+			},
+		}
+	}
+	source := allocations.InMemory()
+	source.CreateOrUpdate(spec("load", "transform"), allocations.Change{})
+	buffer := new(bytes.Buffer)
+	if err := Write(buffer, source, nil); err != nil {
+		t.Fatal(err)
+	}
+	archive, _ := Read(buffer)
+
+	// merged with the target's transform, which runs after load, it's a loop
+	target := allocations.InMemory()
+	target.CreateOrUpdate(spec("transform", "load"), allocations.Change{})
+	_, err := archive.Restore(target, Merge)
+	if _, ok := err.(*allocations.CycleError); !ok {
+		t.Fatalf("expected a *CycleError merging a loop but got %v", err)
+	}
+	if _, err := target.Get(allocations.DefaultNamespace, "load"); err == nil {
+		t.Error("expected nothing to be restored when the result would have a cycle")
+	}
+
+	// replacing drops the target's transform, so there's no loop
+	if _, err := archive.Restore(target, Replace); err != nil {
+		t.Errorf("expected replace to restore load without transform but got %v", err)
+	}
+}
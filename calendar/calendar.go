@@ -0,0 +1,198 @@
+// Package calendar holds the named blackout calendars allocations can
+// refer to, so their runs are skipped during maintenance windows,
+// holidays, weekends and the like
+package calendar
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How a calendar is described under calendars in the server's config file
+type Spec struct {
+	// Optional iCalendar file, each of whose events is a blackout
+	File string
+	// Blackout date ranges
+	Ranges []Range
+	// Days of the week that are blacked out every week, e.g. Saturday
+	Weekdays []string
+	// The time zone for dates without one, e.g. Europe/London. Default UTC
+	Location string
+}
+
+// A blackout from Start to End. Both are either dates like 2026-12-24,
+// in which case End is the last day of the blackout, or RFC 3339 times,
+// in which case the blackout is over at End
+type Range struct {
+	Start  string
+	End    string
+	Reason string
+}
+
+// A blackout, from Start up to but not including End
+type Period struct {
+	Start  time.Time
+	End    time.Time
+	Reason string
+}
+
+func (period Period) Contains(t time.Time) bool {
+	return !t.Before(period.Start) && t.Before(period.End)
+}
+
+type Calendar struct {
+	Name     string
+	Periods  []Period
+	Weekdays []time.Weekday
+	Location *time.Location
+}
+
+// Whether t is blacked out, and if so why
+func (calendar *Calendar) Blackout(t time.Time) (string, bool) {
+	for _, period := range calendar.Periods {
+		if period.Contains(t) {
+			return period.Reason, true
+		}
+	}
+	weekday := t.In(calendar.Location).Weekday()
+	for _, blackedOut := range calendar.Weekdays {
+		if weekday == blackedOut {
+			return weekday.String(), true
+		}
+	}
+	return "", false
+}
+
+// Calendars by name, loaded when the server starts
+type Calendars map[string]*Calendar
+
+func (calendars Calendars) Has(name string) bool {
+	_, ok := calendars[name]
+	return ok
+}
+
+func (calendars Calendars) Names() []string {
+	names := []string{}
+	for name := range calendars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Whether t is blacked out by any of the named calendars, and if so
+// why. Names that aren't loaded are ignored
+func (calendars Calendars) Blackout(names []string, t time.Time) (string, bool) {
+	for _, name := range names {
+		calendar, ok := calendars[name]
+		if !ok {
+			continue
+		}
+		if reason, blackedOut := calendar.Blackout(t); blackedOut {
+			if reason == "" {
+				return fmt.Sprintf("blackout calendar %v", name), true
+			}
+			return fmt.Sprintf("blackout calendar %v: %v", name, reason), true
+		}
+	}
+	return "", false
+}
+
+// Build calendars from their config, reading any iCalendar files
+func Load(specs map[string]Spec) (Calendars, error) {
+	calendars := Calendars{}
+	for name, spec := range specs {
+		calendar, err := load(name, spec)
+		if err != nil {
+			return nil, fmt.Errorf("calendar %v: %v", name, err)
+		}
+		calendars[name] = calendar
+	}
+	return calendars, nil
+}
+
+func load(name string, spec Spec) (*Calendar, error) {
+	location := time.UTC
+	if spec.Location != "" {
+		loaded, err := time.LoadLocation(spec.Location)
+		if err != nil {
+			return nil, err
+		}
+		location = loaded
+	}
+	calendar := &Calendar{Name: name, Location: location}
+
+	for _, raw := range spec.Weekdays {
+		weekday, err := parseWeekday(raw)
+		if err != nil {
+			return nil, err
+		}
+		calendar.Weekdays = append(calendar.Weekdays, weekday)
+	}
+
+	for _, r := range spec.Ranges {
+		period, err := r.period(location)
+		if err != nil {
+			return nil, err
+		}
+		calendar.Periods = append(calendar.Periods, period)
+	}
+
+	if spec.File != "" {
+		file, err := os.Open(spec.File)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		periods, err := ParseICal(file, location)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", spec.File, err)
+		}
+		calendar.Periods = append(calendar.Periods, periods...)
+	}
+	return calendar, nil
+}
+
+const dateLayout = "2006-01-02"
+
+func (r Range) period(location *time.Location) (Period, error) {
+	if start, err := time.ParseInLocation(dateLayout, r.Start, location); err == nil {
+		end, err := time.ParseInLocation(dateLayout, r.End, location)
+		if err != nil {
+			return Period{}, fmt.Errorf("range starting on date %v must end on a date too: %v", r.Start, err)
+		}
+		// a date End is the last day of the blackout
+		end = end.AddDate(0, 0, 1)
+		return newPeriod(start, end, r.Reason)
+	}
+
+	start, err := time.Parse(time.RFC3339, r.Start)
+	if err != nil {
+		return Period{}, fmt.Errorf("range start %q must be a date like 2026-12-24 or an RFC 3339 time", r.Start)
+	}
+	end, err := time.Parse(time.RFC3339, r.End)
+	if err != nil {
+		return Period{}, fmt.Errorf("range end %q must be an RFC 3339 time", r.End)
+	}
+	return newPeriod(start, end, r.Reason)
+}
+
+func newPeriod(start time.Time, end time.Time, reason string) (Period, error) {
+	if !end.After(start) {
+		return Period{}, fmt.Errorf("range from %v to %v ends before it starts", start, end)
+	}
+	return Period{Start: start, End: end, Reason: reason}, nil
+}
+
+func parseWeekday(raw string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := day.String()
+		if strings.EqualFold(raw, name) || strings.EqualFold(raw, name[:3]) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", raw)
+}
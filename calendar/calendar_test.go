@@ -0,0 +1,94 @@
+package calendar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const holidays = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20261225\r\n" +
+	"SUMMARY:Christmas\r\n" +
+	"  Day\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20261101T020000Z\r\n" +
+	"DTEND:20261101T040000Z\r\n" +
+	"SUMMARY:Database upgrade\\, phase 1\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func mustParse(t *testing.T, raw string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestParseICal(t *testing.T) {
+	periods, err := ParseICal(strings.NewReader(holidays), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(periods) != 2 {
+		t.Fatalf("expected 2 periods but got %v", periods)
+	}
+	if periods[0].Reason != "Christmas Day" || periods[0].End.Sub(periods[0].Start) != 24*time.Hour {
+		t.Errorf("unexpected all day period %+v", periods[0])
+	}
+	if periods[1].Reason != "Database upgrade, phase 1" || !periods[1].Contains(mustParse(t, "2026-11-01T03:00:00Z")) {
+		t.Errorf("unexpected period %+v", periods[1])
+	}
+
+	recurring := strings.Replace(holidays, "SUMMARY:Christmas", "RRULE:FREQ=YEARLY\r\nSUMMARY:Christmas", 1)
+	if _, err := ParseICal(strings.NewReader(recurring), time.UTC); err == nil {
+		t.Errorf("expected recurring events to be refused")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calendar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "holidays.ics")
+	if err := ioutil.WriteFile(file, []byte(holidays), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	calendars, err := Load(map[string]Spec{
+		"business-days": {File: file, Weekdays: []string{"Saturday", "sun"}},
+		"maintenance":   {Ranges: []Range{{Start: "2026-11-10", End: "2026-11-11", Reason: "datacenter move"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"2026-11-07T12:00:00Z": "blackout calendar business-days: Saturday",
+		"2026-12-25T09:00:00Z": "blackout calendar business-days: Christmas Day",
+		"2026-11-11T23:59:00Z": "blackout calendar maintenance: datacenter move",
+		"2026-11-12T00:00:00Z": "",
+		"2026-11-09T09:00:00Z": "",
+	}
+	for raw, expected := range cases {
+		reason, blackedOut := calendars.Blackout([]string{"business-days", "maintenance"}, mustParse(t, raw))
+		if reason != expected || blackedOut != (expected != "") {
+			t.Errorf("expected %v to be blacked out for %q but got %q", raw, expected, reason)
+		}
+	}
+
+	if _, err := Load(map[string]Spec{"bad": {Weekdays: []string{"Caturday"}}}); err == nil {
+		t.Errorf("expected an unknown weekday to be refused")
+	}
+	if _, err := Load(map[string]Spec{"bad": {Ranges: []Range{{Start: "2026-11-11", End: "2026-11-10"}}}}); err == nil {
+		t.Errorf("expected a range that ends before it starts to be refused")
+	}
+}
@@ -0,0 +1,147 @@
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Read the events of an iCalendar (RFC 5545) file as blackout periods,
+// with each event's SUMMARY as the reason. Times without a zone are in
+// location. Recurring events aren't supported and are refused rather
+// than silently blacking out only their first occurrence
+func ParseICal(r io.Reader, location *time.Location) ([]Period, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	periods := []Period{}
+	var event map[string]icalProperty
+	for number, line := range lines {
+		property, err := parseProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", number+1, err)
+		}
+
+		switch {
+		case property.name == "BEGIN" && property.value == "VEVENT":
+			event = map[string]icalProperty{}
+		case property.name == "END" && property.value == "VEVENT":
+			if event == nil {
+				return nil, fmt.Errorf("line %v: END:VEVENT without BEGIN:VEVENT", number+1)
+			}
+			period, err := eventPeriod(event, location)
+			if err != nil {
+				return nil, fmt.Errorf("event ending on line %v: %v", number+1, err)
+			}
+			periods = append(periods, period)
+			event = nil
+		case event != nil:
+			event[property.name] = property
+		}
+	}
+	return periods, nil
+}
+
+type icalProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// join continuation lines, which start with a space or tab, onto the line before
+func unfold(r io.Reader) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// parse NAME;PARAM=VALUE;...:VALUE
+func parseProperty(line string) (icalProperty, error) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return icalProperty{}, fmt.Errorf("expected NAME:VALUE but got %q", line)
+	}
+	parts := strings.Split(line[:colon], ";")
+	property := icalProperty{
+		name:   strings.ToUpper(parts[0]),
+		params: map[string]string{},
+		value:  line[colon+1:],
+	}
+	for _, param := range parts[1:] {
+		if equals := strings.Index(param, "="); equals >= 0 {
+			property.params[strings.ToUpper(param[:equals])] = strings.Trim(param[equals+1:], `"`)
+		}
+	}
+	return property, nil
+}
+
+func eventPeriod(event map[string]icalProperty, location *time.Location) (Period, error) {
+	if _, recurring := event["RRULE"]; recurring {
+		return Period{}, fmt.Errorf("recurring events (RRULE) aren't supported")
+	}
+
+	dtstart, ok := event["DTSTART"]
+	if !ok {
+		return Period{}, fmt.Errorf("event has no DTSTART")
+	}
+	start, allDay, err := parseICalTime(dtstart, location)
+	if err != nil {
+		return Period{}, err
+	}
+
+	var end time.Time
+	if dtend, ok := event["DTEND"]; ok {
+		end, _, err = parseICalTime(dtend, location)
+		if err != nil {
+			return Period{}, err
+		}
+	} else if allDay {
+		// an all day event without an end lasts the day
+		end = start.AddDate(0, 0, 1)
+	} else {
+		return Period{}, fmt.Errorf("event starting %v has no DTEND", start)
+	}
+
+	return newPeriod(start, end, unescape(event["SUMMARY"].value))
+}
+
+// parse a DATE or DATE-TIME value, reporting whether it was a date
+func parseICalTime(property icalProperty, location *time.Location) (time.Time, bool, error) {
+	if tzid, ok := property.params["TZID"]; ok {
+		loaded, err := time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		location = loaded
+	}
+
+	value := property.value
+	switch {
+	case property.params["VALUE"] == "DATE" || len(value) == len("20060102"):
+		t, err := time.ParseInLocation("20060102", value, location)
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, location)
+	return t, false, err
+}
+
+func unescape(text string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(text)
+}
@@ -18,21 +18,60 @@ import (
 	"strings"
 )
 
+// Returned when the server answers with an error status
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("Server responded with status %v body %v", err.Status, err.Body)
+}
+
+// Whether err is the server answering with status code
+func IsStatus(err error, code int) bool {
+	status, ok := err.(*StatusError)
+	return ok && status.StatusCode == code
+}
+
 type Client struct {
-	baseUrl string
+	baseUrl   string
+	namespace string
 }
 
-func NewClient(baseUrl string) *Client {
+// A client for the allocations in one namespace of the server at baseUrl
+func NewClient(baseUrl string, namespace string) *Client {
 	return &Client{
-		baseUrl: baseUrl,
+		baseUrl:   baseUrl,
+		namespace: allocations.NamespaceOrDefault(namespace),
 	}
 }
 
-func (c *Client) List() (allocations.Allocations, error) {
-	fmt.Fprint(os.Stderr, color.BlueString("GET %v\n", c.baseUrl))
+// A client for the allocations in another namespace of the
+// same server, or this one if namespace is empty
+func (c *Client) Namespace(namespace string) *Client {
+	if namespace == "" {
+		return c
+	}
+	return NewClient(c.baseUrl, namespace)
+}
+
+// url of the namespace's allocations collection, or
+// of something inside it if path segments are given
+func (c *Client) allocationsUrl(path ...string) string {
+	parts := append([]string{c.baseUrl, "namespaces", c.namespace, "allocations"}, path...)
+	return strings.Join(parts, "/")
+}
+
+// List the allocations in the namespace, only those
+// matching selector if it isn't empty
+func (c *Client) List(selector string) (allocations.Allocations, error) {
+	url := withSelector(c.allocationsUrl(), selector)
+	fmt.Fprint(os.Stderr, color.BlueString("GET %v\n", url))
 
 	result, err := c.execute(
-		func() (*http.Response, error) { return http.Get(c.baseUrl) },
+		func() (*http.Response, error) { return http.Get(url) },
 		&allocations.Allocations{},
 	)
 
@@ -49,7 +88,7 @@ func (c *Client) List() (allocations.Allocations, error) {
 }
 
 func (c *Client) Get(name string) (*allocations.Allocation, error) {
-	url := strings.Join([]string{c.baseUrl, name}, "/")
+	url := c.allocationsUrl(name)
 	fmt.Fprint(os.Stderr, color.BlueString("GET %v\n", url))
 	result, err := c.execute(
 		func() (*http.Response, error) { return http.Get(url) },
@@ -68,7 +107,19 @@ func (c *Client) Get(name string) (*allocations.Allocation, error) {
 	return cast, nil
 }
 
+// Create or update the allocation. If it has a ResourceVersion the
+// server refuses the write unless the allocation is still at it
 func (c *Client) CreateOrUpdate(newAllocation *allocations.AllocationSpecification) (bool, error) {
+	return c.post(newAllocation, false)
+}
+
+// Create the allocation, which the server refuses if it exists
+func (c *Client) Create(newAllocation *allocations.AllocationSpecification) error {
+	_, err := c.post(newAllocation, true)
+	return err
+}
+
+func (c *Client) post(newAllocation *allocations.AllocationSpecification, createOnly bool) (bool, error) {
 
 	buffer := new(bytes.Buffer)
 	err := json.NewEncoder(buffer).Encode(newAllocation)
@@ -76,13 +127,31 @@ func (c *Client) CreateOrUpdate(newAllocation *allocations.AllocationSpecificati
 		return false, err
 	}
 
-	fmt.Fprint(os.Stderr, color.BlueString("POST %v\n", c.baseUrl))
+	// an allocation that names its namespace goes there,
+	// whatever namespace the client was created for
+	url := c.Namespace(newAllocation.Namespace).allocationsUrl()
+	fmt.Fprint(os.Stderr, color.BlueString("POST %v\n", url))
 
 	pretty, err := json.MarshalIndent(newAllocation, "", "    ")
 	fmt.Println(string(pretty))
 
+	req, err := http.NewRequest(http.MethodPost, url, buffer)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthor(req)
+	// ask the server to refuse the write if the
+	// allocation has changed since this version
+	if newAllocation.ResourceVersion != 0 {
+		req.Header.Set("If-Match", fmt.Sprintf("\"%v\"", newAllocation.ResourceVersion))
+	}
+	if createOnly {
+		req.Header.Set("If-None-Match", "*")
+	}
+
 	result, err := c.execute(
-		func() (*http.Response, error) { return http.Post(c.baseUrl, "application/json", buffer) },
+		func() (*http.Response, error) { return http.DefaultClient.Do(req) },
 		&map[string]bool{},
 	)
 
@@ -99,7 +168,7 @@ func (c *Client) CreateOrUpdate(newAllocation *allocations.AllocationSpecificati
 }
 
 func (c *Client) Delete(name string) error {
-	url := strings.Join([]string{c.baseUrl, name}, "/")
+	url := c.allocationsUrl(name)
 	fmt.Fprint(os.Stderr, color.BlueString("DELETE %v", url))
 	req, err := http.NewRequest(http.MethodDelete, url, nil)
 	if err != nil {
@@ -131,7 +200,7 @@ func (c *Client) execute(call func() (*http.Response, error), target interface{}
 		if err != nil {
 			return nil, err
 		}
-		return nil, fmt.Errorf("Server responded with status %v body %v", resp.Status, string(body))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// What the server did with an imported archive, and what the
+// imported allocations need that archives don't carry
+type ImportResult struct {
+	Imported                   []string `json:"Imported"`
+	Deleted                    []string `json:"Deleted"`
+	MissingSecrets             []string `json:"MissingSecrets"`
+	MissingRegistryCredentials []string `json:"MissingRegistryCredentials"`
+	MissingCalendars           []string `json:"MissingCalendars"`
+}
+
+// Write an archive of the server's whole state to w
+func (c *Client) Export(w io.Writer) error {
+	url := c.baseUrl + "/export"
+	fmt.Fprint(os.Stderr, color.BlueString("GET %v\n", url))
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Restore an archive written by Export, mode is merge or replace
+func (c *Client) Import(archive io.Reader, mode string) (*ImportResult, error) {
+	url := c.baseUrl + "/import?mode=" + mode
+	fmt.Fprint(os.Stderr, color.BlueString("POST %v\n", url))
+
+	result, err := c.execute(
+		func() (*http.Response, error) { return http.Post(url, "application/gzip", archive) },
+		&ImportResult{},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cast, ok := result.(*ImportResult)
+	if !ok {
+		return nil, errors.New("error casting response to *ImportResult")
+	}
+	return cast, nil
+}
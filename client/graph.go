@@ -0,0 +1,31 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/horthy/docket/allocations"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Get the trigger dependencies between the allocations in the client's namespace
+func (c *Client) Graph() (*allocations.Graph, error) {
+	target := strings.Join([]string{c.baseUrl, "namespaces", c.namespace, "graph"}, "/")
+	fmt.Fprint(os.Stderr, color.BlueString("GET %v\n", target))
+
+	result, err := c.execute(
+		func() (*http.Response, error) { return http.Get(target) },
+		&allocations.Graph{},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cast, ok := result.(*allocations.Graph)
+	if !ok {
+		return nil, errors.New("error casting response to *allocations.Graph")
+	}
+	return cast, nil
+}
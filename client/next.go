@@ -0,0 +1,41 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/horthy/docket/allocations"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Preview the next count runs of the allocation called name,
+// or if name is empty of the allocations matching selector
+func (c *Client) Next(name string, selector string, count int) ([]allocations.Preview, error) {
+	query := url.Values{}
+	query.Set("count", fmt.Sprintf("%v", count))
+	if name != "" {
+		query.Set("name", name)
+	}
+	if selector != "" {
+		query.Set("selector", selector)
+	}
+	target := strings.Join([]string{c.baseUrl, "namespaces", c.namespace, "next"}, "/") + "?" + query.Encode()
+	fmt.Fprint(os.Stderr, color.BlueString("GET %v\n", target))
+
+	result, err := c.execute(
+		func() (*http.Response, error) { return http.Get(target) },
+		&[]allocations.Preview{},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cast, ok := result.(*[]allocations.Preview)
+	if !ok {
+		return nil, errors.New("error casting response to *[]allocations.Preview")
+	}
+	return *cast, nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/horthy/docket/allocations"
+	"net/http"
+	"os"
+	"os/user"
+)
+
+// Attribute the changes a request makes to the local user
+func setAuthor(req *http.Request) {
+	name := os.Getenv("USER")
+	if current, err := user.Current(); name == "" && err == nil {
+		name = current.Username
+	}
+	if name != "" {
+		req.Header.Set("X-Docket-Author", name)
+	}
+}
+
+// Get every revision of an allocation, oldest first
+func (c *Client) Revisions(name string) ([]*allocations.Revision, error) {
+	url := c.allocationsUrl(name, "revisions")
+	fmt.Fprint(os.Stderr, color.BlueString("GET %v\n", url))
+
+	result, err := c.execute(
+		func() (*http.Response, error) { return http.Get(url) },
+		&[]*allocations.Revision{},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cast, ok := result.(*[]*allocations.Revision)
+	if !ok {
+		return nil, errors.New("error casting response to *[]*allocations.Revision")
+	}
+	return *cast, nil
+}
+
+// Restore the spec from revision to, returning the
+// revision the allocation is at afterwards
+func (c *Client) Rollback(name string, to int) (int, error) {
+	buffer := new(bytes.Buffer)
+	err := json.NewEncoder(buffer).Encode(map[string]int{"To": to})
+	if err != nil {
+		return 0, err
+	}
+
+	url := c.allocationsUrl(name, "rollback")
+	fmt.Fprint(os.Stderr, color.BlueString("POST %v\n", url))
+	req, err := http.NewRequest(http.MethodPost, url, buffer)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthor(req)
+
+	result, err := c.execute(
+		func() (*http.Response, error) { return http.DefaultClient.Do(req) },
+		&map[string]int{},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	cast, ok := result.(*map[string]int)
+	if !ok {
+		return 0, errors.New("error casting response to map[string]int")
+	}
+	return (*cast)["Revision"], nil
+}
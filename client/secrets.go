@@ -0,0 +1,71 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/horthy/docket/secrets"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func (c *Client) ListSecrets() ([]secrets.Info, error) {
+	url := strings.Join([]string{c.baseUrl, "secrets"}, "/")
+	fmt.Fprint(os.Stderr, color.BlueString("GET %v\n", url))
+
+	result, err := c.execute(
+		func() (*http.Response, error) { return http.Get(url) },
+		&[]secrets.Info{},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cast, ok := result.(*[]secrets.Info)
+	if !ok {
+		return nil, errors.New("error casting response to *[]secrets.Info")
+	}
+
+	return *cast, nil
+}
+
+func (c *Client) SetSecret(name string, value string) error {
+	buffer := new(bytes.Buffer)
+	err := json.NewEncoder(buffer).Encode(map[string]string{"Value": value})
+	if err != nil {
+		return err
+	}
+
+	url := strings.Join([]string{c.baseUrl, "secrets", name}, "/")
+	fmt.Fprint(os.Stderr, color.BlueString("PUT %v\n", url))
+	req, err := http.NewRequest(http.MethodPut, url, buffer)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err = c.execute(
+		func() (*http.Response, error) { return http.DefaultClient.Do(req) },
+		&map[string]bool{},
+	)
+	return err
+}
+
+func (c *Client) DeleteSecret(name string) error {
+	url := strings.Join([]string{c.baseUrl, "secrets", name}, "/")
+	fmt.Fprint(os.Stderr, color.BlueString("DELETE %v\n", url))
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.execute(
+		func() (*http.Response, error) { return http.DefaultClient.Do(req) },
+		&map[string]bool{},
+	)
+	return err
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+func withSelector(base string, selector string) string {
+	if selector == "" {
+		return base
+	}
+	return base + "?selector=" + url.QueryEscape(selector)
+}
+
+// Delete every allocation in the namespace matching selector,
+// returning the names of the deleted allocations
+func (c *Client) DeleteSelected(selector string) ([]string, error) {
+	target := withSelector(c.allocationsUrl(), selector)
+	fmt.Fprint(os.Stderr, color.BlueString("DELETE %v\n", target))
+	req, err := http.NewRequest(http.MethodDelete, target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.affected("deleted", func() (*http.Response, error) { return http.DefaultClient.Do(req) })
+}
+
+func (c *Client) Pause(name string, selector string) ([]string, error) {
+	return c.act("pause", "paused", name, selector)
+}
+
+func (c *Client) Resume(name string, selector string) ([]string, error) {
+	return c.act("resume", "resumed", name, selector)
+}
+
+// Run allocations now, regardless of their schedule
+func (c *Client) Trigger(name string, selector string) ([]string, error) {
+	return c.act("trigger", "triggered", name, selector)
+}
+
+// apply action to the allocation called name, or if name is empty
+// to the allocations matching selector
+func (c *Client) act(action string, past string, name string, selector string) ([]string, error) {
+	target := strings.Join([]string{c.baseUrl, "namespaces", c.namespace, action}, "/")
+	if name != "" {
+		target = c.allocationsUrl(name, action)
+	}
+	target = withSelector(target, selector)
+	fmt.Fprint(os.Stderr, color.BlueString("POST %v\n", target))
+
+	return c.affected(past, func() (*http.Response, error) { return http.Post(target, "application/json", nil) })
+}
+
+// run a bulk call and return the names of the allocations it affected
+func (c *Client) affected(past string, call func() (*http.Response, error)) ([]string, error) {
+	result, err := c.execute(call, &map[string][]string{})
+	if err != nil {
+		return nil, err
+	}
+
+	cast, ok := result.(*map[string][]string)
+	if !ok {
+		return nil, errors.New("error casting response to map[string][]string")
+	}
+	return (*cast)[past], nil
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/horthy/docket/allocations"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Stream changes to the namespace's allocations matching selector,
+// starting after sinceVersion, or with every current allocation if it's 0.
+// The channel is closed when the server ends the stream or cancel is
+// called, watch again from the last ResourceVersion received to resume
+func (c *Client) Watch(selector string, sinceVersion uint64) (<-chan allocations.WatchEvent, func(), error) {
+	query := url.Values{}
+	query.Set("watch", "true")
+	if selector != "" {
+		query.Set("selector", selector)
+	}
+	if sinceVersion != 0 {
+		query.Set("resourceVersion", fmt.Sprintf("%v", sinceVersion))
+	}
+	target := c.allocationsUrl() + "?" + query.Encode()
+	fmt.Fprint(os.Stderr, color.BlueString("GET %v\n", target))
+
+	resp, err := http.Get(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+
+	events := make(chan allocations.WatchEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(events)
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			event := allocations.WatchEvent{}
+			if err := decoder.Decode(&event); err != nil {
+				return
+			}
+			select {
+			case events <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	cancelled := false
+	cancel := func() {
+		if !cancelled {
+			cancelled = true
+			close(done)
+			resp.Body.Close()
+		}
+	}
+	return events, cancel, nil
+}
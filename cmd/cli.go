@@ -7,9 +7,17 @@ import (
 	"github.com/fatih/color"
 	"github.com/horthy/docket/allocations"
 	"github.com/horthy/docket/client"
+	"github.com/horthy/docket/crontab"
+	"github.com/horthy/docket/k8s"
+	"github.com/horthy/docket/manifest"
+	"github.com/horthy/docket/seal"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
 )
 
 type CLI struct {
@@ -24,19 +32,69 @@ func NewCli(cmd *cobra.Command, args []string) *CLI {
 	}
 }
 
+// Build a client from the --host and --namespace flags. Flags that
+// weren't given fall back to the active profile in the config file,
+// then to the flag's default
+func (cli *CLI) client() (*client.Client, error) {
+	host, err := cli.setting("host")
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := cli.setting("namespace")
+	if err != nil {
+		return nil, err
+	}
+	return client.NewClient(host, namespace), nil
+}
+
+func (cli *CLI) setting(name string) (string, error) {
+	flag := cli.cmd.Flags().Lookup(name)
+	if flag == nil {
+		return "", fmt.Errorf("command %v has no --%v flag", cli.cmd.Name(), name)
+	}
+	if flag.Changed {
+		return flag.Value.String(), nil
+	}
+
+	profile := viper.GetString("profile")
+	if profile != "" {
+		key := "profiles." + profile
+		if !viper.IsSet(key) {
+			return "", fmt.Errorf("profile %v not found in %v", profile, viper.ConfigFileUsed())
+		}
+		if value := viper.GetString(key + "." + name); value != "" {
+			return value, nil
+		}
+	}
+	return flag.Value.String(), nil
+}
+
 // TODO server needs to handle Not Found better here
 func (cli *CLI) Delete() error {
-	host, err := cli.cmd.Flags().GetString("host")
+	theClient, err := cli.client()
 	if err != nil {
 		return err
 	}
 
+	selector, _ := cli.cmd.Flags().GetString("selector")
+	if selector != "" {
+		if len(cli.args) != 0 {
+			return errors.New("give either a name or a --selector, not both")
+		}
+		deleted, err := theClient.DeleteSelected(selector)
+		if err != nil {
+			return err
+		}
+		color.Green("Deleted %v", strings.Join(deleted, ", "))
+		return nil
+	}
+
 	if len(cli.args) != 1 {
 		return errors.New("name is required")
 	}
 	name := cli.args[0]
 
-	err = client.NewClient(host).Delete(name)
+	err = theClient.Delete(name)
 	if err != nil {
 		return err
 	}
@@ -45,8 +103,40 @@ func (cli *CLI) Delete() error {
 	return nil
 }
 
+// Pause, resume or trigger the allocation named in args,
+// or every allocation matching the --selector flag
+func (cli *CLI) Act(action func(*client.Client, string, string) ([]string, error), past string) error {
+	theClient, err := cli.client()
+	if err != nil {
+		return err
+	}
+
+	selector, _ := cli.cmd.Flags().GetString("selector")
+	name := ""
+	switch {
+	case selector != "" && len(cli.args) != 0:
+		return errors.New("give either a name or a --selector, not both")
+	case selector == "" && len(cli.args) != 1:
+		return errors.New("name or --selector is required")
+	case selector == "":
+		name = cli.args[0]
+	}
+
+	affected, err := action(theClient, name, selector)
+	if err != nil {
+		return err
+	}
+
+	if len(affected) == 0 {
+		color.Yellow("No allocations matched")
+		return nil
+	}
+	color.Green("%v %v", past, strings.Join(affected, ", "))
+	return nil
+}
+
 func (cli *CLI) Get() error {
-	host, err := cli.cmd.Flags().GetString("host")
+	theClient, err := cli.client()
 	if err != nil {
 		return err
 	}
@@ -56,7 +146,7 @@ func (cli *CLI) Get() error {
 	}
 	name := cli.args[0]
 
-	allocation, err := client.NewClient(host).Get(name)
+	allocation, err := theClient.Get(name)
 	if err != nil {
 		return err
 	}
@@ -68,13 +158,62 @@ func (cli *CLI) Get() error {
 	fmt.Print(string(raw))
 	return nil
 }
+func (cli *CLI) Revisions() error {
+	theClient, err := cli.client()
+	if err != nil {
+		return err
+	}
+
+	if len(cli.args) != 1 {
+		return errors.New("name is required")
+	}
+
+	revisions, err := theClient.Revisions(cli.args[0])
+	if err != nil {
+		return err
+	}
+
+	bytes, _ := json.MarshalIndent(revisions, "", "    ")
+	fmt.Print(string(bytes))
+	return nil
+}
+
+func (cli *CLI) Rollback() error {
+	theClient, err := cli.client()
+	if err != nil {
+		return err
+	}
+
+	if len(cli.args) != 1 {
+		return errors.New("name is required")
+	}
+	name := cli.args[0]
+
+	to, _ := cli.cmd.Flags().GetInt("to")
+	if to < 1 {
+		return errors.New("--to REV is required")
+	}
+
+	revision, err := theClient.Rollback(name, to)
+	if err != nil {
+		return err
+	}
+
+	color.Green("Rolled %v back to revision %v, now at revision %v", name, to, revision)
+	return nil
+}
 
 func (cli *CLI) List() error {
-	host, err := cli.cmd.Flags().GetString("host")
+	theClient, err := cli.client()
 	if err != nil {
 		return err
 	}
-	allocations, err := client.NewClient(host).List()
+	selector, _ := cli.cmd.Flags().GetString("selector")
+	if watch, _ := cli.cmd.Flags().GetBool("watch"); watch {
+		return cli.watch(theClient, selector)
+	}
+
+	allocations, err := theClient.List(selector)
 	if err != nil {
 		return err
 	}
@@ -85,43 +224,547 @@ func (cli *CLI) List() error {
 }
 
 func (cli *CLI) Push() error {
-	host, err := cli.cmd.Flags().GetString("host")
+	theClient, err := cli.client()
 	if err != nil {
 		return err
 	}
 
-	var file string
+	entries, err := cli.load()
+	if err != nil {
+		return err
+	}
+	return cli.push(theClient, entries)
+}
+
+// Validate the entries, then create or update each
+func (cli *CLI) push(theClient *client.Client, entries []manifest.Entry) error {
+	report := manifest.NewReport(manifest.Validate(entries))
+	printFindings(report.Findings)
+	if !report.Valid {
+		return fmt.Errorf("not pushing, found %v", countFindings(report))
+	}
+
+	force, _ := cli.cmd.Flags().GetBool("force")
+	for _, entry := range entries {
+		allocation := entry.Spec
+		created, err := pushOne(theClient, allocation, force)
+		if client.IsStatus(err, http.StatusConflict) {
+			return fmt.Errorf("%v: allocation %v changed while pushing, push again to overwrite it: %v", entry.Source, allocation.Name, err)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: allocation %v: %v", entry.Source, allocation.Name, err)
+		}
+
+		if created {
+			color.Green("Created allocation %v", allocation.Name)
+		} else {
+			color.Green("Updated allocation %v", allocation.Name)
+		}
+	}
+
+	return nil
+}
+
+// Write the allocation, unless force guarding the write with the
+// version last read, so that a change someone else makes in between
+// is refused rather than overwritten. A ResourceVersion in the
+// specification is used as the version last read
+func pushOne(theClient *client.Client, allocation *allocations.AllocationSpecification, force bool) (bool, error) {
+	if force || allocation.ResourceVersion != 0 {
+		return theClient.CreateOrUpdate(allocation)
+	}
+
+	current, err := theClient.Namespace(allocation.Namespace).Get(allocation.Name)
+	if client.IsStatus(err, http.StatusNotFound) {
+		return true, theClient.Create(allocation)
+	}
+	if err != nil {
+		return false, err
+	}
+	allocation.ResourceVersion = current.ResourceVersion
+	return theClient.CreateOrUpdate(allocation)
+}
+
+// Convert the crontab named in args to allocations that run each
+// command in --image, and print them as yaml or push them with --push
+func (cli *CLI) ImportCrontab() error {
 	if len(cli.args) != 1 {
-		file = "docket.yml"
+		return errors.New("expected a crontab file, or - for stdin")
+	}
+	file := cli.args[0]
+	image, _ := cli.cmd.Flags().GetString("image")
+	if image == "" {
+		return errors.New("--image is required")
 	}
-	file = cli.args[0]
 
-	data, err := ioutil.ReadFile(file)
+	in := os.Stdin
+	if file != manifest.Stdin {
+		var err error
+		in, err = os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	jobs, err := crontab.Parse(in)
+	if err != nil {
+		return fmt.Errorf("%v: %v", file, err)
+	}
+
+	options := crontab.Options{Image: image}
+	options.Prefix, _ = cli.cmd.Flags().GetString("prefix")
+	options.TimeZone, _ = cli.cmd.Flags().GetString("timezone")
+	if namespace := cli.cmd.Flags().Lookup("namespace"); namespace.Changed {
+		options.Namespace = namespace.Value.String()
+	}
+	specs := crontab.Convert(jobs, options)
+
+	if push, _ := cli.cmd.Flags().GetBool("push"); !push {
+		out, err := yaml.Marshal(specs)
+		if err != nil {
+			return err
+		}
+		fmt.Print("---\n" + string(out))
+		return nil
+	}
+
+	theClient, err := cli.client()
 	if err != nil {
 		return err
 	}
+	entries := []manifest.Entry{}
+	for i, spec := range specs {
+		entries = append(entries, manifest.Entry{
+			Spec:   spec,
+			Source: manifest.Source{File: file, Line: jobs[i].Line},
+		})
+	}
+	return cli.push(theClient, entries)
+}
 
-	specs := []*allocations.AllocationSpecification{}
+// Print the specifications push would send, with
+// templates and vars expanded
+func (cli *CLI) Render() error {
+	entries, err := cli.load()
+	if err != nil {
+		return err
+	}
 
-	err = yaml.Unmarshal(data, &specs)
+	specs := []*allocations.AllocationSpecification{}
+	for _, entry := range entries {
+		specs = append(specs, entry.Spec)
+	}
+	out, err := yaml.Marshal(specs)
 	if err != nil {
 		return err
 	}
+	fmt.Print("---\n" + string(out))
+	return nil
+}
+
+// Translate between kubernetes CronJobs and allocations, printing the
+// result as yaml, and what couldn't be translated to stderr
+func (cli *CLI) Convert() error {
+	from, _ := cli.cmd.Flags().GetString("from")
+	to, _ := cli.cmd.Flags().GetString("to")
+
+	findings := []manifest.Finding{}
+	documents := []interface{}{}
+	switch {
+	case from == "k8s" && to == "":
+		paths := cli.args
+		if len(paths) == 0 {
+			paths = []string{manifest.Stdin}
+		}
+		files, err := manifest.Expand(paths)
+		if err != nil {
+			return err
+		}
+		specs := []*allocations.AllocationSpecification{}
+		for _, file := range files {
+			entries, found, err := cli.readCronJobs(file)
+			if err != nil {
+				return err
+			}
+			findings = append(findings, found...)
+			for _, entry := range entries {
+				specs = append(specs, entry.Spec)
+			}
+		}
+		documents = append(documents, specs)
+	case to == "k8s" && from == "":
+		entries, err := cli.load()
+		if err != nil {
+			return err
+		}
+		jobs, found := k8s.ToCronJobs(entries)
+		findings = append(findings, found...)
+		// one document per CronJob, as kubectl expects
+		for _, job := range jobs {
+			documents = append(documents, job)
+		}
+	default:
+		return errors.New("expected either --from k8s or --to k8s")
+	}
 
-	theClient := client.NewClient(host)
-	for _, allocation := range specs {
-		created, err := theClient.CreateOrUpdate(allocation)
+	report := manifest.NewReport(findings)
+	printFindings(report.Findings)
+	for _, document := range documents {
+		bytes, err := yaml.Marshal(document)
 		if err != nil {
 			return err
 		}
+		fmt.Print("---\n" + string(bytes))
+	}
 
-		if created {
-			color.Green("Created allocation %v", allocation.Name)
+	strict, _ := cli.cmd.Flags().GetBool("strict")
+	if !report.Valid || strict && report.Warnings > 0 {
+		return fmt.Errorf("found %v", countFindings(report))
+	}
+	return nil
+}
+
+func (cli *CLI) readCronJobs(file string) ([]manifest.Entry, []manifest.Finding, error) {
+	if file == manifest.Stdin {
+		return k8s.FromCronJobs("<stdin>", os.Stdin)
+	}
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer in.Close()
+	return k8s.FromCronJobs(file, in)
+}
+
+// Check the specifications push would send without talking to the
+// server, and print what was found, as json with -o json
+func (cli *CLI) Validate() error {
+	overrides, err := cli.overrides()
+	if err != nil {
+		return err
+	}
+	entries, errs := manifest.Read(cli.args, os.Stdin, overrides)
+	findings := manifest.ErrorFindings(errs)
+	findings = append(findings, manifest.Validate(entries)...)
+	report := manifest.NewReport(findings)
+
+	output, _ := cli.cmd.Flags().GetString("output")
+	switch output {
+	case "json":
+		bytes, _ := json.MarshalIndent(report, "", "    ")
+		fmt.Println(string(bytes))
+	case "", "text":
+		printFindings(report.Findings)
+		if report.Valid && report.Warnings == 0 {
+			color.Green("%v allocations are valid", len(entries))
+		}
+	default:
+		return fmt.Errorf("unknown output %v, expected text or json", output)
+	}
+
+	strict, _ := cli.cmd.Flags().GetBool("strict")
+	if !report.Valid || strict && report.Warnings > 0 {
+		return fmt.Errorf("found %v", countFindings(report))
+	}
+	return nil
+}
+
+func printFindings(findings []manifest.Finding) {
+	for _, finding := range findings {
+		if finding.Severity == manifest.SeverityError {
+			fmt.Fprintln(os.Stderr, color.RedString(finding.String()))
 		} else {
-			color.Green("Updated allocation %v", allocation.Name)
+			fmt.Fprintln(os.Stderr, color.YellowString(finding.String()))
+		}
+	}
+}
+
+func countFindings(report manifest.Report) string {
+	return fmt.Sprintf("%v errors and %v warnings", report.Errors, report.Warnings)
+}
+
+// Read the specifications in the files, directories and globs named
+// by the args, or docket.yml, with vars from --values and then --set
+// overriding those in the files
+func (cli *CLI) load() ([]manifest.Entry, error) {
+	overrides, err := cli.overrides()
+	if err != nil {
+		return nil, err
+	}
+	return manifest.Load(cli.args, os.Stdin, overrides)
+}
+
+// vars from --values, then --set
+func (cli *CLI) overrides() (manifest.Overrides, error) {
+	overrides := manifest.Overrides{}
+
+	valuesFile, _ := cli.cmd.Flags().GetString("values")
+	if valuesFile != "" {
+		values, err := manifest.ReadValues(valuesFile)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			overrides[key] = value
+		}
+	}
+
+	sets, _ := cli.cmd.Flags().GetStringArray("set")
+	set, err := manifest.ParseSet(sets)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range set {
+		overrides[key] = value
+	}
+	return overrides, nil
+}
+
+func (cli *CLI) Seal() error {
+	keyFile, err := cli.cmd.Flags().GetString("key-file")
+	if err != nil {
+		return err
+	}
+
+	if len(cli.args) != 1 {
+		return errors.New("file is required")
+	}
+
+	key, err := seal.KeyFromFile(keyFile)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := ioutil.ReadFile(cli.args[0])
+	if err != nil {
+		return err
+	}
+
+	sealed, err := seal.Seal(key, plaintext)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(sealed)
+	return err
+}
+
+func (cli *CLI) SetSecret() error {
+	theClient, err := cli.client()
+	if err != nil {
+		return err
+	}
+
+	if len(cli.args) < 1 || len(cli.args) > 2 {
+		return errors.New("name is required")
+	}
+	name := cli.args[0]
+
+	// reading from stdin keeps the value out of shell history
+	var value string
+	if len(cli.args) == 2 {
+		value = cli.args[1]
+	} else {
+		raw, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimRight(string(raw), "\r\n")
+	}
+
+	err = theClient.SetSecret(name, value)
+	if err != nil {
+		return err
+	}
+
+	color.Green("Stored secret %v", name)
+	return nil
+}
+
+// print changes as they happen, until the server goes away
+func (cli *CLI) watch(theClient *client.Client, selector string) error {
+	events, cancel, err := theClient.Watch(selector, 0)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	for event := range events {
+		line := fmt.Sprintf("%v\t%v/%v\t%v", event.Type, event.Allocation.Namespace, event.Allocation.Name, event.ResourceVersion)
+		if event.Run != nil {
+			line += fmt.Sprintf("\trun %v %v", event.Run.ID, event.Run.Status)
+		}
+		fmt.Println(line)
+	}
+	return errors.New("watch closed by the server")
+}
+
+// Write the server's state to stdout, or the --output file
+func (cli *CLI) Export() error {
+	theClient, err := cli.client()
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if output, _ := cli.cmd.Flags().GetString("output"); output != "" {
+		out, err = os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
 		}
+		defer out.Close()
 	}
 
+	return theClient.Export(out)
+}
+
+// Restore an archive from the file in args, or stdin
+func (cli *CLI) Import() error {
+	theClient, err := cli.client()
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if len(cli.args) > 1 {
+		return errors.New("expected at most one archive file")
+	}
+	if len(cli.args) == 1 && cli.args[0] != "-" {
+		in, err = os.Open(cli.args[0])
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	mode, _ := cli.cmd.Flags().GetString("mode")
+	result, err := theClient.Import(in, mode)
+	if err != nil {
+		return err
+	}
+
+	color.Green("Imported %v", strings.Join(result.Imported, ", "))
+	if len(result.Deleted) > 0 {
+		color.Yellow("Deleted %v", strings.Join(result.Deleted, ", "))
+	}
+	if len(result.MissingSecrets) > 0 {
+		color.Red("Secrets the imported allocations need that aren't set: %v", strings.Join(result.MissingSecrets, ", "))
+	}
+	if len(result.MissingRegistryCredentials) > 0 {
+		color.Red("Registry credentials the imported allocations need that aren't loaded: %v", strings.Join(result.MissingRegistryCredentials, ", "))
+	}
+	if len(result.MissingCalendars) > 0 {
+		color.Red("Blackout calendars the imported allocations need that aren't loaded: %v", strings.Join(result.MissingCalendars, ", "))
+	}
+	return nil
+}
+
+// Print when the allocation named in args, or those
+// matching --selector, will next run
+func (cli *CLI) Next() error {
+	theClient, err := cli.client()
+	if err != nil {
+		return err
+	}
+
+	selector, _ := cli.cmd.Flags().GetString("selector")
+	count, _ := cli.cmd.Flags().GetInt("count")
+	name := ""
+	if len(cli.args) > 1 {
+		return errors.New("expected at most one name")
+	}
+	if len(cli.args) == 1 {
+		name = cli.args[0]
+	}
+
+	previews, err := theClient.Next(name, selector, count)
+	if err != nil {
+		return err
+	}
+
+	const layout = "2006-01-02 15:04:05 MST"
+	for _, preview := range previews {
+		heading := fmt.Sprintf("%v/%v", preview.Namespace, preview.Name)
+		if preview.Paused {
+			heading += " (paused)"
+		}
+		color.Cyan(heading)
+		switch {
+		case preview.Completed != nil:
+			fmt.Printf("  completed at %v\n", preview.Completed.Local().Format(layout))
+		case len(preview.Runs) == 0:
+			fmt.Println("  no upcoming runs")
+		}
+		for _, run := range preview.Runs {
+			line := "  " + run.Scheduled.Local().Format(layout)
+			switch {
+			case preview.Splay:
+				line += fmt.Sprintf(", starts at %v (splay of %v)", run.Earliest.Local().Format(layout), preview.Jitter)
+			case preview.Jitter != "":
+				line += fmt.Sprintf(", starts between %v and %v (jitter of %v)", run.Earliest.Local().Format(layout), run.Latest.Local().Format(layout), preview.Jitter)
+			}
+			if run.Skipped != "" {
+				line += color.YellowString(", skipped (%v)", run.Skipped)
+			}
+			fmt.Println(line)
+		}
+	}
+	return nil
+}
+
+func (cli *CLI) ListSecrets() error {
+	theClient, err := cli.client()
+	if err != nil {
+		return err
+	}
+
+	list, err := theClient.ListSecrets()
+	if err != nil {
+		return err
+	}
+
+	bytes, _ := json.MarshalIndent(list, "", "    ")
+	fmt.Print(string(bytes))
 	return nil
+}
+
+func (cli *CLI) DeleteSecret() error {
+	theClient, err := cli.client()
+	if err != nil {
+		return err
+	}
 
+	if len(cli.args) != 1 {
+		return errors.New("name is required")
+	}
+	name := cli.args[0]
+
+	err = theClient.DeleteSecret(name)
+	if err != nil {
+		return err
+	}
+
+	color.Green("Deleted secret %v", name)
+	return nil
+}
+
+func (cli *CLI) Graph() error {
+	theClient, err := cli.client()
+	if err != nil {
+		return err
+	}
+
+	graph, err := theClient.Graph()
+	if err != nil {
+		return err
+	}
+
+	if len(graph.Edges) == 0 {
+		fmt.Println("No allocations are triggered by others")
+		return nil
+	}
+	for _, edge := range graph.Edges {
+		fmt.Printf("%v -> %v (on %v)\n", edge.From, edge.To, edge.On)
+	}
+	return nil
 }
@@ -0,0 +1,38 @@
+// Copyright © Copyright 2016 Dexter Horthy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// convertCmd represents the convert command
+var convertCmd = &cobra.Command{
+	Use:   "convert --from k8s | --to k8s [FILE | DIR | GLOB | -]...",
+	Short: "Translate between kubernetes CronJob yaml and allocations",
+	Long:  "TODO",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return NewCli(cmd, args).Convert()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(convertCmd)
+	convertCmd.Flags().String("from", "", "Read this format and print allocations, k8s for batch/v1 CronJobs")
+	convertCmd.Flags().String("to", "", "Read allocations and print this format, k8s for batch/v1 CronJobs")
+	convertCmd.Flags().Bool("strict", false, "Fail if any field can't be represented")
+	convertCmd.Flags().StringArray("set", []string{}, "Set a template var, e.g. --set tenant=acme")
+	convertCmd.Flags().String("values", "", "Yaml file of template vars")
+}
@@ -20,8 +20,8 @@ import (
 
 // deleteCmd represents the delete command
 var deleteCmd = &cobra.Command{
-	Use:   "delete NAME",
-	Short: "Delete an Allocation by name",
+	Use:   "delete [NAME | -l SELECTOR]",
+	Short: "Delete an Allocation by name, or every Allocation matching a label selector",
 	Long:  "TODO",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return NewCli(cmd, args).Delete()
@@ -31,4 +31,5 @@ var deleteCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(deleteCmd)
 	deleteCmd.Flags().String("host", "http://localhost:3000", "The host to use")
+	deleteCmd.Flags().StringP("selector", "l", "", "Label selector, e.g. team=data,env!=prod")
 }
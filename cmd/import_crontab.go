@@ -0,0 +1,39 @@
+// Copyright © Copyright 2016 Dexter Horthy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// importCrontabCmd represents the import crontab command
+var importCrontabCmd = &cobra.Command{
+	Use:   "crontab FILE",
+	Short: "Convert a crontab to allocations that run each command in an image",
+	Long:  "TODO",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return NewCli(cmd, args).ImportCrontab()
+	},
+}
+
+func init() {
+	importCmd.AddCommand(importCrontabCmd)
+	importCrontabCmd.Flags().String("host", "http://localhost:3000", "The host to use")
+	importCrontabCmd.Flags().String("image", "", "The image to run each command's shell in")
+	importCrontabCmd.Flags().String("prefix", "", "Put this and a dash before each allocation's name")
+	importCrontabCmd.Flags().String("timezone", "", "IANA time zone of the host the crontab came from, for jobs without CRON_TZ or TZ (default the server's)")
+	importCrontabCmd.Flags().Bool("push", false, "Push the allocations instead of printing them as yaml")
+	importCrontabCmd.Flags().Bool("force", false, "With --push, overwrite allocations even if they change while pushing")
+}
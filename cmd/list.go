@@ -21,7 +21,7 @@ import (
 // listCmd represents the list command
 var listCmd = &cobra.Command{
 	Use:   "list",
-	Short: "Get a list of all Allocations",
+	Short: "Get a list of all Allocations, optionally filtered by a label selector",
 	Long:  "TODO",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return NewCli(cmd, args).List()
@@ -31,4 +31,6 @@ var listCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(listCmd)
 	listCmd.Flags().String("host", "http://localhost:3000", "The host to use")
+	listCmd.Flags().StringP("selector", "l", "", "Label selector, e.g. team=data,env!=prod")
+	listCmd.Flags().BoolP("watch", "w", false, "Print each allocation, then changes to them as they happen")
 }
@@ -0,0 +1,36 @@
+// Copyright © Copyright 2016 Dexter Horthy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// nextCmd represents the next command
+var nextCmd = &cobra.Command{
+	Use:   "next [NAME | -l SELECTOR]",
+	Short: "Preview when Allocations will next run, including Jitter and Splay",
+	Long:  "TODO",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return NewCli(cmd, args).Next()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(nextCmd)
+	nextCmd.Flags().String("host", "http://localhost:3000", "The host to use")
+	nextCmd.Flags().StringP("selector", "l", "", "Label selector, e.g. team=data,env!=prod")
+	nextCmd.Flags().IntP("count", "c", 5, "How many runs to show for each allocation")
+}
@@ -20,8 +20,8 @@ import (
 
 // pushCmd represents the push command
 var pushCmd = &cobra.Command{
-	Use:   "push",
-	Short: "Push one or more allocations from a Yaml file",
+	Use:   "push [FILE | DIR | GLOB | -]...",
+	Short: "Push allocations from Yaml or JSON files, directories, globs or stdin",
 	Long:  "TODO",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return NewCli(cmd, args).Push()
@@ -31,4 +31,7 @@ var pushCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(pushCmd)
 	pushCmd.Flags().String("host", "http://localhost:3000", "The host to use")
+	pushCmd.Flags().StringArray("set", []string{}, "Set a template var, e.g. --set tenant=acme")
+	pushCmd.Flags().String("values", "", "Yaml file of template vars")
+	pushCmd.Flags().Bool("force", false, "Overwrite allocations even if they change while pushing")
 }
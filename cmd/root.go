@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/horthy/docket/allocations"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -47,6 +48,9 @@ func init() {
 	// will be global for your application.
 
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.docket.yaml)")
+	RootCmd.PersistentFlags().StringP("namespace", "n", allocations.DefaultNamespace, "The namespace to use")
+	RootCmd.PersistentFlags().String("profile", "", "Profile from the config file to take the default host and namespace from")
+	viper.BindPFlag("profile", RootCmd.PersistentFlags().Lookup("profile"))
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
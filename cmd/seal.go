@@ -0,0 +1,46 @@
+// Copyright © Copyright 2016 Dexter Horthy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// sealCmd represents the seal command
+var sealCmd = &cobra.Command{
+	Use:   "seal FILE",
+	Short: "Encrypt a file, e.g. registry credentials, for the server to load",
+	Long: `Encrypt a file with the server's key and write the result to stdout.
+
+To give the server private registry credentials, write them as json
+
+    {
+        "quay": {"serveraddress": "quay.io", "username": "me", "password": "..."}
+    }
+
+then
+
+    docket seal --key-file docket.key creds.json > creds.sealed
+    docket server --key-file docket.key --registry-credentials creds.sealed
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return NewCli(cmd, args).Seal()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(sealCmd)
+	sealCmd.Flags().String("key-file", "", "File holding the server's key")
+}
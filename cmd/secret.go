@@ -0,0 +1,66 @@
+// Copyright © Copyright 2016 Dexter Horthy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// secretCmd groups the secret subcommands
+var secretCmd = &cobra.Command{
+	Use:   "secret",
+	Short: "Manage secrets that allocations can expose to their containers",
+	Long: `Manage secrets stored on the server.
+
+Values can be set but never read back, allocations reference secrets by
+name in their Secrets section and the runner injects the value when it
+creates the container.
+`,
+}
+
+var secretSetCmd = &cobra.Command{
+	Use:   "set NAME [VALUE]",
+	Short: "Create or update a secret, reading the value from stdin if it isn't given",
+	Long:  "TODO",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return NewCli(cmd, args).SetSecret()
+	},
+}
+
+var secretListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the names of all secrets",
+	Long:  "TODO",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return NewCli(cmd, args).ListSecrets()
+	},
+}
+
+var secretDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete a secret by name",
+	Long:  "TODO",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return NewCli(cmd, args).DeleteSecret()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(secretCmd)
+	secretCmd.PersistentFlags().String("host", "http://localhost:3000", "The host to use")
+	secretCmd.AddCommand(secretSetCmd)
+	secretCmd.AddCommand(secretListCmd)
+	secretCmd.AddCommand(secretDeleteCmd)
+}
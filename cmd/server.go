@@ -15,8 +15,15 @@
 package cmd
 
 import (
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/calendar"
+	"github.com/horthy/docket/notify"
+	"github.com/horthy/docket/run"
 	"github.com/horthy/docket/server"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"log"
+	"time"
 )
 
 // serverCmd represents the server command
@@ -25,13 +32,113 @@ var serverCmd = &cobra.Command{
 	Short: "Run the docket server",
 	Long:  `TODO`,
 	Run: func(cmd *cobra.Command, args []string) {
-		server.Start()
+		server.Start(serverConfig())
 	},
 }
 
+// server settings can come from flags or from the docket config file
+func serverConfig() server.Config {
+	quotas := server.Quotas{}
+	err := viper.UnmarshalKey("namespaces", &quotas)
+	if err != nil {
+		log.Fatalf("Invalid namespaces in config file, error was %v", err)
+	}
+
+	calendars := map[string]calendar.Spec{}
+	err = viper.UnmarshalKey("calendars", &calendars)
+	if err != nil {
+		log.Fatalf("Invalid calendars in config file, error was %v", err)
+	}
+
+	limits := run.Limits{
+		Workers:       viper.GetInt("queue.workers"),
+		MaxConcurrent: viper.GetInt("queue.max-concurrent"),
+	}
+	for key, limit := range map[string]*map[string]int{"queue.per-image": &limits.PerImage, "queue.per-label": &limits.PerLabel} {
+		err := viper.UnmarshalKey(key, limit)
+		if err != nil {
+			log.Fatalf("Invalid %v in config file, error was %v", key, err)
+		}
+	}
+
+	return server.Config{
+		WebhookSecret: viper.GetString("webhook-secret"),
+		SMTP: notify.SMTPConfig{
+			Host:     viper.GetString("smtp.host"),
+			Port:     viper.GetInt("smtp.port"),
+			Username: viper.GetString("smtp.username"),
+			Password: viper.GetString("smtp.password"),
+			From:     viper.GetString("smtp.from"),
+			Digest:   viper.GetDuration("smtp.digest"),
+		},
+		KeyFile:             viper.GetString("key-file"),
+		DockerConfig:        viper.GetString("docker-config"),
+		RegistryCredentials: viper.GetString("registry-credentials"),
+		SecretsFile:         viper.GetString("secrets-file"),
+		Namespaces:          quotas,
+		Retention: allocations.Retention{
+			KeepRuns:        viper.GetInt("retention.keep-runs"),
+			KeepDays:        viper.GetInt("retention.keep-days"),
+			KeepFailureDays: viper.GetInt("retention.keep-failure-days"),
+			KeepLogs:        viper.GetInt("retention.keep-logs"),
+		},
+		CompactInterval: viper.GetDuration("retention.compact-interval"),
+		StoreFile:       viper.GetString("store-file"),
+		LeaseTTL:        viper.GetDuration("lease-ttl"),
+		ServerID:        viper.GetString("server-id"),
+		Queue:           limits,
+		Calendars:       calendars,
+	}
+}
+
 func init() {
 	RootCmd.AddCommand(serverCmd)
 
 	// TODO flags for port, backend, docker, etc
+	serverCmd.Flags().String("webhook-secret", "", "Secret used to sign outbound webhook payloads")
+	viper.BindPFlag("webhook-secret", serverCmd.Flags().Lookup("webhook-secret"))
+
+	// smtp.password is deliberately not a flag, set it in
+	// the config file so it doesn't show up in `ps`
+	serverCmd.Flags().String("smtp-host", "", "SMTP relay for email notifications")
+	serverCmd.Flags().Int("smtp-port", 25, "Port of the SMTP relay")
+	serverCmd.Flags().String("smtp-username", "", "Username for the SMTP relay, if it needs auth")
+	serverCmd.Flags().String("smtp-from", "docket@localhost", "From address for email notifications")
+	serverCmd.Flags().Duration("smtp-digest", 0, "Batch failure emails over this window instead of sending each one")
+	for _, name := range []string{"host", "port", "username", "from", "digest"} {
+		viper.BindPFlag("smtp."+name, serverCmd.Flags().Lookup("smtp-"+name))
+	}
+
+	serverCmd.Flags().String("key-file", "", "File holding the key for files encrypted with `docket seal`")
+	serverCmd.Flags().String("docker-config", "", "Docker config.json to load registry credentials from")
+	serverCmd.Flags().String("registry-credentials", "", "Registry credentials file encrypted with `docket seal`")
+	serverCmd.Flags().String("secrets-file", "", "File to keep secrets in, encrypted with the key in --key-file")
+	for _, name := range []string{"key-file", "docker-config", "registry-credentials", "secrets-file"} {
+		viper.BindPFlag(name, serverCmd.Flags().Lookup(name))
+	}
+
+	// allocations can override these with their own Retention, 0 means no limit
+	serverCmd.Flags().Int("keep-runs", 100, "Keep at most this many runs per allocation")
+	serverCmd.Flags().Int("keep-days", 7, "Drop successful runs older than this many days")
+	serverCmd.Flags().Int("keep-failure-days", 30, "Drop failed runs older than this many days")
+	serverCmd.Flags().Int("keep-logs", 500, "Keep at most this many log lines per allocation")
+	serverCmd.Flags().Duration("compact-interval", 10*time.Minute, "How often to prune history")
+	for _, name := range []string{"keep-runs", "keep-days", "keep-failure-days", "keep-logs", "compact-interval"} {
+		viper.BindPFlag("retention."+name, serverCmd.Flags().Lookup(name))
+	}
+
+	serverCmd.Flags().String("store-file", "", "File to keep allocations in, shared by servers that take turns scheduling")
+	serverCmd.Flags().Duration("lease-ttl", 15*time.Second, "How long the scheduler lease lasts without renewal")
+	serverCmd.Flags().String("server-id", "", "This server's name in the lease, defaults to hostname-pid")
+	for _, name := range []string{"store-file", "lease-ttl", "server-id"} {
+		viper.BindPFlag(name, serverCmd.Flags().Lookup(name))
+	}
+
+	// per-image and per-label limits are only set in the config file
+	serverCmd.Flags().Int("workers", 4, "How many runs can happen at once")
+	serverCmd.Flags().Int("max-concurrent", 0, "Most containers running at once, 0 for as many as there are workers")
+	for _, name := range []string{"workers", "max-concurrent"} {
+		viper.BindPFlag("queue."+name, serverCmd.Flags().Lookup(name))
+	}
 
 }
@@ -0,0 +1,37 @@
+// Copyright © Copyright 2016 Dexter Horthy
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate [FILE | DIR | GLOB | -]...",
+	Short: "Check allocations for errors and likely mistakes without pushing them",
+	Long:  "TODO",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return NewCli(cmd, args).Validate()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().StringP("output", "o", "text", "Output format, text or json")
+	validateCmd.Flags().Bool("strict", false, "Fail on warnings as well as errors")
+	validateCmd.Flags().StringArray("set", []string{}, "Set a template var, e.g. --set tenant=acme")
+	validateCmd.Flags().String("values", "", "Yaml file of template vars")
+}
@@ -0,0 +1,86 @@
+package crontab
+
+import (
+	"fmt"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/allocations"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// How jobs become allocations
+type Options struct {
+	// the image to run each command's shell in
+	Image     string
+	Namespace string
+	// put before each allocation's name
+	Prefix string
+	// the zone of the host the crontab came from, for jobs that
+	// don't set CRON_TZ or TZ. Empty means the server's
+	TimeZone string
+}
+
+var unnamed = regexp.MustCompile(`[^a-z0-9]+`)
+
+// An allocation specification per job, running its command with
+// Shell -c in the image. Names come from the command, e.g. backup-sh
+// for /usr/local/bin/backup.sh --full, and are made unique
+func Convert(jobs []Job, options Options) []*allocations.AllocationSpecification {
+	specs := []*allocations.AllocationSpecification{}
+	taken := map[string]bool{}
+	for _, job := range jobs {
+		name := uniqueName(jobName(job, options.Prefix), taken)
+
+		timeZone := job.TimeZone
+		if timeZone == "" {
+			timeZone = options.TimeZone
+		}
+
+		env := []string{}
+		for key, value := range job.Env {
+			env = append(env, key+"="+value)
+		}
+		sort.Strings(env)
+
+		specs = append(specs, &allocations.AllocationSpecification{
+			Name:      name,
+			Namespace: options.Namespace,
+			Cron:      job.Schedule,
+			TimeZone:  timeZone,
+			Container: allocations.CreateContainerOptions{
+				Config: &docker.Config{
+					Image: options.Image,
+					Cmd:   []string{job.Shell, "-c", job.Command},
+					Env:   env,
+				},
+				HostConfig: &docker.HostConfig{AutoRemove: true},
+			},
+		})
+	}
+	return specs
+}
+
+func jobName(job Job, prefix string) string {
+	name := ""
+	if fields := strings.Fields(job.Command); len(fields) > 0 {
+		name = strings.Trim(unnamed.ReplaceAllString(strings.ToLower(path.Base(fields[0])), "-"), "-")
+	}
+	if name == "" {
+		name = fmt.Sprintf("line-%v", job.Line)
+	}
+	if prefix != "" {
+		name = prefix + "-" + name
+	}
+	return name
+}
+
+func uniqueName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%v-%v", name, i)
+	}
+	taken[unique] = true
+	return unique
+}
@@ -0,0 +1,165 @@
+package crontab
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gorhill/cronexpr"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// The shell commands run through when a crontab doesn't set SHELL, as cron does
+const DefaultShell = "/bin/sh"
+
+// macros and the five-field schedule each stands for
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// variables that configure cron itself rather than the commands it runs
+var cronVariables = map[string]bool{
+	"SHELL":    true,
+	"CRON_TZ":  true,
+	"MAILTO":   true,
+	"MAILFROM": true,
+}
+
+var envLine = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+
+// One command from a crontab, with the environment cron would run it in
+type Job struct {
+	// where the job is in the file, from 1
+	Line int
+	// a five-field cron expression, with any macro expanded
+	Schedule string
+	Command  string
+	Shell    string
+	// the IANA zone from CRON_TZ, or TZ, set before the job, if any
+	TimeZone string
+	// the variables set before the job, except those for cron itself
+	Env map[string]string
+}
+
+// Read the jobs in a crontab in the standard five-field format, with
+// comments, @daily style macros and NAME=value lines, which apply to
+// the jobs after them. @reboot and commands with unescaped %, which
+// cron turns into standard input, have no equivalent and are refused
+func Parse(r io.Reader) ([]Job, error) {
+	jobs := []Job{}
+	env := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if match := envLine.FindStringSubmatch(line); match != nil {
+			env[match[1]] = unquote(match[2])
+			continue
+		}
+
+		job, err := parseJob(line, env)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", number, err)
+		}
+		job.Line = number
+		jobs = append(jobs, job)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func parseJob(line string, env map[string]string) (Job, error) {
+	job := Job{Shell: DefaultShell, Env: map[string]string{}}
+	if shell := env["SHELL"]; shell != "" {
+		job.Shell = shell
+	}
+	job.TimeZone = env["CRON_TZ"]
+	if job.TimeZone == "" {
+		job.TimeZone = env["TZ"]
+	}
+	for name, value := range env {
+		if !cronVariables[name] {
+			job.Env[name] = value
+		}
+	}
+
+	var command string
+	if strings.HasPrefix(line, "@") {
+		fields := strings.Fields(line)
+		if fields[0] == "@reboot" {
+			return job, fmt.Errorf("@reboot has no equivalent, docket doesn't know when hosts boot")
+		}
+		schedule, ok := macros[fields[0]]
+		if !ok {
+			return job, fmt.Errorf("unknown macro %v", fields[0])
+		}
+		job.Schedule = schedule
+		command = strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	} else {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			return job, fmt.Errorf("expected five schedule fields and a command")
+		}
+		job.Schedule = strings.Join(fields[:5], " ")
+		// keep the command's own spacing
+		rest := line
+		for _, field := range fields[:5] {
+			rest = strings.TrimLeft(rest, " \t")[len(field):]
+		}
+		command = strings.TrimSpace(rest)
+	}
+	if command == "" {
+		return job, fmt.Errorf("no command")
+	}
+
+	if _, err := cronexpr.Parse(job.Schedule); err != nil {
+		return job, fmt.Errorf("schedule %q: %v", job.Schedule, err)
+	}
+
+	command, stdin := unescapePercent(command)
+	if stdin {
+		return job, fmt.Errorf("unescaped %% sends input to the command, which isn't supported, escape it as \\%%")
+	}
+	job.Command = command
+	return job, nil
+}
+
+// replace \% with %, reporting whether there were any unescaped %
+func unescapePercent(command string) (string, bool) {
+	out := strings.Builder{}
+	stdin := false
+	for i := 0; i < len(command); i++ {
+		switch {
+		case command[i] == '\\' && i+1 < len(command) && command[i+1] == '%':
+			out.WriteByte('%')
+			i++
+		case command[i] == '%':
+			stdin = true
+			out.WriteByte('%')
+		default:
+			out.WriteByte(command[i])
+		}
+	}
+	return out.String(), stdin
+}
+
+func unquote(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,89 @@
+package crontab
+
+import (
+	"github.com/gorhill/cronexpr"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const example = `# m h dom mon dow command
+SHELL=/bin/bash
+PATH = "/usr/local/bin:/usr/bin:/bin"
+MAILTO=ops@example.com
+
+*/15 * * * *   /usr/local/bin/backup.sh --full   > /var/log/backup.log 2>&1
+CRON_TZ=America/New_York
+@daily  date +\%Y-\%m-\%d >> /tmp/dates
+0 3 * * 7 /usr/local/bin/backup.sh --prune
+`
+
+func TestParse(t *testing.T) {
+	jobs, err := Parse(strings.NewReader(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"PATH": "/usr/local/bin:/usr/bin:/bin"}
+	expected := []Job{
+		{Line: 6, Schedule: "*/15 * * * *", Command: "/usr/local/bin/backup.sh --full   > /var/log/backup.log 2>&1", Shell: "/bin/bash", Env: env},
+		{Line: 8, Schedule: "0 0 * * *", Command: "date +%Y-%m-%d >> /tmp/dates", Shell: "/bin/bash", TimeZone: "America/New_York", Env: env},
+		{Line: 9, Schedule: "0 3 * * 7", Command: "/usr/local/bin/backup.sh --prune", Shell: "/bin/bash", TimeZone: "America/New_York", Env: env},
+	}
+	if !reflect.DeepEqual(jobs, expected) {
+		t.Errorf("expected %+v but got %+v", expected, jobs)
+	}
+
+	// 7 is Sunday, as in cron
+	saturday := time.Date(2016, 12, 10, 12, 0, 0, 0, time.UTC)
+	if next := cronexpr.MustParse(jobs[2].Schedule).Next(saturday); next.Weekday() != time.Sunday {
+		t.Errorf("expected day 7 to be Sunday but next run was %v", next)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for crontab, expected := range map[string]string{
+		"# reboot\n@reboot /bin/true\n": "line 2: @reboot has no equivalent, docket doesn't know when hosts boot",
+		"@fortnightly /bin/true\n":      "line 1: unknown macro @fortnightly",
+		"0 3 * * /bin/true\n":           "line 1: expected five schedule fields and a command",
+		"0 3 * * mon-fry /bin/true\n":   `line 1: schedule "0 3 * * mon-fry": syntax error in day-of-week field: 'mon-fry'`,
+		"0 3 * * * echo a%b\n":          `line 1: unescaped % sends input to the command, which isn't supported, escape it as \%`,
+		"FOO=bar\n@hourly\n":            "line 2: no command",
+	} {
+		_, err := Parse(strings.NewReader(crontab))
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected %q but got %v", expected, err)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	jobs, err := Parse(strings.NewReader(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+	specs := Convert(jobs, Options{Image: "acme/ops:2.1", Prefix: "web1", TimeZone: "Europe/London"})
+
+	names := []string{}
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+		if problems := spec.Check(); len(problems) > 0 {
+			t.Errorf("expected %v to be valid but got %v", spec.Name, problems)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"web1-backup-sh", "web1-date", "web1-backup-sh-2"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+
+	backup := specs[0]
+	if backup.TimeZone != "Europe/London" || specs[1].TimeZone != "America/New_York" {
+		t.Errorf("expected the default zone unless the crontab set one but got %v and %v", backup.TimeZone, specs[1].TimeZone)
+	}
+	config := backup.Container.Config
+	if config.Image != "acme/ops:2.1" || !reflect.DeepEqual(config.Cmd, []string{"/bin/bash", "-c", jobs[0].Command}) {
+		t.Errorf("expected the command to run through bash in the image but got %v %v", config.Image, config.Cmd)
+	}
+	if !reflect.DeepEqual(config.Env, []string{"PATH=/usr/local/bin:/usr/bin:/bin"}) {
+		t.Errorf("expected only PATH in the environment but got %v", config.Env)
+	}
+}
@@ -0,0 +1,264 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/manifest"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The parts of a batch/v1 CronJob docket can translate
+type CronJob struct {
+	APIVersion string      `yaml:"apiVersion"`
+	Kind       string      `yaml:"kind"`
+	Metadata   ObjectMeta  `yaml:"metadata"`
+	Spec       CronJobSpec `yaml:"spec"`
+}
+
+type ObjectMeta struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+type CronJobSpec struct {
+	Schedule          string      `yaml:"schedule"`
+	TimeZone          string      `yaml:"timeZone,omitempty"`
+	ConcurrencyPolicy string      `yaml:"concurrencyPolicy"`
+	JobTemplate       JobTemplate `yaml:"jobTemplate"`
+}
+
+type JobTemplate struct {
+	Spec JobSpec `yaml:"spec"`
+}
+
+type JobSpec struct {
+	ActiveDeadlineSeconds int64       `yaml:"activeDeadlineSeconds,omitempty"`
+	BackoffLimit          int         `yaml:"backoffLimit"`
+	Template              PodTemplate `yaml:"template"`
+}
+
+type PodTemplate struct {
+	Spec PodSpec `yaml:"spec"`
+}
+
+type PodSpec struct {
+	RestartPolicy string      `yaml:"restartPolicy"`
+	Containers    []Container `yaml:"containers"`
+}
+
+type Container struct {
+	Name            string     `yaml:"name"`
+	Image           string     `yaml:"image"`
+	Command         []string   `yaml:"command,omitempty"`
+	Args            []string   `yaml:"args,omitempty"`
+	Env             []EnvVar   `yaml:"env,omitempty"`
+	WorkingDir      string     `yaml:"workingDir,omitempty"`
+	Resources       *Resources `yaml:"resources,omitempty"`
+	ImagePullPolicy string     `yaml:"imagePullPolicy,omitempty"`
+}
+
+type EnvVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type Resources struct {
+	Limits   map[string]string `yaml:"limits,omitempty"`
+	Requests map[string]string `yaml:"requests,omitempty"`
+}
+
+// the specification fields ToCronJobs translates, the rest are reported
+var translatedFields = []string{"Name", "Namespace", "Labels", "Cron", "TimeZone", "Container", "Timeout", "PullPolicy", "ResourceVersion"}
+
+// Translate allocation specifications to CronJobs. Fields a CronJob can't
+// represent are reported as warnings, and allocations that can't be
+// CronJobs at all, like those without a Cron or with Steps, as errors
+func ToCronJobs(entries []manifest.Entry) ([]CronJob, []manifest.Finding) {
+	jobs := []CronJob{}
+	findings := []manifest.Finding{}
+	for _, entry := range entries {
+		spec := entry.Spec
+		report := func(severity manifest.Severity, field string, message string) {
+			findings = append(findings, manifest.Finding{
+				File:       entry.Source.File,
+				Line:       entry.Source.Line,
+				Allocation: allocations.NamespaceOrDefault(spec.Namespace) + "/" + spec.Name,
+				Field:      field,
+				Severity:   severity,
+				Message:    message,
+			})
+		}
+
+		if spec.Cron == "" {
+			report(manifest.SeverityError, "Cron", "only allocations with a Cron can be CronJobs")
+			continue
+		}
+		schedule, err := kubeSchedule(spec.Cron)
+		if err != nil {
+			report(manifest.SeverityError, "Cron", err.Error())
+			continue
+		}
+		if len(spec.Steps) > 0 || spec.Container.Config == nil {
+			report(manifest.SeverityError, "Steps", "only allocations with a Container can be CronJobs")
+			continue
+		}
+
+		for _, field := range unrepresented(spec, translatedFields...) {
+			report(manifest.SeverityWarning, field, "can't be represented in a CronJob")
+		}
+
+		container, dropped := kubeContainer(spec.Name, spec.Container)
+		for _, field := range dropped {
+			report(manifest.SeverityWarning, "Container."+field, "can't be represented in a CronJob")
+		}
+		container.ImagePullPolicy = string(spec.PullPolicy)
+
+		job := CronJob{
+			APIVersion: "batch/v1",
+			Kind:       "CronJob",
+			Metadata: ObjectMeta{
+				Name:      spec.Name,
+				Namespace: spec.Namespace,
+				Labels:    spec.Labels,
+			},
+			Spec: CronJobSpec{
+				Schedule: schedule,
+				TimeZone: spec.TimeZone,
+				// docket starts a run even if the last one is still going
+				ConcurrencyPolicy: "Allow",
+			},
+		}
+		jobSpec := &job.Spec.JobTemplate.Spec
+		if spec.Timeout != "" {
+			timeout, _ := time.ParseDuration(spec.Timeout)
+			jobSpec.ActiveDeadlineSeconds = int64(math.Ceil(timeout.Seconds()))
+		}
+		// docket doesn't retry failed runs
+		jobSpec.BackoffLimit = 0
+		jobSpec.Template.Spec = PodSpec{RestartPolicy: "Never", Containers: []Container{container}}
+		jobs = append(jobs, job)
+	}
+	return jobs, findings
+}
+
+func kubeContainer(name string, options allocations.CreateContainerOptions) (Container, []string) {
+	config := options.Config
+	container := Container{
+		Name:       name,
+		Image:      config.Image,
+		Command:    config.Entrypoint,
+		Args:       config.Cmd,
+		WorkingDir: config.WorkingDir,
+	}
+	dropped := []string{}
+	for _, field := range unrepresented(config, "Image", "Entrypoint", "Cmd", "WorkingDir", "Env") {
+		dropped = append(dropped, "Config."+field)
+	}
+	for _, variable := range config.Env {
+		equals := strings.Index(variable, "=")
+		if equals < 0 {
+			// taken from docker's own environment
+			dropped = append(dropped, "Config.Env."+variable)
+			continue
+		}
+		container.Env = append(container.Env, EnvVar{Name: variable[:equals], Value: variable[equals+1:]})
+	}
+
+	if host := options.HostConfig; host != nil {
+		resources := &Resources{Limits: map[string]string{}, Requests: map[string]string{}}
+		if host.Memory > 0 {
+			resources.Limits["memory"] = formatMemory(host.Memory)
+		}
+		if host.NanoCPUs > 0 {
+			resources.Limits["cpu"] = formatCPU(host.NanoCPUs)
+		}
+		if host.MemoryReservation > 0 {
+			resources.Requests["memory"] = formatMemory(host.MemoryReservation)
+		}
+		if len(resources.Limits)+len(resources.Requests) > 0 {
+			container.Resources = resources
+		}
+		// kubernetes cleans up a job's pods along with the job
+		for _, field := range unrepresented(host, "Memory", "NanoCpus", "MemoryReservation", "AutoRemove") {
+			dropped = append(dropped, "HostConfig."+field)
+		}
+	}
+	if options.NetworkingConfig != nil {
+		for _, field := range unrepresented(options.NetworkingConfig) {
+			dropped = append(dropped, "NetworkingConfig."+field)
+		}
+	}
+	return container, dropped
+}
+
+// A docket cron expression as a five-field kubernetes schedule. Six
+// fields end with a year, seven also start with seconds, and neither
+// can be represented unless they're * and 0
+func kubeSchedule(cron string) (string, error) {
+	fields := strings.Fields(cron)
+	switch {
+	case len(fields) == 1 && strings.HasPrefix(cron, "@"):
+		return cron, nil
+	case len(fields) == 5:
+		return cron, nil
+	case len(fields) == 6 && fields[5] == "*":
+		return strings.Join(fields[:5], " "), nil
+	case len(fields) == 7 && fields[0] == "0" && fields[6] == "*":
+		return strings.Join(fields[1:6], " "), nil
+	case len(fields) == 6 || len(fields) == 7:
+		return "", fmt.Errorf("%q can't be represented, a CronJob schedule has no seconds or years", cron)
+	}
+	return "", fmt.Errorf("can't translate %q", cron)
+}
+
+// The json fields of value that are set, other than handled
+func unrepresented(value interface{}, handled ...string) []string {
+	encoded, _ := json.Marshal(value)
+	fields := map[string]interface{}{}
+	json.Unmarshal(encoded, &fields)
+	for _, field := range handled {
+		delete(fields, field)
+	}
+
+	set := []string{}
+	for field, value := range fields {
+		if !isZero(value) {
+			set = append(set, field)
+		}
+	}
+	sort.Strings(set)
+	return set
+}
+
+func isZero(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !value
+	case float64:
+		return value == 0
+	case string:
+		return value == ""
+	case []interface{}:
+		for _, each := range value {
+			if !isZero(each) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		for _, each := range value {
+			if !isZero(each) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
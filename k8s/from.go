@@ -0,0 +1,314 @@
+package k8s
+
+import (
+	"fmt"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorhill/cronexpr"
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/manifest"
+	"gopkg.in/yaml.v3"
+	"io"
+	"sort"
+	"strings"
+)
+
+// fields the api server fills in, which say nothing about the job
+var serverFields = []string{"uid", "resourceVersion", "creationTimestamp", "generation", "managedFields", "selfLink"}
+
+// pod and container defaults kubectl get shows, which docket behaves like anyway
+var defaultFields = []string{"terminationMessagePath", "terminationMessagePolicy", "dnsPolicy", "schedulerName"}
+
+// Translate the CronJobs in a kubernetes yaml file, which can hold
+// several documents and List kinds. Every field that can't be
+// represented is reported, with its line, rather than dropped, as are
+// documents that aren't CronJobs. CronJobs that can't be translated
+// at all, e.g. because of their schedule, are errors
+func FromCronJobs(file string, r io.Reader) ([]manifest.Entry, []manifest.Finding, error) {
+	converter := &fromKube{file: file, findings: []manifest.Finding{}}
+	entries := []manifest.Entry{}
+	decoder := yaml.NewDecoder(r)
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v: %v", file, err)
+		}
+		if len(document.Content) == 0 {
+			continue
+		}
+		entries = append(entries, converter.document(document.Content[0])...)
+	}
+	sort.SliceStable(converter.findings, func(i, j int) bool {
+		return converter.findings[i].Line < converter.findings[j].Line
+	})
+	return entries, converter.findings, nil
+}
+
+type fromKube struct {
+	file     string
+	findings []manifest.Finding
+	// the allocation being translated, for findings
+	allocation string
+	objects    []*object
+}
+
+func (c *fromKube) report(node *yaml.Node, severity manifest.Severity, field string, message string) {
+	line := 0
+	if node != nil {
+		line = node.Line
+	}
+	c.findings = append(c.findings, manifest.Finding{
+		File:       c.file,
+		Line:       line,
+		Allocation: c.allocation,
+		Field:      field,
+		Severity:   severity,
+		Message:    message,
+	})
+}
+
+func (c *fromKube) document(root *yaml.Node) []manifest.Entry {
+	c.allocation = ""
+	c.objects = nil
+	doc := c.object("", root)
+	switch kind := doc.string("kind"); kind {
+	case "CronJob":
+		entry, ok := c.cronJob(doc)
+		if !ok {
+			return nil
+		}
+		c.reportUnused()
+		return []manifest.Entry{entry}
+	case "List":
+		entries := []manifest.Entry{}
+		items := doc.value("items")
+		if items == nil {
+			return entries
+		}
+		for _, item := range items.Content {
+			entries = append(entries, c.document(item)...)
+		}
+		return entries
+	default:
+		c.report(root, manifest.SeverityWarning, "kind", fmt.Sprintf("%v isn't a CronJob, skipped", kind))
+		return nil
+	}
+}
+
+func (c *fromKube) cronJob(doc *object) (manifest.Entry, bool) {
+	doc.ignore("apiVersion", "status")
+	spec := &allocations.AllocationSpecification{}
+	entry := manifest.Entry{Spec: spec, Source: manifest.Source{File: c.file, Line: doc.node.Line}}
+
+	metadata := c.object("metadata", doc.value("metadata"))
+	metadata.ignore(serverFields...)
+	spec.Name = metadata.string("name")
+	spec.Namespace = metadata.string("namespace")
+	metadata.decode("labels", &spec.Labels)
+	c.allocation = allocations.NamespaceOrDefault(spec.Namespace) + "/" + spec.Name
+
+	cronJob := c.object("spec", doc.value("spec"))
+	if !c.schedule(cronJob, spec) {
+		return entry, false
+	}
+	if zone := cronJob.string("timeZone"); zone != "" {
+		spec.TimeZone = zone
+	}
+	if policy := cronJob.value("concurrencyPolicy"); policy != nil && policy.Value != "Allow" {
+		c.report(policy, manifest.SeverityWarning, "spec.concurrencyPolicy", fmt.Sprintf("%v can't be represented, docket starts a run even if the last one is still going", policy.Value))
+	}
+	if suspend := cronJob.value("suspend"); suspend != nil && suspend.Value == "true" {
+		c.report(suspend, manifest.SeverityWarning, "spec.suspend", fmt.Sprintf("can't be represented in a specification, run docket pause %v after pushing", spec.Name))
+	}
+
+	jobTemplate := c.object("spec.jobTemplate", cronJob.value("jobTemplate"))
+	job := c.object("spec.jobTemplate.spec", jobTemplate.value("spec"))
+	var deadline int64
+	if job.decode("activeDeadlineSeconds", &deadline) && deadline > 0 {
+		spec.Timeout = fmt.Sprintf("%vs", deadline)
+	}
+	if backoff := job.value("backoffLimit"); backoff != nil && backoff.Value != "0" {
+		c.report(backoff, manifest.SeverityWarning, "spec.jobTemplate.spec.backoffLimit", "can't be represented, docket doesn't retry failed runs")
+	}
+
+	template := c.object("spec.jobTemplate.spec.template", job.value("template"))
+	pod := c.object("spec.jobTemplate.spec.template.spec", template.value("spec"))
+	pod.ignore(defaultFields...)
+	if restart := pod.value("restartPolicy"); restart != nil && restart.Value != "Never" {
+		c.report(restart, manifest.SeverityWarning, "spec.jobTemplate.spec.template.spec.restartPolicy", fmt.Sprintf("%v can't be represented, docket doesn't restart failed containers", restart.Value))
+	}
+
+	containers := pod.value("containers")
+	if containers == nil || len(containers.Content) == 0 {
+		c.report(pod.node, manifest.SeverityError, "spec.jobTemplate.spec.template.spec.containers", "a CronJob needs a container, skipped")
+		return entry, false
+	}
+	for i, container := range containers.Content[1:] {
+		c.report(container, manifest.SeverityWarning, fmt.Sprintf("spec.jobTemplate.spec.template.spec.containers[%v]", i+1), "can't be represented, an allocation runs one container")
+	}
+	c.container(c.object("spec.jobTemplate.spec.template.spec.containers[0]", containers.Content[0]), spec)
+	return entry, true
+}
+
+// translate the schedule, returning false if it can't be
+func (c *fromKube) schedule(cronJob *object, spec *allocations.AllocationSpecification) bool {
+	node := cronJob.value("schedule")
+	if node == nil {
+		c.report(cronJob.node, manifest.SeverityError, "spec.schedule", "a CronJob needs a schedule, skipped")
+		return false
+	}
+	schedule := strings.TrimSpace(node.Value)
+
+	// the zone prefix kubernetes used to accept
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if strings.HasPrefix(schedule, prefix) {
+			fields := strings.SplitN(schedule, " ", 2)
+			spec.TimeZone = strings.TrimPrefix(fields[0], prefix)
+			schedule = ""
+			if len(fields) == 2 {
+				schedule = strings.TrimSpace(fields[1])
+			}
+		}
+	}
+
+	switch {
+	case schedule == "@midnight":
+		schedule = "0 0 * * *"
+	case strings.HasPrefix(schedule, "@every "):
+		spec.Every = strings.TrimSpace(strings.TrimPrefix(schedule, "@every "))
+		return true
+	}
+	if _, err := cronexpr.Parse(schedule); err != nil {
+		c.report(node, manifest.SeverityError, "spec.schedule", fmt.Sprintf("can't translate %q: %v, skipped", node.Value, err))
+		return false
+	}
+	spec.Cron = schedule
+	return true
+}
+
+func (c *fromKube) container(container *object, spec *allocations.AllocationSpecification) {
+	// kubernetes needs container names, docket names the allocation
+	container.ignore("name")
+	config := &docker.Config{Image: container.string("image")}
+	host := &docker.HostConfig{}
+	spec.Container = allocations.CreateContainerOptions{Config: config, HostConfig: host}
+
+	container.decode("command", &config.Entrypoint)
+	container.decode("args", &config.Cmd)
+	config.WorkingDir = container.string("workingDir")
+	spec.PullPolicy = allocations.PullPolicy(container.string("imagePullPolicy"))
+
+	if env := container.value("env"); env != nil {
+		for i, node := range env.Content {
+			variable := c.object(fmt.Sprintf("%v.env[%v]", container.path, i), node)
+			name := variable.string("name")
+			if from := variable.value("valueFrom"); from != nil {
+				variable.ignore("value")
+				c.report(from, manifest.SeverityWarning, variable.path+".valueFrom", fmt.Sprintf("%v can't be represented, docket secrets are set with docket secret and Secrets", name))
+				continue
+			}
+			config.Env = append(config.Env, name+"="+variable.string("value"))
+		}
+	}
+
+	resources := c.object(container.path+".resources", container.value("resources"))
+	limits := c.object(resources.path+".limits", resources.value("limits"))
+	requests := c.object(resources.path+".requests", resources.value("requests"))
+	c.quantity(limits, "memory", &host.Memory, parseMemory)
+	c.quantity(limits, "cpu", &host.NanoCPUs, parseCPU)
+	c.quantity(requests, "memory", &host.MemoryReservation, parseMemory)
+}
+
+func (c *fromKube) quantity(resources *object, name string, into *int64, parse func(string) (int64, error)) {
+	node := resources.value(name)
+	if node == nil {
+		return
+	}
+	value, err := parse(node.Value)
+	if err != nil {
+		c.report(node, manifest.SeverityWarning, resources.path+"."+name, fmt.Sprintf("%v, dropped", err))
+		return
+	}
+	*into = value
+}
+
+// report every key no one looked at, except empty ones
+func (c *fromKube) reportUnused() {
+	for _, object := range c.objects {
+		for _, key := range object.unused() {
+			c.report(key, manifest.SeverityWarning, strings.TrimPrefix(object.path+"."+key.Value, "."), "can't be represented, dropped")
+		}
+	}
+}
+
+// A yaml mapping whose keys are marked as they're translated,
+// so the ones left over can be reported
+type object struct {
+	path string
+	// nil if the mapping wasn't there
+	node *yaml.Node
+	used map[string]bool
+}
+
+func (c *fromKube) object(path string, node *yaml.Node) *object {
+	if node != nil && node.Kind != yaml.MappingNode {
+		c.report(node, manifest.SeverityWarning, path, "expected a mapping, dropped")
+		node = nil
+	}
+	o := &object{path: path, node: node, used: map[string]bool{}}
+	c.objects = append(c.objects, o)
+	return o
+}
+
+// the value under key, or nil, marking key used
+func (o *object) value(key string) *yaml.Node {
+	if o.node == nil {
+		return nil
+	}
+	for i := 0; i+1 < len(o.node.Content); i += 2 {
+		if o.node.Content[i].Value == key {
+			o.used[key] = true
+			return o.node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func (o *object) string(key string) string {
+	if node := o.value(key); node != nil {
+		return node.Value
+	}
+	return ""
+}
+
+// decode the value under key into out, returning whether there was one
+func (o *object) decode(key string, out interface{}) bool {
+	node := o.value(key)
+	return node != nil && node.Decode(out) == nil
+}
+
+func (o *object) ignore(keys ...string) {
+	for _, key := range keys {
+		o.used[key] = true
+	}
+}
+
+// the keys of set values no one looked at
+func (o *object) unused() []*yaml.Node {
+	keys := []*yaml.Node{}
+	if o.node == nil {
+		return keys
+	}
+	for i := 0; i+1 < len(o.node.Content); i += 2 {
+		key, value := o.node.Content[i], o.node.Content[i+1]
+		empty := value.Kind == yaml.ScalarNode && (value.Tag == "!!null" || value.Value == "") ||
+			value.Kind != yaml.ScalarNode && len(value.Content) == 0
+		if !o.used[key.Value] && !empty {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
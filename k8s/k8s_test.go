@@ -0,0 +1,202 @@
+package k8s
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/manifest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const cronJobs = `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+  uid: 0b3e
+  annotations: {owner: data}
+spec:
+  schedule: "CRON_TZ=Europe/Berlin 0 2 * * *"
+  concurrencyPolicy: Forbid
+  suspend: true
+  jobTemplate:
+    spec:
+      activeDeadlineSeconds: 600
+      template:
+        spec:
+          restartPolicy: OnFailure
+          dnsPolicy: ClusterFirst
+          securityContext: {}
+          containers:
+          - name: report
+            image: acme/report:1.2
+            command: [/bin/report]
+            args: [--since, 24h]
+            env:
+            - {name: MODE, value: full}
+            - name: TOKEN
+              valueFrom: {secretKeyRef: {name: report, key: token}}
+            resources:
+              limits: {memory: 512Mi, cpu: 1500m}
+              requests: {memory: 256Mi}
+          - name: sidecar
+            image: envoy
+---
+kind: List
+items:
+- kind: CronJob
+  metadata: {name: every}
+  spec:
+    schedule: "@every 90s"
+    jobTemplate: {spec: {template: {spec: {containers: [{image: alpine}]}}}}
+- kind: CronJob
+  metadata: {name: broken}
+  spec:
+    schedule: "every tuesday"
+- kind: Service
+`
+
+func TestFromCronJobs(t *testing.T) {
+	entries, findings, err := FromCronJobs("jobs.yml", strings.NewReader(cronJobs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Source.Line != 1 || entries[1].Spec.Every != "90s" {
+		t.Fatalf("expected report and every but got %+v", entries)
+	}
+
+	report := entries[0].Spec
+	expected := &allocations.AllocationSpecification{
+		Name:     "report",
+		Cron:     "0 2 * * *",
+		TimeZone: "Europe/Berlin",
+		Timeout:  "600s",
+		Container: allocations.CreateContainerOptions{
+			Config: &docker.Config{
+				Image:      "acme/report:1.2",
+				Entrypoint: []string{"/bin/report"},
+				Cmd:        []string{"--since", "24h"},
+				Env:        []string{"MODE=full"},
+			},
+			HostConfig: &docker.HostConfig{Memory: 512 << 20, NanoCPUs: 1500000000, MemoryReservation: 256 << 20},
+		},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("expected %+v but got %+v", expected, report)
+	}
+
+	found := []string{}
+	for _, finding := range findings {
+		found = append(found, finding.String())
+	}
+	want := []string{
+		"jobs.yml:6: warning: allocation default/report: metadata.annotations: can't be represented, dropped",
+		"jobs.yml:9: warning: allocation default/report: spec.concurrencyPolicy: Forbid can't be represented, docket starts a run even if the last one is still going",
+		"jobs.yml:10: warning: allocation default/report: spec.suspend: can't be represented in a specification, run docket pause report after pushing",
+		"jobs.yml:16: warning: allocation default/report: spec.jobTemplate.spec.template.spec.restartPolicy: OnFailure can't be represented, docket doesn't restart failed containers",
+		"jobs.yml:27: warning: allocation default/report: spec.jobTemplate.spec.template.spec.containers[0].env[1].valueFrom: TOKEN can't be represented, docket secrets are set with docket secret and Secrets",
+		"jobs.yml:31: warning: allocation default/report: spec.jobTemplate.spec.template.spec.containers[1]: can't be represented, an allocation runs one container",
+		`jobs.yml:44: error: allocation default/broken: spec.schedule: can't translate "every tuesday": missing field(s), skipped`,
+		"jobs.yml:45: warning: kind: Service isn't a CronJob, skipped",
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("expected\n%v\nbut got\n%v", strings.Join(want, "\n"), strings.Join(found, "\n"))
+	}
+}
+
+func TestToCronJobs(t *testing.T) {
+	spec := &allocations.AllocationSpecification{
+		Name:       "report",
+		Namespace:  "analytics",
+		Cron:       "0 0 2 * * * *",
+		Timeout:    "90s",
+		PullPolicy: allocations.PullIfNotPresent,
+		Jitter:     "5m",
+		Container: allocations.CreateContainerOptions{
+			Config: &docker.Config{
+				Image: "acme/report:1.2",
+				Cmd:   []string{"--since", "24h"},
+				Env:   []string{"MODE=full"},
+				User:  "nobody",
+			},
+			HostConfig: &docker.HostConfig{AutoRemove: true, Memory: 512 << 20, NanoCPUs: 250000000},
+		},
+	}
+	every := &allocations.AllocationSpecification{Name: "poll", Every: "90s"}
+	seconds := &allocations.AllocationSpecification{Name: "fast", Cron: "*/10 * * * * * *"}
+	entries := []manifest.Entry{
+		{Spec: spec, Source: manifest.Source{File: "jobs.yml", Line: 1}},
+		{Spec: every, Source: manifest.Source{File: "jobs.yml", Line: 20}},
+		{Spec: seconds, Source: manifest.Source{File: "jobs.yml", Line: 30}},
+	}
+
+	jobs, findings := ToCronJobs(entries)
+	if len(jobs) != 1 {
+		t.Fatalf("expected only report to translate but got %+v", jobs)
+	}
+	job := jobs[0]
+	container := job.Spec.JobTemplate.Spec.Template.Spec.Containers[0]
+	if job.Spec.Schedule != "0 2 * * *" || job.Metadata.Namespace != "analytics" || job.Spec.JobTemplate.Spec.ActiveDeadlineSeconds != 90 {
+		t.Errorf("unexpected CronJob %+v", job)
+	}
+	expected := Container{
+		Name:            "report",
+		Image:           "acme/report:1.2",
+		Args:            []string{"--since", "24h"},
+		Env:             []EnvVar{{Name: "MODE", Value: "full"}},
+		Resources:       &Resources{Limits: map[string]string{"memory": "512Mi", "cpu": "250m"}, Requests: map[string]string{}},
+		ImagePullPolicy: "IfNotPresent",
+	}
+	if !reflect.DeepEqual(container, expected) {
+		t.Errorf("expected %+v but got %+v", expected, container)
+	}
+
+	found := []string{}
+	for _, finding := range findings {
+		found = append(found, finding.String())
+	}
+	want := []string{
+		"jobs.yml:1: warning: allocation analytics/report: Jitter: can't be represented in a CronJob",
+		"jobs.yml:1: warning: allocation analytics/report: Container.Config.User: can't be represented in a CronJob",
+		"jobs.yml:20: error: allocation default/poll: Cron: only allocations with a Cron can be CronJobs",
+		`jobs.yml:30: error: allocation default/fast: Cron: "*/10 * * * * * *" can't be represented, a CronJob schedule has no seconds or years`,
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("expected\n%v\nbut got\n%v", strings.Join(want, "\n"), strings.Join(found, "\n"))
+	}
+}
+
+func TestKubeSchedule(t *testing.T) {
+	for cron, expected := range map[string]string{
+		"*/5 * * * *":    "*/5 * * * *",
+		"@daily":         "@daily",
+		"0 2 * * * *":    "0 2 * * *",
+		"0 30 2 * * * *": "30 2 * * *",
+		"0 2 * * * 2027": "",
+		"30 0 2 * * * *": "",
+	} {
+		schedule, err := kubeSchedule(cron)
+		if schedule != expected || (expected == "") != (err != nil) {
+			t.Errorf("%v: expected %q but got %q, %v", cron, expected, schedule, err)
+		}
+	}
+}
+
+func TestQuantities(t *testing.T) {
+	for quantity, expected := range map[string]int64{"512Mi": 512 << 20, "1G": 1e9, "1.5Ki": 1536, "100": 100} {
+		if bytes, err := parseMemory(quantity); err != nil || bytes != expected {
+			t.Errorf("%v: expected %v but got %v, %v", quantity, expected, bytes, err)
+		}
+	}
+	for quantity, expected := range map[string]int64{"500m": 5e8, "2": 2e9, "0.25": 25e7} {
+		if nanos, err := parseCPU(quantity); err != nil || nanos != expected {
+			t.Errorf("%v: expected %v but got %v, %v", quantity, expected, nanos, err)
+		}
+	}
+	if _, err := parseMemory("12Qi"); err == nil {
+		t.Errorf("expected an unknown suffix to be refused")
+	}
+	if formatMemory(1536<<20) != "1536Mi" || formatMemory(2<<30) != "2Gi" || formatCPU(25e7) != "250m" || formatCPU(3e9) != "3" {
+		t.Errorf("unexpected formatting")
+	}
+}
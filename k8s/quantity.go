@@ -0,0 +1,67 @@
+package k8s
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var quantitySuffixes = map[string]float64{
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+	"Pi": 1 << 50,
+	"Ei": 1 << 60,
+	"k":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"P":  1e15,
+	"E":  1e18,
+	"m":  1e-3,
+	"":   1,
+}
+
+// Parse a kubernetes resource quantity, e.g. 512Mi or 250m
+func parseQuantity(quantity string) (float64, error) {
+	number := strings.TrimRight(quantity, "KMGTPEkmi")
+	multiplier, ok := quantitySuffixes[quantity[len(number):]]
+	if !ok {
+		return 0, fmt.Errorf("unknown suffix in quantity %q", quantity)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid quantity %q", quantity)
+	}
+	return value * multiplier, nil
+}
+
+func parseMemory(quantity string) (int64, error) {
+	bytes, err := parseQuantity(quantity)
+	return int64(math.Ceil(bytes)), err
+}
+
+// cpus as docker's NanoCPUs
+func parseCPU(quantity string) (int64, error) {
+	cpus, err := parseQuantity(quantity)
+	return int64(math.Round(cpus * 1e9)), err
+}
+
+func formatMemory(bytes int64) string {
+	for _, suffix := range []string{"Gi", "Mi", "Ki"} {
+		if unit := int64(quantitySuffixes[suffix]); bytes%unit == 0 {
+			return fmt.Sprintf("%v%v", bytes/unit, suffix)
+		}
+	}
+	return fmt.Sprintf("%v", bytes)
+}
+
+func formatCPU(nanoCPUs int64) string {
+	if nanoCPUs%1e9 == 0 {
+		return fmt.Sprintf("%v", nanoCPUs/1e9)
+	}
+	// kubernetes doesn't go finer than millicores
+	return fmt.Sprintf("%vm", int64(math.Ceil(float64(nanoCPUs)/1e6)))
+}
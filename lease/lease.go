@@ -0,0 +1,124 @@
+// Package lease decides which of several docket servers sharing a store
+// runs the scheduler. Every server answers API requests, but only the
+// holder of the lease starts scheduled containers. The lease is kept in
+// the store the servers share, see allocations.File.
+//
+// Each new holder gets a higher fencing token. Runs carry the token of the
+// leader that started them and stores refuse runs with a token older than
+// one they've already seen, so a leader that was deposed while it was
+// paused or partitioned can't start or record a run the new leader owns.
+package lease
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+type Lease interface {
+	// Become the holder, or stay the holder, for ttl. Returns whether
+	// holder has the lease and, if it does, the fencing token for its
+	// term. The token only changes when the holder does
+	TryAcquire(holder string, ttl time.Duration) (token uint64, acquired bool, err error)
+
+	// Give up the lease early, if holder has it
+	Release(holder string) error
+}
+
+// Keeps trying to acquire and renew a lease in the background
+type Elector struct {
+	lease  Lease
+	holder string
+	ttl    time.Duration
+
+	mutex sync.Mutex
+	token uint64
+	// when our hold on the lease runs out unless renewed
+	until time.Time
+}
+
+func NewElector(lease Lease, holder string, ttl time.Duration) *Elector {
+	return &Elector{
+		lease:  lease,
+		holder: holder,
+		ttl:    ttl,
+	}
+}
+
+// Renew often enough that a couple of failed attempts
+// don't lose the lease
+func (elector *Elector) Start() {
+	elector.tryAcquire()
+	ticker := time.NewTicker(elector.ttl / 3)
+	go func() {
+		for range ticker.C {
+			elector.tryAcquire()
+		}
+	}()
+}
+
+func (elector *Elector) tryAcquire() {
+	// measured before asking, so we never think we hold the lease for longer than the store does
+	asked := time.Now()
+	token, acquired, err := elector.lease.TryAcquire(elector.holder, elector.ttl)
+	if err != nil {
+		log.Printf("Couldn't renew the scheduler lease, error was %v", err)
+		return
+	}
+
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+	wasLeading := elector.leading(asked)
+	if !acquired {
+		elector.until = time.Time{}
+		if wasLeading {
+			log.Printf("%v lost the scheduler lease", elector.holder)
+		}
+		return
+	}
+
+	elector.token = token
+	elector.until = asked.Add(elector.ttl)
+	if !wasLeading {
+		log.Printf("%v is now scheduling, with fencing token %v", elector.holder, token)
+	}
+}
+
+// must be called while locked
+func (elector *Elector) leading(now time.Time) bool {
+	return now.Before(elector.until)
+}
+
+// Whether this server currently holds the lease
+func (elector *Elector) Leading() bool {
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+	return elector.leading(time.Now())
+}
+
+// The fencing token to run with, 0 if this server isn't the leader
+func (elector *Elector) Token() uint64 {
+	token, _ := elector.Term()
+	return token
+}
+
+// The token of the current term and whether this server is leading,
+// read together so a run can't be scheduled with the token of a term
+// that has ended. The token is 0 if this server isn't the leader
+func (elector *Elector) Term() (uint64, bool) {
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+	if !elector.leading(time.Now()) {
+		return 0, false
+	}
+	return elector.token, true
+}
+
+func (elector *Elector) Stop() {
+	elector.mutex.Lock()
+	elector.until = time.Time{}
+	elector.mutex.Unlock()
+	if err := elector.lease.Release(elector.holder); err != nil {
+		log.Printf("Couldn't release the scheduler lease, error was %v", err)
+	}
+}
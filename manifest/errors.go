@@ -0,0 +1,55 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An error in a file, and where
+type SourceError struct {
+	Source
+	Err error
+}
+
+func (err *SourceError) Error() string {
+	return fmt.Sprintf("%v: %v", err.Source, err.Err)
+}
+
+func sourceError(file string, line int, err error) *SourceError {
+	return &SourceError{Source: Source{File: file, Line: line}, Err: err}
+}
+
+// Every problem found loading a set of files, one per line
+type Errors []error
+
+func (errs Errors) Error() string {
+	lines := []string{}
+	for _, err := range errs {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// turn the yaml package's "yaml: line 3: ..." errors, and each
+// line of its type errors, into file:line errors
+func yamlError(file string, err error) error {
+	problems := strings.Split(strings.TrimPrefix(err.Error(), "yaml: unmarshal errors:\n"), "\n")
+	errs := Errors{}
+	for _, problem := range problems {
+		problem = strings.TrimSpace(problem)
+		if match := yamlLine.FindStringSubmatch(problem); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			errs = append(errs, sourceError(file, line, fmt.Errorf("%v", match[2])))
+			continue
+		}
+		errs = append(errs, sourceError(file, 0, fmt.Errorf("%v", problem)))
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errs
+}
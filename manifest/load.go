@@ -0,0 +1,184 @@
+package manifest
+
+import (
+	"fmt"
+	"github.com/horthy/docket/allocations"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The file push and render read when they aren't given any
+const DefaultFile = "docket.yml"
+
+// The path that means standard input
+const Stdin = "-"
+
+// Read the specifications in every file named by paths, which can be
+// files, directories (every *.yml and *.yaml file under them), globs or
+// - for stdin. Allocations defined more than once are an error, and
+// every error says which file and line it came from
+func Load(paths []string, stdin io.Reader, overrides Overrides) ([]Entry, error) {
+	entries, errs := Read(paths, stdin, overrides)
+	errs = append(errs, Duplicates(entries)...)
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return entries, nil
+}
+
+// Like Load, but returns the entries from every file that could
+// be read along with the errors from those that couldn't, and
+// doesn't check for duplicates
+func Read(paths []string, stdin io.Reader, overrides Overrides) ([]Entry, Errors) {
+	files, err := Expand(paths)
+	if err != nil {
+		return nil, Errors{err}
+	}
+
+	entries := []Entry{}
+	errs := Errors{}
+	for _, file := range files {
+		parsed, err := loadFile(file, stdin, overrides)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		entries = append(entries, parsed...)
+	}
+	return entries, errs
+}
+
+func loadFile(file string, stdin io.Reader, overrides Overrides) ([]Entry, error) {
+	if file == Stdin {
+		return Parse("<stdin>", stdin, overrides)
+	}
+	reader, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return Parse(file, reader, overrides)
+}
+
+// The files named by paths, in order, with directories
+// walked and globs expanded. No paths means docket.yml
+func Expand(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{DefaultFile}
+	}
+
+	files := []string{}
+	seen := map[string]bool{}
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, path := range paths {
+		if path == Stdin {
+			add(path)
+			continue
+		}
+
+		matches := []string{path}
+		if strings.ContainsAny(path, "*?[") {
+			var err error
+			matches, err = filepath.Glob(path)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", path, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%v: no files match", path)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+			found, err := walk(match)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range found {
+				add(file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// every yaml file under dir, sorted so pushes happen in a predictable order
+func walk(dir string) ([]string, error) {
+	found := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if !info.IsDir() && (ext == ".yml" || ext == ".yaml") {
+			found = append(found, path)
+		}
+		return nil
+	})
+	sort.Strings(found)
+	return found, err
+}
+
+// An error for each allocation defined after an earlier
+// definition with the same namespace and name
+func Duplicates(entries []Entry) Errors {
+	errs := Errors{}
+	first := map[string]Source{}
+	for _, entry := range entries {
+		key := allocations.NamespaceOrDefault(entry.Spec.Namespace) + "/" + entry.Spec.Name
+		if source, ok := first[key]; ok {
+			errs = append(errs, &SourceError{
+				Source: entry.Source,
+				Err:    fmt.Errorf("allocation %v is already defined at %v", key, source),
+			})
+			continue
+		}
+		first[key] = entry.Source
+	}
+	return errs
+}
+
+// Parse --set flags, each key=value. Later flags win
+func ParseSet(values []string) (Overrides, error) {
+	overrides := Overrides{}
+	for _, value := range values {
+		equals := strings.Index(value, "=")
+		if equals <= 0 {
+			return nil, fmt.Errorf("expected --set key=value but got %q", value)
+		}
+		overrides[value[:equals]] = value[equals+1:]
+	}
+	return overrides, nil
+}
+
+// Read a --values file, a yaml map of vars
+func ReadValues(path string) (Overrides, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	overrides := Overrides{}
+	if err := yaml.Unmarshal(data, &overrides); err != nil {
+		return nil, yamlError(path, err)
+	}
+	return overrides, nil
+}
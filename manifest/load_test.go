@@ -0,0 +1,101 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func names(entries []Entry) []string {
+	found := []string{}
+	for _, entry := range entries {
+		found = append(found, entry.Spec.Name+"@"+entry.Source.String())
+	}
+	return found
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"etl/extract.yml": "Name: extract\nCron: \"0 * * * * *\"\n---\n- Name: transform\n  Cron: \"0 * * * * *\"\n",
+		"etl/load.yaml":   "- Name: load\n  Cron: \"0 * * * * *\"\n",
+		"etl/README.md":   "not yaml",
+		"report.json":     "[\n  {\"Name\": \"report\", \"Cron\": \"0 0 * * * *\"}\n]\n",
+	})
+	defer os.RemoveAll(dir)
+
+	entries, err := Load([]string{filepath.Join(dir, "etl"), filepath.Join(dir, "*.json"), Stdin}, strings.NewReader("Name: adhoc\nAt: \"2026-11-01T03:00:00Z\"\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"extract@" + filepath.Join(dir, "etl/extract.yml") + ":1",
+		"transform@" + filepath.Join(dir, "etl/extract.yml") + ":4",
+		"load@" + filepath.Join(dir, "etl/load.yaml") + ":1",
+		"report@" + filepath.Join(dir, "report.json") + ":2",
+		"adhoc@<stdin>:1",
+	}, " ")
+	if found := strings.Join(names(entries), " "); found != expected {
+		t.Errorf("expected %v but got %v", expected, found)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.yml":   "- Name: foo\n  Cron: \"0 * * * * *\"\n- Name: bar\n  Cron: \"0 * * * * *\"\n",
+		"b.yml":   "\n- Name: foo\n  Cron: \"0 * * * * *\"\n",
+		"bad.yml": "- Name: broken\n  Cron: [\n",
+	})
+	defer os.RemoveAll(dir)
+
+	_, err := Load([]string{filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")}, nil, nil)
+	expected := filepath.Join(dir, "b.yml") + ":2: allocation default/foo is already defined at " + filepath.Join(dir, "a.yml") + ":1"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected %q but got %v", expected, err)
+	}
+
+	_, err = Load([]string{filepath.Join(dir, "bad.yml")}, nil, nil)
+	if err == nil || !strings.HasPrefix(err.Error(), filepath.Join(dir, "bad.yml")+":2: ") {
+		t.Errorf("expected a syntax error with its file and line but got %v", err)
+	}
+
+	if _, err := Load([]string{filepath.Join(dir, "*.json")}, nil, nil); err == nil {
+		t.Errorf("expected a glob matching nothing to be an error")
+	}
+}
+
+func TestExpandDefault(t *testing.T) {
+	dir := writeFiles(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+
+	if _, err := Expand(nil); err == nil || !strings.Contains(err.Error(), DefaultFile) {
+		t.Errorf("expected no paths to mean %v but got %v", DefaultFile, err)
+	}
+
+	ioutil.WriteFile(DefaultFile, []byte("[]"), 0600)
+	files, err := Expand(nil)
+	if err != nil || len(files) != 1 || files[0] != DefaultFile {
+		t.Errorf("expected no paths to mean %v but got %v, %v", DefaultFile, files, err)
+	}
+}
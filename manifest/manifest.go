@@ -0,0 +1,330 @@
+// Package manifest reads allocation specifications from docket.yml files.
+//
+// A file is one or more yaml (or JSON) documents, each either a single
+// specification, a list of them, or a map with shared vars, named
+// templates, and a list of allocations that each start from a template
+// and set their own vars:
+//
+//	vars:
+//	  registry: quay.io/acme
+//	templates:
+//	  tenant-sync:
+//	    Cron: "0 0 * * * *"
+//	    Container:
+//	      Config:
+//	        Image: "{{ .registry }}/sync"
+//	        Env: ["TENANT={{ .tenant }}"]
+//	allocations:
+//	  - Name: "sync-{{ .tenant }}"
+//	    template: tenant-sync
+//	    vars:
+//	      tenant: acme
+//
+// Every string in an allocation is a text/template executed with its vars.
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/horthy/docket/allocations"
+	"gopkg.in/yaml.v3"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// the keys of a templated document, and of an allocation
+// entry in one that aren't part of its specification
+const (
+	allocationsKey = "allocations"
+	templatesKey   = "templates"
+	templateKey    = "template"
+	varsKey        = "vars"
+)
+
+// Vars that take precedence over any set in a file, from --values and --set
+type Overrides map[string]string
+
+// A specification and where it was read from
+type Entry struct {
+	Spec *allocations.AllocationSpecification
+	Source
+}
+
+// Where in which file something was read from
+type Source struct {
+	File string
+	Line int
+}
+
+func (source Source) String() string {
+	if source.Line == 0 {
+		return source.File
+	}
+	return fmt.Sprintf("%v:%v", source.File, source.Line)
+}
+
+// Read the specifications in one file, named file in errors, expanding
+// templates and vars. A plain list or specification is only templated
+// if there are overrides, so older files with a literal {{ in them,
+// e.g. in an email Body, keep working
+func Parse(file string, r io.Reader, overrides Overrides) ([]Entry, error) {
+	entries := []Entry{}
+	decoder := yaml.NewDecoder(r)
+	for {
+		document := &yaml.Node{}
+		err := decoder.Decode(document)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, yamlError(file, err)
+		}
+		parsed, err := parseDocument(file, document, overrides)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, parsed...)
+	}
+}
+
+// Read the specifications in a file, without reporting where they came from
+func Render(data []byte, overrides Overrides) ([]*allocations.AllocationSpecification, error) {
+	entries, err := Parse("docket.yml", bytes.NewReader(data), overrides)
+	if err != nil {
+		return nil, err
+	}
+	specs := []*allocations.AllocationSpecification{}
+	for _, entry := range entries {
+		specs = append(specs, entry.Spec)
+	}
+	return specs, nil
+}
+
+func parseDocument(file string, document *yaml.Node, overrides Overrides) ([]Entry, error) {
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+	root := document.Content[0]
+
+	switch {
+	case root.Kind == yaml.SequenceNode:
+		return parseEntries(file, root.Content, templated{}, len(overrides) > 0, overrides)
+	case root.Kind == yaml.MappingNode && isTemplated(root):
+		doc := templated{}
+		if err := root.Decode(&doc); err != nil {
+			return nil, yamlError(file, err)
+		}
+		items := mappingValue(root, allocationsKey)
+		if items == nil {
+			return nil, nil
+		}
+		if items.Kind != yaml.SequenceNode {
+			return nil, sourceError(file, items.Line, fmt.Errorf("allocations must be a list"))
+		}
+		return parseEntries(file, items.Content, doc, true, overrides)
+	case root.Kind == yaml.MappingNode:
+		return parseEntries(file, []*yaml.Node{root}, templated{}, len(overrides) > 0, overrides)
+	}
+	return nil, sourceError(file, root.Line, fmt.Errorf("expected an allocation, a list of allocations, or a map with templates, vars and allocations"))
+}
+
+type templated struct {
+	Vars      map[string]string                 `yaml:"vars"`
+	Templates map[string]map[string]interface{} `yaml:"templates"`
+}
+
+// a map with any of the templated document keys, rather than a specification
+func isTemplated(node *yaml.Node) bool {
+	for _, key := range []string{allocationsKey, templatesKey, varsKey} {
+		if mappingValue(node, key) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func parseEntries(file string, items []*yaml.Node, doc templated, template bool, overrides Overrides) ([]Entry, error) {
+	entries := []Entry{}
+	for _, item := range items {
+		source := Source{File: file, Line: item.Line}
+		if item.Kind != yaml.MappingNode {
+			return nil, sourceError(file, item.Line, fmt.Errorf("expected an allocation"))
+		}
+
+		spec := &allocations.AllocationSpecification{}
+		if template {
+			var err error
+			spec, err = renderEntry(item, doc, overrides)
+			if err != nil {
+				return nil, sourceError(file, item.Line, fmt.Errorf("allocation %v: %v", entryName(item), err))
+			}
+		} else if err := item.Decode(spec); err != nil {
+			return nil, yamlError(file, err)
+		}
+		entries = append(entries, Entry{Spec: spec, Source: source})
+	}
+	return entries, nil
+}
+
+func entryName(item *yaml.Node) string {
+	if name := mappingValue(item, "Name"); name != nil {
+		return name.Value
+	}
+	return "without a Name"
+}
+
+func renderEntry(item *yaml.Node, doc templated, overrides Overrides) (*allocations.AllocationSpecification, error) {
+	entry := map[string]interface{}{}
+	if err := item.Decode(&entry); err != nil {
+		return nil, err
+	}
+
+	vars := map[string]string{}
+	for key, value := range doc.Vars {
+		vars[key] = value
+	}
+	if entryVars, ok := entry[varsKey]; ok {
+		entryMap, ok := entryVars.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("vars must be a map")
+		}
+		for key, value := range entryMap {
+			vars[key] = fmt.Sprint(value)
+		}
+	}
+	for key, value := range overrides {
+		vars[key] = value
+	}
+
+	merged := map[string]interface{}{}
+	if name, ok := entry[templateKey]; ok {
+		base, ok := doc.Templates[fmt.Sprint(name)]
+		if !ok {
+			return nil, fmt.Errorf("no template named %v, have %v", name, templateNames(doc.Templates))
+		}
+		merged = merge(base, nil).(map[string]interface{})
+	}
+	for key, value := range entry {
+		if key == templateKey || key == varsKey {
+			continue
+		}
+		merged[key] = merge(merged[key], value)
+	}
+
+	expanded, err := expand(merged, vars, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// round trip through yaml so the result is decoded
+	// exactly as a plain specification would be
+	out, err := yaml.Marshal(expanded)
+	if err != nil {
+		return nil, err
+	}
+	spec := &allocations.AllocationSpecification{}
+	err = yaml.Unmarshal(out, spec)
+	return spec, err
+}
+
+// Deep merge override onto base, returning a copy. Maps are merged
+// key by key, anything else in override replaces base
+func merge(base interface{}, override interface{}) interface{} {
+	baseMap, baseIsMap := base.(map[string]interface{})
+	overrideMap, overrideIsMap := override.(map[string]interface{})
+	switch {
+	case baseIsMap && (overrideIsMap || override == nil):
+		merged := map[string]interface{}{}
+		for key, value := range baseMap {
+			merged[key] = merge(value, nil)
+		}
+		for key, value := range overrideMap {
+			merged[key] = merge(merged[key], value)
+		}
+		return merged
+	case override != nil:
+		return copyValue(override)
+	}
+	return copyValue(base)
+}
+
+func copyValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return merge(typed, nil)
+	case []interface{}:
+		copied := []interface{}{}
+		for _, item := range typed {
+			copied = append(copied, copyValue(item))
+		}
+		return copied
+	}
+	return value
+}
+
+// fields that are templates themselves, executed by the server when
+// it uses them, which expanding vars would break
+var runtimeTemplates = map[string]bool{
+	"Notifications.Email.Subject": true,
+	"Notifications.Email.Body":    true,
+}
+
+// Execute every string in value as a template with vars, except
+// runtime templates. path is where value is in the specification
+func expand(value interface{}, vars map[string]string, path string) (interface{}, error) {
+	if runtimeTemplates[path] {
+		return value, nil
+	}
+	switch typed := value.(type) {
+	case string:
+		if !strings.Contains(typed, "{{") {
+			return typed, nil
+		}
+		tmpl, err := template.New("value").Option("missingkey=error").Parse(typed)
+		if err != nil {
+			return nil, err
+		}
+		out := new(bytes.Buffer)
+		if err := tmpl.Execute(out, vars); err != nil {
+			return nil, err
+		}
+		return out.String(), nil
+	case map[string]interface{}:
+		for key, item := range typed {
+			expanded, err := expand(item, vars, strings.TrimPrefix(path+"."+key, "."))
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", key, err)
+			}
+			typed[key] = expanded
+		}
+	case []interface{}:
+		for i, item := range typed {
+			expanded, err := expand(item, vars, fmt.Sprintf("%v[%v]", path, i))
+			if err != nil {
+				return nil, fmt.Errorf("[%v]: %v", i, err)
+			}
+			typed[i] = expanded
+		}
+	}
+	return value, nil
+}
+
+func templateNames(templates map[string]map[string]interface{}) string {
+	names := []string{}
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,120 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const tenants = `
+vars:
+  registry: quay.io/acme
+templates:
+  tenant-sync:
+    Cron: "0 0 * * * *"
+    Labels:
+      team: data
+    Container:
+      Config:
+        Image: "{{ .registry }}/sync"
+        Env: ["TENANT={{ .tenant }}", "REGION={{ .region }}"]
+allocations:
+  - Name: "sync-{{ .tenant }}"
+    template: tenant-sync
+    vars:
+      tenant: acme
+      region: us
+  - Name: "sync-{{ .tenant }}"
+    template: tenant-sync
+    Labels:
+      tier: gold
+    vars:
+      tenant: globex
+      region: eu
+`
+
+func TestRenderTemplates(t *testing.T) {
+	specs, err := Render([]byte(tenants), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 2 {
+		t.Fatalf("expected 2 specs but got %v", len(specs))
+	}
+
+	acme, globex := specs[0], specs[1]
+	if acme.Name != "sync-acme" || acme.Cron != "0 0 * * * *" || acme.Container.Config.Image != "quay.io/acme/sync" {
+		t.Errorf("unexpected spec %+v", acme)
+	}
+	if !reflect.DeepEqual(acme.Container.Config.Env, []string{"TENANT=acme", "REGION=us"}) {
+		t.Errorf("unexpected env %v", acme.Container.Config.Env)
+	}
+	if !reflect.DeepEqual(globex.Labels, map[string]string{"team": "data", "tier": "gold"}) {
+		t.Errorf("expected entry labels to merge with the template's but got %v", globex.Labels)
+	}
+	if !reflect.DeepEqual(acme.Labels, map[string]string{"team": "data"}) {
+		t.Errorf("expected one entry's labels not to leak into another's but got %v", acme.Labels)
+	}
+
+	specs, err = Render([]byte(tenants), Overrides{"registry": "localhost:5000", "region": "ap"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if specs[1].Container.Config.Image != "localhost:5000/sync" || specs[1].Container.Config.Env[1] != "REGION=ap" {
+		t.Errorf("expected overrides to win over file vars but got %+v", specs[1].Container.Config)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	missingVar := strings.Replace(tenants, "      region: us\n", "", 1)
+	if _, err := Render([]byte(missingVar), nil); err == nil || !strings.Contains(err.Error(), "sync-{{ .tenant }}") {
+		t.Errorf("expected an error naming the allocation missing a var but got %v", err)
+	}
+
+	missingTemplate := strings.Replace(tenants, "template: tenant-sync", "template: nope", 1)
+	if _, err := Render([]byte(missingTemplate), nil); err == nil {
+		t.Errorf("expected an unknown template to be refused")
+	}
+}
+
+func TestRenderList(t *testing.T) {
+	list := `
+- Name: foo
+  Cron: "* * * * * *"
+  Notifications:
+    Email:
+      Body: "{{ .Allocation }} failed"
+  Container:
+    Config:
+      Image: "busybox:{{ .tag }}"
+`
+	specs, err := Render([]byte(list), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if specs[0].Container.Config.Image != "busybox:{{ .tag }}" {
+		t.Errorf("expected a plain list not to be templated without overrides but got %v", specs[0].Container.Config.Image)
+	}
+
+	// the email body is the server's template, not the file's
+	specs, err = Render([]byte(list), Overrides{"tag": "latest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if specs[0].Container.Config.Image != "busybox:latest" || specs[0].Notifications.Email.Body != "{{ .Allocation }} failed" {
+		t.Errorf("unexpected templated list %+v", specs[0])
+	}
+}
+
+func TestParseSet(t *testing.T) {
+	overrides, err := ParseSet([]string{"tenant=acme", "query=a=b", "tenant=globex"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(overrides, Overrides{"tenant": "globex", "query": "a=b"}) {
+		t.Errorf("unexpected overrides %v", overrides)
+	}
+	if _, err := ParseSet([]string{"=acme"}); err == nil {
+		t.Errorf("expected a set without a key to be refused")
+	}
+}
@@ -0,0 +1,142 @@
+package manifest
+
+import (
+	"fmt"
+	"github.com/horthy/docket/allocations"
+	"sort"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// One problem found by Validate. Errors would make the server
+// refuse the allocation, warnings are probably mistakes
+type Finding struct {
+	File       string   `json:"File,omitempty"`
+	Line       int      `json:"Line,omitempty"`
+	Allocation string   `json:"Allocation,omitempty"`
+	Field      string   `json:"Field,omitempty"`
+	Severity   Severity `json:"Severity"`
+	Message    string   `json:"Message"`
+}
+
+func (finding Finding) String() string {
+	out := ""
+	if finding.File != "" {
+		out = Source{File: finding.File, Line: finding.Line}.String() + ": "
+	}
+	out += string(finding.Severity) + ": "
+	if finding.Allocation != "" {
+		out += "allocation " + finding.Allocation + ": "
+	}
+	if finding.Field != "" {
+		out += finding.Field + ": "
+	}
+	return out + finding.Message
+}
+
+// What Validate found, as printed by docket validate -o json
+type Report struct {
+	Valid    bool      `json:"Valid"`
+	Errors   int       `json:"Errors"`
+	Warnings int       `json:"Warnings"`
+	Findings []Finding `json:"Findings"`
+}
+
+func NewReport(findings []Finding) Report {
+	report := Report{Findings: findings}
+	for _, finding := range findings {
+		if finding.Severity == SeverityError {
+			report.Errors++
+		} else {
+			report.Warnings++
+		}
+	}
+	report.Valid = report.Errors == 0
+	return report
+}
+
+// Run every check the server would on entries, without talking to it,
+// along with lint warnings. Duplicates and trigger cycles between
+// the entries are errors too. Checks that need the server's
+// state, like whether a blackout calendar exists, are left out
+func Validate(entries []Entry) []Finding {
+	findings := []Finding{}
+	for _, err := range Duplicates(entries) {
+		findings = append(findings, ErrorFindings(err)...)
+	}
+
+	existing := []*allocations.Allocation{}
+	for _, entry := range entries {
+		existing = append(existing, &allocations.Allocation{
+			Namespace: allocations.NamespaceOrDefault(entry.Spec.Namespace),
+			Name:      entry.Spec.Name,
+			Trigger:   entry.Spec.Trigger,
+		})
+	}
+
+	for _, entry := range entries {
+		name := allocations.NamespaceOrDefault(entry.Spec.Namespace) + "/" + entry.Spec.Name
+		finding := func(severity Severity, field string, message string) Finding {
+			return Finding{
+				File:       entry.Source.File,
+				Line:       entry.Source.Line,
+				Allocation: name,
+				Field:      field,
+				Severity:   severity,
+				Message:    message,
+			}
+		}
+
+		problems := entry.Spec.Check()
+		for _, field := range sortedKeys(problems) {
+			findings = append(findings, finding(SeverityError, field, problems[field]))
+		}
+		if err := allocations.CheckCycles(existing, entry.Spec); err != nil {
+			findings = append(findings, finding(SeverityError, "Trigger", err.Error()))
+		}
+		if len(problems) > 0 {
+			continue
+		}
+
+		warnings := entry.Spec.Lint()
+		for _, field := range sortedKeys(warnings) {
+			findings = append(findings, finding(SeverityWarning, field, warnings[field]))
+		}
+	}
+	return findings
+}
+
+// Findings for the errors Load and Read return, keeping
+// the file and line of each where there is one
+func ErrorFindings(err error) []Finding {
+	switch err := err.(type) {
+	case Errors:
+		findings := []Finding{}
+		for _, each := range err {
+			findings = append(findings, ErrorFindings(each)...)
+		}
+		return findings
+	case *SourceError:
+		return []Finding{{
+			File:     err.File,
+			Line:     err.Line,
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("%v", err.Err),
+		}}
+	}
+	return []Finding{{Severity: SeverityError, Message: err.Error()}}
+}
+
+func sortedKeys(fields map[string]string) []string {
+	keys := []string{}
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
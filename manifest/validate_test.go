@@ -0,0 +1,61 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	entries, errs := Read([]string{"-"}, strings.NewReader(`
+- Name: extract
+  Cron: "0 0 * * * * *"
+  Container:
+    Config: {Image: "etl:1.0"}
+    HostConfig: {AutoRemove: true, Memory: 1000000}
+- Name: load
+  Cron: "not a cron"
+  Trigger: {After: [transform]}
+  Container:
+    Config: {Image: "etl:1.0"}
+- Name: transform
+  Trigger: {After: [load]}
+  Container:
+    Config: {Image: "etl"}
+    HostConfig: {AutoRemove: true, Memory: 1000000}
+- Name: extract
+  Cron: "0 0 * * * * *"
+`), Overrides{})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	found := []string{}
+	for _, finding := range Validate(entries) {
+		found = append(found, finding.String())
+	}
+	expected := []string{
+		"<stdin>:17: error: allocation default/extract is already defined at <stdin>:2",
+		"<stdin>:7: error: allocation default/load: Cron: missing field(s)",
+		"<stdin>:7: error: allocation default/load: Trigger: trigger cycle in namespace default: load -> transform -> load",
+		"<stdin>:12: error: allocation default/transform: Trigger: trigger cycle in namespace default: transform -> load -> transform",
+		"<stdin>:12: warning: allocation default/transform: Container.Config.Image: etl uses the latest tag, pin a version so runs are repeatable",
+		"<stdin>:17: error: allocation default/extract: Container.Config: Config is required",
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected\n%v\nbut got\n%v", strings.Join(expected, "\n"), strings.Join(found, "\n"))
+	}
+
+	report := NewReport(Validate(entries))
+	if report.Valid || report.Errors != 5 || report.Warnings != 1 {
+		t.Errorf("expected 5 errors and a warning but got %+v", report)
+	}
+}
+
+func TestErrorFindings(t *testing.T) {
+	_, errs := Read([]string{"-"}, strings.NewReader("- Name: a\n  Cron: [\n"), Overrides{})
+	findings := ErrorFindings(errs)
+	if len(findings) != 1 || findings[0].File != "<stdin>" || findings[0].Line == 0 || findings[0].Severity != SeverityError {
+		t.Errorf("expected a syntax error with a line but got %+v", findings)
+	}
+}
@@ -0,0 +1,214 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/horthy/docket/allocations"
+	"log"
+	"net"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	DefaultEmailSubject = `[docket] {{.Allocation}}: {{.Type}}`
+	DefaultEmailBody    = `Allocation: {{.Allocation}}
+Event:      {{.Type}}
+Time:       {{.Time}}
+{{if .RunID}}Run:        {{.RunID}}
+Exit code:  {{.ExitCode}}
+{{end}}{{if .Message}}
+{{.Message}}
+{{end}}{{if .Output}}
+Output:
+{{.Output}}
+{{end}}`
+)
+
+// Connection details for the relay, and whether to batch failures
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// If non-zero, failure, timeout, missed and overdue events are collected for
+	// this long and sent as one message per recipient list
+	Digest time.Duration
+}
+
+func (config SMTPConfig) address() string {
+	return net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+}
+
+// Sends mail about events to the recipients listed on an allocation
+type EmailNotifier struct {
+	store  allocations.AllocationStore
+	config SMTPConfig
+
+	// pending digest messages, keyed by recipient list
+	mutex   *sync.Mutex
+	pending map[string]*digest
+}
+
+type digest struct {
+	recipients []string
+	entries    []digestEntry
+}
+
+// an event waiting in a digest, with the allocation it's about
+type digestEntry struct {
+	alloc *allocations.Allocation
+	email *allocations.Email
+	event Event
+}
+
+func NewEmailNotifier(store allocations.AllocationStore, config SMTPConfig) *EmailNotifier {
+	return &EmailNotifier{
+		store:   store,
+		config:  config,
+		mutex:   &sync.Mutex{},
+		pending: map[string]*digest{},
+	}
+}
+
+func (n *EmailNotifier) Notify(alloc *allocations.Allocation, event Event) {
+	email := alloc.Notifications.Email
+	if email == nil || !email.Wants(event.Type) {
+		return
+	}
+
+	if n.config.Digest > 0 && isFailure(event.Type) {
+		n.addToDigest(alloc, email, event)
+		return
+	}
+
+	subject, body, err := render(email, event)
+	if err != nil {
+		log.Printf("Failed to render %v email for %v, error was %v", event.Type, alloc.Name, err)
+		n.store.Log(alloc, "Failed to render", event.Type, "email", err)
+		return
+	}
+
+	err = n.send(email.Recipients, subject, body)
+	if err != nil {
+		log.Printf("Failed to send %v email for %v, error was %v", event.Type, alloc.Name, err)
+		n.store.Log(alloc, "Failed to send", event.Type, "email to", email.Recipients, err)
+		return
+	}
+	n.store.Log(alloc, "Sent", event.Type, "email to", email.Recipients)
+}
+
+func isFailure(event allocations.NotificationEvent) bool {
+	return event == allocations.EventFailure ||
+		event == allocations.EventTimeout ||
+		event == allocations.EventMissed ||
+		event == allocations.EventOverdue
+}
+
+func (n *EmailNotifier) addToDigest(alloc *allocations.Allocation, email *allocations.Email, event Event) {
+	sorted := append([]string{}, email.Recipients...)
+	sort.Strings(sorted)
+	key := strings.Join(sorted, ",")
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	pending, ok := n.pending[key]
+	if !ok {
+		pending = &digest{recipients: sorted}
+		n.pending[key] = pending
+		time.AfterFunc(n.config.Digest, func() { n.flush(key) })
+	}
+	pending.entries = append(pending.entries, digestEntry{alloc: alloc, email: email, event: event})
+}
+
+// send everything collected for a recipient list as a single message. Each
+// event is rendered with its allocation's Body, but the digest has its own
+// subject, so per-allocation Subject templates don't apply
+func (n *EmailNotifier) flush(key string) {
+	n.mutex.Lock()
+	pending := n.pending[key]
+	delete(n.pending, key)
+	n.mutex.Unlock()
+
+	if pending == nil || len(pending.entries) == 0 {
+		return
+	}
+
+	subject := fmt.Sprintf("[docket] %v failures in the last %v", len(pending.entries), n.config.Digest)
+	body := new(bytes.Buffer)
+	for _, entry := range pending.entries {
+		_, rendered, err := render(entry.email, entry.event)
+		if err != nil {
+			rendered = fmt.Sprintf("%v: %v\n", entry.event.Allocation, entry.event.Type)
+		}
+		body.WriteString(rendered)
+		body.WriteString("\n----\n\n")
+	}
+
+	err := n.send(pending.recipients, subject, body.String())
+	if err != nil {
+		log.Printf("Failed to send digest of %v events to %v, error was %v", len(pending.entries), pending.recipients, err)
+	}
+	for _, entry := range pending.entries {
+		if err != nil {
+			n.store.Log(entry.alloc, "Failed to send", entry.event.Type, "digest email to", pending.recipients, err)
+		} else {
+			n.store.Log(entry.alloc, "Sent", entry.event.Type, "digest email to", pending.recipients)
+		}
+	}
+}
+
+func (n *EmailNotifier) send(recipients []string, subject string, body string) error {
+	message := new(bytes.Buffer)
+	fmt.Fprintf(message, "From: %v\r\n", n.config.From)
+	fmt.Fprintf(message, "To: %v\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(message, "Subject: %v\r\n", subject)
+	fmt.Fprintf(message, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprint(message, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+	}
+	return smtp.SendMail(n.config.address(), auth, n.config.From, recipients, message.Bytes())
+}
+
+func render(email *allocations.Email, event Event) (string, string, error) {
+	subjectTemplate := email.Subject
+	if subjectTemplate == "" {
+		subjectTemplate = DefaultEmailSubject
+	}
+	bodyTemplate := email.Body
+	if bodyTemplate == "" {
+		bodyTemplate = DefaultEmailBody
+	}
+
+	subject, err := renderTemplate("subject", subjectTemplate, event)
+	if err != nil {
+		return "", "", err
+	}
+	body, err := renderTemplate("body", bodyTemplate, event)
+	if err != nil {
+		return "", "", err
+	}
+	// a newline in the subject would let the template inject headers
+	return strings.Replace(subject, "\n", " ", -1), body, nil
+}
+
+func renderTemplate(name string, text string, event Event) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	out := new(bytes.Buffer)
+	err = tmpl.Execute(out, event)
+	return out.String(), err
+}
@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/allocations"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A just-enough SMTP server that hands each message it receives to messages
+func fakeSMTP(t *testing.T, messages chan string) (string, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return host, portNumber
+}
+
+func serveSMTP(conn net.Conn, messages chan string) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(strings.SplitN(line, " ", 2)[0]) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 fake")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			data, _ := bufio.NewReader(text.DotReader()).ReadString(0)
+			text.PrintfLine("250 ok")
+			messages <- data
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("250 ok")
+		}
+	}
+}
+
+func emailAllocation(store *allocations.InMemoryAllocations) *allocations.Allocation {
+	store.CreateOrUpdate(&allocations.AllocationSpecification{
+		Name: "foo",
+		Cron: "* * * * * *",
+		Container: allocations.CreateContainerOptions{
+			Config: &docker.Config{Image: "busybox:latest"},
+		},
+		Notifications: allocations.NotificationSpecification{
+			Email: &allocations.Email{
+				Recipients: []string{"ops@example.com"},
+				Events:     []allocations.NotificationEvent{allocations.EventFailure, allocations.EventRecovered},
+				Subject:    "{{.Allocation}} exited {{.ExitCode}}",
+			},
+		},
+	}, allocations.Change{})
+	alloc, _ := store.Get(allocations.DefaultNamespace, "foo")
+	return alloc
+}
+
+func TestEmail(t *testing.T) {
+	messages := make(chan string, 10)
+	host, port := fakeSMTP(t, messages)
+
+	store := allocations.InMemory()
+	alloc := emailAllocation(store)
+	notifier := NewEmailNotifier(store, SMTPConfig{Host: host, Port: port, From: "docket@example.com"})
+
+	notifier.Notify(alloc, Event{Type: allocations.EventSuccess, Allocation: "foo"})
+	notifier.Notify(alloc, Event{Type: allocations.EventFailure, Allocation: "foo", RunID: "abc", ExitCode: 3, Output: "oh no"})
+
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "Subject: foo exited 3") {
+			t.Errorf("expected templated subject in message %v", message)
+		}
+		if !strings.Contains(message, "oh no") {
+			t.Errorf("expected output in message %v", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	if len(messages) != 0 {
+		t.Errorf("expected success event not to be mailed")
+	}
+}
+
+func TestEmailDigest(t *testing.T) {
+	messages := make(chan string, 10)
+	host, port := fakeSMTP(t, messages)
+
+	store := allocations.InMemory()
+	alloc := emailAllocation(store)
+	notifier := NewEmailNotifier(store, SMTPConfig{
+		Host:   host,
+		Port:   port,
+		From:   "docket@example.com",
+		Digest: time.Hour,
+	})
+	alloc.Notifications.Email.Body = "{{.Allocation}} run {{.RunID}} failed"
+
+	notifier.Notify(alloc, Event{Type: allocations.EventFailure, Allocation: "foo", RunID: "run1"})
+	notifier.Notify(alloc, Event{Type: allocations.EventFailure, Allocation: "foo", RunID: "run2"})
+	// rather than wait out the digest window
+	notifier.flush("ops@example.com")
+
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "2 failures") || !strings.Contains(message, "foo run run1 failed") || !strings.Contains(message, "foo run run2 failed") {
+			t.Errorf("expected both failures rendered with the allocation's body in one digest but got %v", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no digest received")
+	}
+
+	logged, _ := store.Get(allocations.DefaultNamespace, "foo")
+	if len(logged.Logs) != 2 || !strings.Contains(fmt.Sprint(logged.Logs), "Sent failure digest email") {
+		t.Errorf("expected both digest deliveries to be logged but got %v", logged.Logs)
+	}
+}
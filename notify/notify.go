@@ -0,0 +1,75 @@
+// Package notify tells the outside world about the outcome of allocation runs.
+//
+// Notifiers are handed every event and decide for themselves, based on the
+// allocation's NotificationSpecification, whether anybody wants to hear it.
+package notify
+
+import (
+	"github.com/horthy/docket/allocations"
+	"time"
+)
+
+// The payload describing something that happened to an allocation
+type Event struct {
+	Type       allocations.NotificationEvent `json:"Event"`
+	Namespace  string                        `json:"Namespace"`
+	Allocation string                        `json:"Allocation"`
+	RunID      string                        `json:"RunID,omitempty"`
+	ExitCode   int                           `json:"ExitCode"`
+	Output     string                        `json:"Output,omitempty"`
+	Message    string                        `json:"Message,omitempty"`
+	Time       time.Time                     `json:"Time"`
+}
+
+type Notifier interface {
+	Notify(alloc *allocations.Allocation, event Event)
+}
+
+// Fans events out to several notifiers without
+// blocking the caller on slow or retrying deliveries
+type Dispatcher struct {
+	notifiers []Notifier
+}
+
+func NewDispatcher(notifiers ...Notifier) *Dispatcher {
+	return &Dispatcher{notifiers: notifiers}
+}
+
+func (d *Dispatcher) Notify(alloc *allocations.Allocation, event Event) {
+	for _, notifier := range d.notifiers {
+		go notifier.Notify(alloc, event)
+	}
+}
+
+// Build the events for a finished run. previous is the run before
+// this one, if any, and is used to detect recovery from a failure
+func RunEvents(alloc *allocations.Allocation, previous *allocations.Run, run *allocations.Run) []Event {
+	base := Event{
+		Namespace:  alloc.Namespace,
+		Allocation: alloc.Name,
+		RunID:      run.ID,
+		ExitCode:   run.ExitCode,
+		Output:     run.Output,
+		Message:    run.Error,
+		Time:       run.FinishedAt,
+	}
+
+	events := []Event{}
+	switch run.Status {
+	case allocations.RunSucceeded:
+		events = append(events, withType(base, allocations.EventSuccess))
+		if previous != nil && !previous.Succeeded() {
+			events = append(events, withType(base, allocations.EventRecovered))
+		}
+	case allocations.RunTimedOut:
+		events = append(events, withType(base, allocations.EventTimeout))
+	default:
+		events = append(events, withType(base, allocations.EventFailure))
+	}
+	return events
+}
+
+func withType(event Event, eventType allocations.NotificationEvent) Event {
+	event.Type = eventType
+	return event
+}
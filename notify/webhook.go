@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/horthy/docket/allocations"
+	"log"
+	"net/http"
+	"time"
+)
+
+const SignatureHeader = "X-Docket-Signature"
+
+// Delivers events to the webhooks listed on an allocation,
+// retrying failed deliveries and logging every attempt
+// to the allocation so they show up in `docket get`
+type WebhookNotifier struct {
+	store  allocations.AllocationStore
+	client *http.Client
+	secret []byte
+
+	Attempts int
+	Backoff  time.Duration
+}
+
+// secret is used to sign payloads, if it's empty payloads are sent unsigned
+func NewWebhookNotifier(store allocations.AllocationStore, secret string) *WebhookNotifier {
+	return &WebhookNotifier{
+		store:    store,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		secret:   []byte(secret),
+		Attempts: 3,
+		Backoff:  2 * time.Second,
+	}
+}
+
+func (n *WebhookNotifier) Notify(alloc *allocations.Allocation, event Event) {
+	for _, webhook := range alloc.Notifications.Webhooks {
+		if webhook.Wants(event.Type) {
+			n.deliver(alloc, webhook, event)
+		}
+	}
+}
+
+func (n *WebhookNotifier) deliver(alloc *allocations.Allocation, webhook allocations.Webhook, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal %v event for %v, error was %v", event.Type, alloc.Name, err)
+		return
+	}
+
+	backoff := n.Backoff
+	for attempt := 1; attempt <= n.Attempts; attempt++ {
+		err = n.post(webhook.URL, event, body)
+		if err == nil {
+			n.store.Log(alloc, "Delivered", event.Type, "webhook to", webhook.URL, "attempt", attempt)
+			return
+		}
+
+		log.Printf("Webhook delivery to %v for %v failed on attempt %v, error was %v", webhook.URL, alloc.Name, attempt, err)
+		n.store.Log(alloc, "Failed to deliver", event.Type, "webhook to", webhook.URL, "attempt", attempt, err)
+		if attempt < n.Attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (n *WebhookNotifier) post(url string, event Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Docket-Event", string(event.Type))
+	if len(n.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("receiver responded with status %v", resp.Status)
+	}
+	return nil
+}
+
+// The value of the signature header for a payload,
+// receivers can recompute this with the shared secret
+// to check a payload really came from docket
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
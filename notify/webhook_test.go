@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"encoding/json"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/allocations"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	received := []Event{}
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		// fail the first attempt to exercise retries
+		if attempts == 1 {
+			w.WriteHeader(500)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign([]byte("s3cret"), body) {
+			t.Errorf("bad signature %v", r.Header.Get(SignatureHeader))
+		}
+		event := Event{}
+		json.Unmarshal(body, &event)
+		received = append(received, event)
+	}))
+	defer receiver.Close()
+
+	store := allocations.InMemory()
+	store.CreateOrUpdate(&allocations.AllocationSpecification{
+		Name: "foo",
+		Cron: "* * * * * *",
+		Container: allocations.CreateContainerOptions{
+			Config: &docker.Config{Image: "busybox:latest"},
+		},
+		Notifications: allocations.NotificationSpecification{
+			Webhooks: []allocations.Webhook{
+				{URL: receiver.URL, Events: []allocations.NotificationEvent{allocations.EventFailure}},
+			},
+		},
+	}, allocations.Change{})
+	alloc, _ := store.Get(allocations.DefaultNamespace, "foo")
+
+	notifier := NewWebhookNotifier(store, "s3cret")
+	notifier.Backoff = time.Millisecond
+
+	run := &allocations.Run{ID: "abc", Status: allocations.RunFailed, ExitCode: 2, Output: "boom"}
+	for _, event := range RunEvents(alloc, nil, run) {
+		notifier.Notify(alloc, event)
+	}
+	// not subscribed to success, shouldn't be sent
+	notifier.Notify(alloc, Event{Type: allocations.EventSuccess, Allocation: "foo"})
+
+	if attempts != 2 {
+		t.Errorf("expected 2 delivery attempts but got %v", attempts)
+	}
+
+	if len(received) != 1 {
+		t.Fatalf("expected 1 event to be received but got %v", len(received))
+	}
+
+	if received[0].RunID != "abc" || received[0].ExitCode != 2 || received[0].Output != "boom" {
+		t.Errorf("unexpected payload %+v", received[0])
+	}
+
+	logged, _ := store.Get(allocations.DefaultNamespace, "foo")
+	if len(logged.Logs) != 2 {
+		t.Errorf("expected 2 delivery log entries but got %v", logged.Logs)
+	}
+}
+
+func TestRunEvents(t *testing.T) {
+	alloc := &allocations.Allocation{Name: "foo"}
+	failed := &allocations.Run{Status: allocations.RunFailed}
+	succeeded := &allocations.Run{Status: allocations.RunSucceeded}
+
+	events := RunEvents(alloc, failed, succeeded)
+	if len(events) != 2 || events[1].Type != allocations.EventRecovered {
+		t.Errorf("expected success and recovered events but got %v", events)
+	}
+
+	events = RunEvents(alloc, succeeded, succeeded)
+	if len(events) != 1 || events[0].Type != allocations.EventSuccess {
+		t.Errorf("expected only a success event but got %v", events)
+	}
+
+	events = RunEvents(alloc, nil, &allocations.Run{Status: allocations.RunTimedOut})
+	if len(events) != 1 || events[0].Type != allocations.EventTimeout {
+		t.Errorf("expected a timeout event but got %v", events)
+	}
+}
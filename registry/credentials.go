@@ -0,0 +1,175 @@
+// Package registry holds the credentials the server uses to pull
+// images from private registries
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/seal"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const dockerHub = "docker.io"
+
+// Named registry credentials. Allocations can ask for a credential by
+// name, otherwise the one whose server address matches the image's
+// registry is used
+type Credentials struct {
+	mutex       *sync.RWMutex
+	credentials map[string]docker.AuthConfiguration
+}
+
+func NewCredentials() *Credentials {
+	return &Credentials{
+		mutex:       &sync.RWMutex{},
+		credentials: map[string]docker.AuthConfiguration{},
+	}
+}
+
+func (c *Credentials) Add(name string, auth docker.AuthConfiguration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.credentials[name] = auth
+}
+
+// The names of the loaded credentials, never the secrets themselves
+func (c *Credentials) Names() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	names := []string{}
+	for name := range c.credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Credentials) Has(name string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	_, ok := c.credentials[name]
+	return ok
+}
+
+// Pick the credential to pull image with, returning its name, which is
+// empty for an anonymous pull. If name is set that credential must exist,
+// otherwise we look for one matching the image's registry host and fall
+// back to anonymous pulls. When several match, credentials given their
+// own name, as in a sealed file, win over those named after their host,
+// as from a docker config, and then the first name in order wins
+func (c *Credentials) For(image string, name string) (string, docker.AuthConfiguration, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if name != "" {
+		auth, ok := c.credentials[name]
+		if !ok {
+			return "", docker.AuthConfiguration{}, fmt.Errorf("registry credential %v not found", name)
+		}
+		return name, auth, nil
+	}
+
+	host := RegistryHost(image)
+	matching := []string{}
+	for name, auth := range c.credentials {
+		if normalizeHost(auth.ServerAddress) == host {
+			matching = append(matching, name)
+		}
+	}
+	if len(matching) == 0 {
+		return "", docker.AuthConfiguration{}, nil
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		iNamed, jNamed := matching[i] != host, matching[j] != host
+		if iNamed != jNamed {
+			return iNamed
+		}
+		return matching[i] < matching[j]
+	})
+	return matching[0], c.credentials[matching[0]], nil
+}
+
+// The registry an image reference will be pulled from,
+// following docker's rule that the first path component is a
+// registry host only if it looks like one
+func RegistryHost(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 {
+		return dockerHub
+	}
+	first := parts[0]
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return normalizeHost(first)
+	}
+	return dockerHub
+}
+
+// server addresses in docker config files come in a few shapes,
+// e.g. "https://index.docker.io/v1/", "quay.io", "localhost:5000"
+func normalizeHost(address string) string {
+	if strings.Contains(address, "://") {
+		if parsed, err := url.Parse(address); err == nil {
+			address = parsed.Host
+		}
+	}
+	address = strings.SplitN(address, "/", 2)[0]
+	switch address {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHub
+	}
+	return address
+}
+
+// Load every credential in a docker config.json (or legacy .dockercfg),
+// naming each one after its registry host
+func (c *Credentials) LoadDockerConfig(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	configs, err := docker.NewAuthConfigurations(file)
+	if err != nil {
+		return fmt.Errorf("reading docker config %v: %v", path, err)
+	}
+
+	for address, auth := range configs.Configs {
+		if auth.ServerAddress == "" {
+			auth.ServerAddress = address
+		}
+		c.Add(normalizeHost(address), auth)
+	}
+	return nil
+}
+
+// Load credentials from a file produced by `docket seal`, whose plaintext
+// is a json object of credential name to docker.AuthConfiguration
+func (c *Credentials) LoadSealedFile(path string, key *seal.Key) error {
+	sealed, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := seal.Open(key, sealed)
+	if err != nil {
+		return fmt.Errorf("reading credentials %v: %v", path, err)
+	}
+
+	named := map[string]docker.AuthConfiguration{}
+	err = json.Unmarshal(plaintext, &named)
+	if err != nil {
+		return fmt.Errorf("reading credentials %v: %v", path, err)
+	}
+
+	for name, auth := range named {
+		c.Add(name, auth)
+	}
+	return nil
+}
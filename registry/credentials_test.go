@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"encoding/json"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/seal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistryHost(t *testing.T) {
+	cases := map[string]string{
+		"busybox":                        "docker.io",
+		"busybox:latest":                 "docker.io",
+		"library/busybox":                "docker.io",
+		"quay.io/coreos/etcd:v3":         "quay.io",
+		"localhost:5000/foo":             "localhost:5000",
+		"localhost/foo":                  "localhost",
+		"index.docker.io/library/ubuntu": "docker.io",
+	}
+	for image, expected := range cases {
+		if host := RegistryHost(image); host != expected {
+			t.Errorf("expected registry host of %v to be %v but was %v", image, expected, host)
+		}
+	}
+}
+
+func TestCredentialsFor(t *testing.T) {
+	credentials := NewCredentials()
+	credentials.Add("hub", docker.AuthConfiguration{Username: "hubuser", ServerAddress: "https://index.docker.io/v1/"})
+	credentials.Add("quay", docker.AuthConfiguration{Username: "quayuser", ServerAddress: "quay.io"})
+
+	_, auth, _ := credentials.For("quay.io/foo/bar", "")
+	if auth.Username != "quayuser" {
+		t.Errorf("expected quay credential to match quay.io image but got %v", auth.Username)
+	}
+
+	_, auth, _ = credentials.For("busybox", "")
+	if auth.Username != "hubuser" {
+		t.Errorf("expected hub credential to match docker hub image but got %v", auth.Username)
+	}
+
+	_, auth, _ = credentials.For("gcr.io/foo/bar", "")
+	if auth.Username != "" {
+		t.Errorf("expected anonymous pull for unknown registry but got %v", auth.Username)
+	}
+
+	_, auth, _ = credentials.For("busybox", "quay")
+	if auth.Username != "quayuser" {
+		t.Errorf("expected named credential to win but got %v", auth.Username)
+	}
+
+	_, _, err := credentials.For("busybox", "nope")
+	if err == nil {
+		t.Error("expected an error for an unknown named credential")
+	}
+
+	// several credentials for one registry always pick the same one
+	credentials.Add("quay.io", docker.AuthConfiguration{Username: "configuser", ServerAddress: "quay.io"})
+	credentials.Add("quay-backup", docker.AuthConfiguration{Username: "backupuser", ServerAddress: "https://quay.io"})
+	for i := 0; i < 20; i++ {
+		name, auth, _ := credentials.For("quay.io/foo/bar", "")
+		if name != "quay" || auth.Username != "quayuser" {
+			t.Fatalf("expected the first named quay credential to win but got %v", name)
+		}
+	}
+	credentials = NewCredentials()
+	credentials.Add("quay.io", docker.AuthConfiguration{Username: "configuser", ServerAddress: "quay.io"})
+	if name, _, _ := credentials.For("quay.io/foo/bar", ""); name != "quay.io" {
+		t.Errorf("expected the docker config credential when it's the only match but got %v", name)
+	}
+}
+
+func TestLoadSealedFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "docket-registry")
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key")
+	ioutil.WriteFile(keyFile, []byte("correct horse battery staple\n"), 0600)
+	key, _ := seal.KeyFromFile(keyFile)
+
+	plaintext, _ := json.Marshal(map[string]docker.AuthConfiguration{
+		"private": {Username: "me", Password: "secret", ServerAddress: "registry.example.com"},
+	})
+	sealed, _ := seal.Seal(key, plaintext)
+	credentialsFile := filepath.Join(dir, "creds.sealed")
+	ioutil.WriteFile(credentialsFile, sealed, 0600)
+
+	credentials := NewCredentials()
+	err := credentials.LoadSealedFile(credentialsFile, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, auth, _ := credentials.For("registry.example.com/app", "")
+	if auth.Password != "secret" {
+		t.Errorf("expected sealed credential to be loaded but got %+v", auth)
+	}
+
+	otherKey := seal.Key{}
+	err = NewCredentials().LoadSealedFile(credentialsFile, &otherKey)
+	if err == nil {
+		t.Error("expected loading with the wrong key to fail")
+	}
+}
@@ -0,0 +1,87 @@
+package run
+
+import (
+	"sync"
+	"time"
+)
+
+// Tracks image pulls so that allocations sharing an image and registry
+// credential share one in-flight pull, and remembers when each image was
+// last pulled so IfNotPresent allocations can decide whether to refresh it
+type pullGroup struct {
+	mutex *sync.Mutex
+	// keyed by image and credential, a pull with another credential
+	// mustn't succeed or fail on behalf of this one
+	inFlight map[pullKey]*pullCall
+	pulled   map[string]time.Time
+}
+
+type pullKey struct {
+	image      string
+	credential string
+}
+
+type pullCall struct {
+	done chan struct{}
+	err  error
+	// callers waiting on someone else's pull
+	waiting int
+}
+
+func newPullGroup() *pullGroup {
+	return &pullGroup{
+		mutex:    &sync.Mutex{},
+		inFlight: map[pullKey]*pullCall{},
+		pulled:   map[string]time.Time{},
+	}
+}
+
+// Run pull for image with the named credential, empty for anonymous,
+// unless a pull of the same image with the same credential is already
+// in progress, in which case wait for that one and return its result.
+// shared is true if the result came from someone else's pull
+func (group *pullGroup) Do(image string, credential string, pull func() error) (shared bool, err error) {
+	key := pullKey{image: image, credential: credential}
+	group.mutex.Lock()
+	if call, ok := group.inFlight[key]; ok {
+		call.waiting++
+		group.mutex.Unlock()
+		<-call.done
+		return true, call.err
+	}
+
+	call := &pullCall{done: make(chan struct{})}
+	group.inFlight[key] = call
+	group.mutex.Unlock()
+
+	call.err = pull()
+
+	group.mutex.Lock()
+	delete(group.inFlight, key)
+	if call.err == nil {
+		group.pulled[image] = time.Now()
+	}
+	group.mutex.Unlock()
+	close(call.done)
+
+	return false, call.err
+}
+
+// How many callers are waiting on the in-flight pull of image with
+// credential, 0 if there isn't one
+func (group *pullGroup) waiting(image string, credential string) int {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if call, ok := group.inFlight[pullKey{image: image, credential: credential}]; ok {
+		return call.waiting
+	}
+	return 0
+}
+
+// When image was last pulled successfully by this server,
+// or the zero time if it hasn't been
+func (group *pullGroup) LastPulled(image string) time.Time {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return group.pulled[image]
+}
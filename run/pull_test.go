@@ -0,0 +1,86 @@
+package run
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPullGroupSharesInFlightPulls(t *testing.T) {
+	group := newPullGroup()
+	release := make(chan struct{})
+	pulls := 0
+
+	wg := &sync.WaitGroup{}
+	shared := make(chan bool, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wasShared, _ := group.Do("busybox:latest", "hub", func() error {
+				pulls++
+				<-release
+				return nil
+			})
+			shared <- wasShared
+		}()
+	}
+
+	// wait for every goroutine to join the pull before it finishes
+	deadline := time.Now().Add(5 * time.Second)
+	for group.waiting("busybox:latest", "hub") < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 4 callers to join the pull but %v did", group.waiting("busybox:latest", "hub"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(shared)
+
+	if pulls != 1 {
+		t.Errorf("expected exactly one pull but got %v", pulls)
+	}
+
+	sharedCount := 0
+	for wasShared := range shared {
+		if wasShared {
+			sharedCount++
+		}
+	}
+	if sharedCount != 4 {
+		t.Errorf("expected 4 callers to share the pull but got %v", sharedCount)
+	}
+
+	if group.LastPulled("busybox:latest").IsZero() {
+		t.Error("expected last pulled time to be recorded")
+	}
+}
+
+func TestPullGroupKeysOnCredential(t *testing.T) {
+	group := newPullGroup()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		group.Do("private/app:latest", "team-a", func() error {
+			close(started)
+			<-release
+			return nil
+		})
+		close(done)
+	}()
+	<-started
+
+	// a pull with team-a's credential mustn't stand in for team-b's
+	pulled := false
+	shared, _ := group.Do("private/app:latest", "team-b", func() error {
+		pulled = true
+		return nil
+	})
+	if shared || !pulled {
+		t.Error("expected a pull with another credential not to be shared")
+	}
+	close(release)
+	<-done
+}
@@ -0,0 +1,293 @@
+package run
+
+import (
+	"fmt"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/allocations"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many runs may happen at once. Zero means no limit
+type Limits struct {
+	// goroutines starting runs, so at most this many run at once
+	Workers int
+	// containers running at once across every allocation
+	MaxConcurrent int
+	// containers running at once per image, keyed by image
+	// with or without its tag, e.g. busybox or busybox:latest
+	PerImage map[string]int
+	// containers running at once per label, keyed by key=value
+	PerLabel map[string]int
+	// runs at once per namespace, keyed by namespace
+	PerNamespace map[string]int
+}
+
+// A run waiting for, or running in, the queue, as returned by GET /queue
+type QueuedRun struct {
+	Namespace string        `json:"Namespace"`
+	Name      string        `json:"Name"`
+	Image     string        `json:"Image"`
+	Priority  int           `json:"Priority"`
+	Queued    time.Time     `json:"Queued"`
+	Started   *time.Time    `json:"Started,omitempty"`
+	Waited    time.Duration `json:"Waited"`
+	// set while waiting, whichever limit is holding the run back
+	BlockedBy string `json:"BlockedBy,omitempty"`
+
+	alloc    *allocations.Allocation
+	upstream *allocations.Upstream
+	// the fencing token it was scheduled under
+	token uint64
+	seq   int
+	keys  []string
+}
+
+type QueueStatus struct {
+	Workers       int         `json:"Workers"`
+	MaxConcurrent int         `json:"MaxConcurrent"`
+	Running       []QueuedRun `json:"Running"`
+	Queued        []QueuedRun `json:"Queued"`
+}
+
+// An AllocationRunner that queues runs and hands them to a pool of
+// workers, highest Priority first and otherwise in the order they were
+// queued, as the global, per-image, per-label and per-namespace limits
+// allow. A run held back by a limit doesn't stop runs behind it that aren't
+type Queue struct {
+	runner AllocationRunner
+	store  allocations.AllocationStore
+	limits Limits
+
+	mutex   *sync.Mutex
+	changed *sync.Cond
+	queued  []*QueuedRun
+	running map[*QueuedRun]bool
+	// running containers per image and label key
+	counts map[string]int
+	seq    int
+}
+
+func NewQueue(runner AllocationRunner, store allocations.AllocationStore, limits Limits) *Queue {
+	if limits.Workers < 1 {
+		limits.Workers = 1
+	}
+	mutex := &sync.Mutex{}
+	return &Queue{
+		runner:  runner,
+		store:   store,
+		limits:  limits,
+		mutex:   mutex,
+		changed: sync.NewCond(mutex),
+		running: map[*QueuedRun]bool{},
+		counts:  map[string]int{},
+	}
+}
+
+func (q *Queue) Start() {
+	for i := 0; i < q.limits.Workers; i++ {
+		go q.work()
+	}
+}
+
+// Returned when a run is queued for an allocation that
+// already has one waiting in the queue
+type AlreadyQueuedError struct {
+	Namespace string
+	Name      string
+}
+
+func (err *AlreadyQueuedError) Error() string {
+	return fmt.Sprintf("a run of %v/%v is already queued", err.Namespace, err.Name)
+}
+
+// Queue a run and return straight away
+func (q *Queue) RunAllocation(alloc *allocations.Allocation, upstream *allocations.Upstream, token uint64) {
+	q.Enqueue(alloc, upstream, token)
+}
+
+// Queue a run and return straight away. A run for an allocation that's
+// already waiting in the queue is skipped, logged to the allocation and
+// an *AlreadyQueuedError returned
+func (q *Queue) Enqueue(alloc *allocations.Allocation, upstream *allocations.Upstream, token uint64) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, queued := range q.queued {
+		if queued.Namespace == alloc.Namespace && queued.Name == alloc.Name {
+			log.Printf("Skipping run of %v/%v, a run is already queued", alloc.Namespace, alloc.Name)
+			q.store.Log(alloc, "Skipped run, a run is already queued")
+			return &AlreadyQueuedError{Namespace: alloc.Namespace, Name: alloc.Name}
+		}
+	}
+
+	images := alloc.Images()
+	q.seq++
+	q.queued = append(q.queued, &QueuedRun{
+		Namespace: alloc.Namespace,
+		Name:      alloc.Name,
+		Image:     strings.Join(images, ","),
+		Priority:  alloc.Priority,
+		Queued:    time.Now(),
+		alloc:     alloc,
+		upstream:  upstream,
+		token:     token,
+		seq:       q.seq,
+		keys:      limitKeys(alloc.Namespace, images, alloc.Labels),
+	})
+	q.changed.Broadcast()
+	return nil
+}
+
+// the keys a run counts against, its namespace, each image
+// with and without its tag and each label as key=value
+func limitKeys(namespace string, images []string, labels map[string]string) []string {
+	keys := []string{"namespace:" + namespace}
+	// steps sharing an image count against its limits once
+	seen := map[string]bool{}
+	for _, image := range images {
+		if image == "" {
+			continue
+		}
+		repo, tag := docker.ParseRepositoryTag(image)
+		if tag == "" {
+			tag = "latest"
+		}
+		for _, key := range []string{"image:" + repo, "image:" + repo + ":" + tag} {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	for key, value := range labels {
+		keys = append(keys, "label:"+key+"="+value)
+	}
+	return keys
+}
+
+func (q *Queue) limitFor(key string) int {
+	switch {
+	case strings.HasPrefix(key, "image:"):
+		return q.limits.PerImage[strings.TrimPrefix(key, "image:")]
+	case strings.HasPrefix(key, "namespace:"):
+		return q.limits.PerNamespace[strings.TrimPrefix(key, "namespace:")]
+	}
+	return q.limits.PerLabel[strings.TrimPrefix(key, "label:")]
+}
+
+func (q *Queue) work() {
+	for {
+		q.mutex.Lock()
+		next := q.next()
+		for next == nil {
+			q.changed.Wait()
+			next = q.next()
+		}
+		q.start(next)
+		q.mutex.Unlock()
+
+		q.runner.RunAllocation(next.alloc, next.upstream, next.token)
+
+		q.mutex.Lock()
+		q.finish(next)
+		q.changed.Broadcast()
+		q.mutex.Unlock()
+	}
+}
+
+// The run to start next, or nil if nothing can start yet. must be called while locked
+func (q *Queue) next() *QueuedRun {
+	if q.limits.MaxConcurrent > 0 && len(q.running) >= q.limits.MaxConcurrent {
+		return nil
+	}
+	q.sortQueued()
+	for _, queued := range q.queued {
+		if q.blockedBy(queued) == "" {
+			return queued
+		}
+	}
+	return nil
+}
+
+// must be called while locked
+func (q *Queue) sortQueued() {
+	sort.SliceStable(q.queued, func(i, j int) bool {
+		if q.queued[i].Priority != q.queued[j].Priority {
+			return q.queued[i].Priority > q.queued[j].Priority
+		}
+		return q.queued[i].seq < q.queued[j].seq
+	})
+}
+
+// The limit a run is waiting on, empty if it could start. must be called while locked
+func (q *Queue) blockedBy(queued *QueuedRun) string {
+	if q.limits.MaxConcurrent > 0 && len(q.running) >= q.limits.MaxConcurrent {
+		return "MaxConcurrent"
+	}
+	for _, key := range queued.keys {
+		limit := q.limitFor(key)
+		if limit > 0 && q.counts[key] >= limit {
+			return key
+		}
+	}
+	if len(q.running) >= q.limits.Workers {
+		return "Workers"
+	}
+	return ""
+}
+
+// must be called while locked
+func (q *Queue) start(run *QueuedRun) {
+	for i, queued := range q.queued {
+		if queued == run {
+			q.queued = append(q.queued[:i], q.queued[i+1:]...)
+			break
+		}
+	}
+	now := time.Now()
+	run.Started = &now
+	run.Waited = now.Sub(run.Queued)
+	q.running[run] = true
+	for _, key := range run.keys {
+		q.counts[key]++
+	}
+}
+
+// must be called while locked
+func (q *Queue) finish(run *QueuedRun) {
+	delete(q.running, run)
+	for _, key := range run.keys {
+		q.counts[key]--
+	}
+}
+
+// What's running and what's waiting, in the order it will start
+func (q *Queue) Status() QueueStatus {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	status := QueueStatus{
+		Workers:       q.limits.Workers,
+		MaxConcurrent: q.limits.MaxConcurrent,
+		Running:       []QueuedRun{},
+		Queued:        []QueuedRun{},
+	}
+	for run := range q.running {
+		status.Running = append(status.Running, *run)
+	}
+	sort.Slice(status.Running, func(i, j int) bool { return status.Running[i].Started.Before(*status.Running[j].Started) })
+
+	now := time.Now()
+	q.sortQueued()
+	for _, queued := range q.queued {
+		waiting := *queued
+		waiting.Waited = now.Sub(queued.Queued)
+		waiting.BlockedBy = q.blockedBy(queued)
+		status.Queued = append(status.Queued, waiting)
+	}
+	return status
+}
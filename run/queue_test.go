@@ -0,0 +1,142 @@
+package run
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/allocations"
+	"sync"
+	"testing"
+	"time"
+)
+
+// records the order runs start in, and the tokens they
+// were started with, and holds them until released
+type blockingRunner struct {
+	mutex   sync.Mutex
+	started []string
+	tokens  []uint64
+	release chan struct{}
+}
+
+func (runner *blockingRunner) RunAllocation(alloc *allocations.Allocation, upstream *allocations.Upstream, token uint64) {
+	runner.mutex.Lock()
+	runner.started = append(runner.started, alloc.Name)
+	runner.tokens = append(runner.tokens, token)
+	runner.mutex.Unlock()
+	<-runner.release
+}
+
+func (runner *blockingRunner) startedRuns() []string {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	return append([]string{}, runner.started...)
+}
+
+func queueAllocation(name string, image string, priority int) *allocations.Allocation {
+	return &allocations.Allocation{
+		Namespace: "default",
+		Name:      name,
+		Priority:  priority,
+		Container: allocations.CreateContainerOptions{Config: &docker.Config{Image: image}},
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	runner := &blockingRunner{release: make(chan struct{})}
+	queue := NewQueue(runner, allocations.InMemory(), Limits{Workers: 1})
+
+	queue.RunAllocation(queueAllocation("low", "alpine", 0), nil, 1)
+	queue.RunAllocation(queueAllocation("high", "alpine", 10), nil, 1)
+	// scheduled in a later term
+	queue.RunAllocation(queueAllocation("later", "alpine", 0), nil, 2)
+	// already queued, skipped
+	if _, ok := queue.Enqueue(queueAllocation("low", "alpine", 0), nil, 2).(*AlreadyQueuedError); !ok {
+		t.Error("expected a second run of low to be refused while one is queued")
+	}
+	queue.Start()
+
+	for i := 0; i < 3; i++ {
+		runner.release <- struct{}{}
+	}
+	started := runner.startedRuns()
+	if len(started) != 3 || started[0] != "high" || started[1] != "low" || started[2] != "later" {
+		t.Errorf("expected high, then low and later in the order they were queued, but started %v", started)
+	}
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	if tokens := runner.tokens; len(tokens) != 3 || tokens[0] != 1 || tokens[1] != 1 || tokens[2] != 2 {
+		t.Errorf("expected runs to keep the tokens they were queued with, but got %v", tokens)
+	}
+}
+
+func TestQueueLimits(t *testing.T) {
+	runner := &blockingRunner{release: make(chan struct{})}
+	queue := NewQueue(runner, allocations.InMemory(), Limits{
+		Workers:  3,
+		PerImage: map[string]int{"busybox": 1},
+	})
+
+	queue.RunAllocation(queueAllocation("first", "busybox:latest", 0), nil, 0)
+	queue.RunAllocation(queueAllocation("second", "busybox", 0), nil, 0)
+	queue.RunAllocation(queueAllocation("other", "alpine", 0), nil, 0)
+	queue.Start()
+
+	// busybox is limited to 1, so second waits but other doesn't
+	waitFor(t, "first and other to start", func() bool { return len(runner.startedRuns()) == 2 })
+	status := queue.Status()
+	if len(status.Queued) != 1 || status.Queued[0].Name != "second" || status.Queued[0].BlockedBy != "image:busybox" {
+		t.Errorf("expected second to be waiting on the busybox limit but queue was %+v", status.Queued)
+	}
+
+	for i := 0; i < 3; i++ {
+		runner.release <- struct{}{}
+	}
+	if started := runner.startedRuns(); len(started) != 3 || started[2] != "second" {
+		t.Errorf("expected second to run once busybox was free but started %v", started)
+	}
+	waitFor(t, "an empty queue", func() bool {
+		status := queue.Status()
+		return len(status.Queued) == 0 && len(status.Running) == 0
+	})
+}
+
+func TestQueueNamespaceLimit(t *testing.T) {
+	runner := &blockingRunner{release: make(chan struct{})}
+	queue := NewQueue(runner, allocations.InMemory(), Limits{
+		Workers:      3,
+		PerNamespace: map[string]int{"data": 1},
+	})
+
+	first := queueAllocation("first", "busybox", 0)
+	first.Namespace = "data"
+	second := queueAllocation("second", "alpine", 0)
+	second.Namespace = "data"
+	queue.RunAllocation(first, nil, 0)
+	queue.RunAllocation(second, nil, 0)
+	queue.RunAllocation(queueAllocation("other", "alpine", 0), nil, 0)
+	queue.Start()
+
+	// data is limited to 1, so second waits rather than being dropped
+	waitFor(t, "first and other to start", func() bool { return len(runner.startedRuns()) == 2 })
+	status := queue.Status()
+	if len(status.Queued) != 1 || status.Queued[0].Name != "second" || status.Queued[0].BlockedBy != "namespace:data" {
+		t.Errorf("expected second to be waiting on the data namespace's limit but queue was %+v", status.Queued)
+	}
+
+	for i := 0; i < 3; i++ {
+		runner.release <- struct{}{}
+	}
+	if started := runner.startedRuns(); len(started) != 3 || started[2] != "second" {
+		t.Errorf("expected second to run once data had room but started %v", started)
+	}
+}
+
+// poll condition until it holds, failing after a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
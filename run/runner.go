@@ -1,70 +1,232 @@
 package run
 
 import (
+	"bytes"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/notify"
+	"github.com/horthy/docket/registry"
+	"github.com/horthy/docket/secrets"
 	"log"
+	"strings"
+	"time"
 )
 
+// how many lines of container output to keep on each run record
+const outputTailLines = 20
+
+// Runs allocations. upstream is the run that triggered this one,
+// or nil for scheduled and manually triggered runs. token is the
+// scheduler lease fencing token the run was scheduled under, so the
+// store can refuse it once a newer leader has claimed the store, or
+// 0 for a run that isn't fenced
 type AllocationRunner interface {
-	RunAllocation(alloc *allocations.Allocation)
+	RunAllocation(alloc *allocations.Allocation, upstream *allocations.Upstream, token uint64)
 }
 
 type FsouzaAllocationRunner struct {
-	store  allocations.AllocationStore
-	client *docker.Client
+	store       allocations.AllocationStore
+	client      *docker.Client
+	notifier    notify.Notifier
+	credentials *registry.Credentials
+	secrets     secrets.Store
+	pulls       *pullGroup
 }
 
 func NewFsouza(
 	client *docker.Client,
 	store allocations.AllocationStore,
+	notifier notify.Notifier,
+	credentials *registry.Credentials,
+	secretStore secrets.Store,
 ) *FsouzaAllocationRunner {
 	return &FsouzaAllocationRunner{
-		client: client,
-		store:  store,
+		client:      client,
+		store:       store,
+		notifier:    notifier,
+		credentials: credentials,
+		secrets:     secretStore,
+		pulls:       newPullGroup(),
 	}
 }
 
-func (runner *FsouzaAllocationRunner) RunAllocation(alloc *allocations.Allocation) {
+func (runner *FsouzaAllocationRunner) RunAllocation(alloc *allocations.Allocation, upstream *allocations.Upstream, token uint64) {
 	log.Printf("Creating container for allocation %v with cron %v", alloc.Name, alloc.Cron)
 
+	previous := runner.lastRun(alloc)
+	run := allocations.NewRun()
+	run.Revision = alloc.Revision
+	run.FencingToken = token
+	run.TriggeredBy = upstream
+	runner.execute(alloc, run)
+	run.FinishedAt = time.Now()
+
+	err := runner.store.RecordRun(alloc, run)
+	if _, stale := err.(*allocations.StaleTokenError); stale {
+		// the new leader owns this run, don't report it as ours
+		log.Printf("Dropping run %v for %v, error was %v", run.ID, alloc.Name, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to record run %v for %v, error was %v", run.ID, alloc.Name, err)
+	}
+
+	for _, event := range notify.RunEvents(alloc, previous, run) {
+		runner.notifier.Notify(alloc, event)
+	}
+}
+
+// The allocation's latest recorded run, from the store, as alloc can
+// be the scheduler's snapshot, which doesn't carry its runs
+func (runner *FsouzaAllocationRunner) lastRun(alloc *allocations.Allocation) *allocations.Run {
+	stored, err := runner.store.Get(alloc.Namespace, alloc.Name)
+	if err != nil {
+		return nil
+	}
+	return stored.LastRun()
+}
+
+// run the allocation's container, or each of its steps,
+// filling in the outcome on run as we go
+func (runner *FsouzaAllocationRunner) execute(alloc *allocations.Allocation, run *allocations.Run) {
+	if len(alloc.Steps) > 0 {
+		runner.executeSteps(alloc, run)
+		return
+	}
+	runner.executeContainer(alloc, alloc.Container, run)
+}
+
+// pull, create, start and wait for one container, filling in
+// the outcome on run as we go
+func (runner *FsouzaAllocationRunner) executeContainer(alloc *allocations.Allocation, options allocations.CreateContainerOptions, run *allocations.Run) {
+	run.Status = allocations.RunFailed
+	run.ExitCode = -1
+
 	// pull image -- might want to this on allocation creation so we can bail
 	// if the image doesn't exist, but leaving it here for now
-	err := runner.pullImage(alloc)
+	err := runner.ensureImage(alloc, options.Config.Image)
+	if err != nil {
+		run.Error = err.Error()
+		return
+	}
+
+	// the pull can be slow, make sure we weren't deposed in the meantime
+	err = runner.store.Fence(run.FencingToken)
+	if err != nil {
+		run.Error = err.Error()
+		return
+	}
+
+	container, err := runner.createContainer(alloc, options)
 	if err != nil {
+		run.Error = err.Error()
 		return
 	}
+	run.ContainerID = container.ID
 
-	container, err := runner.createContainer(alloc)
+	// attach before starting so we still get the output
+	// of containers that are removed as soon as they exit
+	output := new(bytes.Buffer)
+	attached, err := runner.client.AttachToContainerNonBlocking(docker.AttachToContainerOptions{
+		Container:    container.ID,
+		OutputStream: output,
+		ErrorStream:  output,
+		Stream:       true,
+		Stdout:       true,
+		Stderr:       true,
+	})
 	if err != nil {
+		log.Printf("Failed to attach to container for %v, output will not be recorded, error was %v", alloc.Name, err)
+	}
+
+	err = runner.startContainer(alloc, container, options.HostConfig)
+	if err != nil {
+		run.Error = err.Error()
 		return
 	}
 
-	runner.startContainer(alloc, container)
+	exited := runner.waitForContainer(alloc, container, run)
+
+	if attached != nil && exited {
+		attached.Wait()
+		run.Output = tail(output.String(), outputTailLines)
+	}
+}
+
+// pull the allocation's image if its PullPolicy says we should
+func (runner *FsouzaAllocationRunner) ensureImage(alloc *allocations.Allocation, image string) error {
+	switch alloc.PullPolicy {
+	case allocations.PullNever:
+		log.Printf("Not pulling %v for %v, pull policy is Never", image, alloc.Name)
+		return nil
+	case allocations.PullIfNotPresent:
+		if runner.imageIsFresh(alloc, image) {
+			log.Printf("Using local %v for %v", image, alloc.Name)
+			return nil
+		}
+	}
+
+	return runner.pullImage(alloc, image)
 }
 
-func (runner *FsouzaAllocationRunner) pullImage(alloc *allocations.Allocation) error {
-	repo, tag := docker.ParseRepositoryTag(alloc.Container.Config.Image)
+// whether an IfNotPresent allocation can use the local copy of its image
+func (runner *FsouzaAllocationRunner) imageIsFresh(alloc *allocations.Allocation, image string) bool {
+	_, err := runner.client.InspectImage(image)
+	if err != nil {
+		return false
+	}
+
+	if alloc.PullRefresh <= 0 {
+		return true
+	}
+	// images pulled before this server started count as stale,
+	// so we'll refresh each one at most once more than strictly needed
+	return time.Since(runner.pulls.LastPulled(image)) < alloc.PullRefresh
+}
+
+func (runner *FsouzaAllocationRunner) pullImage(alloc *allocations.Allocation, image string) error {
+	repo, tag := docker.ParseRepositoryTag(image)
 	opts := docker.PullImageOptions{
 		Repository: repo,
 		Tag:        tag,
 	}
 
+	credential, auth, err := runner.credentials.For(image, alloc.RegistryCredential)
+	if err != nil {
+		log.Printf("Failed to find registry credential for %v, error was %v", alloc.Name, err)
+		runner.store.Log(alloc, err)
+		return err
+	}
+
 	log.Printf("Pulling %v:%v for %v", repo, tag, alloc.Name)
-	err := runner.client.PullImage(opts, docker.AuthConfiguration{})
+	shared, err := runner.pulls.Do(image, credential, func() error {
+		return runner.client.PullImage(opts, auth)
+	})
 	if err != nil {
 		log.Printf("Failed to pull image for %v, error was %v", alloc.Name, err)
 		runner.store.Log(alloc, err)
 		return err
 	}
+	if shared {
+		log.Printf("Pulled %v:%v for allocation %v along with another allocation", repo, tag, alloc.Name)
+		runner.store.Log(alloc, "Pulled (shared)", repo, tag, alloc.Name)
+		return nil
+	}
 	log.Printf("Pulled %v:%v for allocation %v", repo, tag, alloc.Name)
 	runner.store.Log(alloc, "Pulled", repo, tag, alloc.Name)
 	return nil
 }
 
-func (runner *FsouzaAllocationRunner) createContainer(alloc *allocations.Allocation) (*docker.Container, error) {
+func (runner *FsouzaAllocationRunner) createContainer(alloc *allocations.Allocation, options allocations.CreateContainerOptions) (*docker.Container, error) {
+	opts, err := runner.containerOptions(alloc, options)
+	if err != nil {
+		log.Printf("Failed to resolve secrets for %v, error was %v", alloc.Name, err)
+		runner.store.Log(alloc, err)
+		return nil, err
+	}
+
 	//create container
-	container, err := runner.client.CreateContainer(alloc.Container.ToOptions())
+	container, err := runner.client.CreateContainer(opts)
 	if err != nil {
 		log.Printf("Failed to create container for %v, error was %v", alloc.Name, err)
 		runner.store.Log(alloc, err)
@@ -73,21 +235,89 @@ func (runner *FsouzaAllocationRunner) createContainer(alloc *allocations.Allocat
 
 	log.Printf("created: %v %v", container.Name, container.ID)
 	runner.store.Log(alloc, "created:", container.Name, container.ID)
+
+	err = runner.uploadSecretFiles(alloc, container, opts.Config)
+	if err != nil {
+		log.Printf("Failed to add secret files for %v, error was %v", alloc.Name, err)
+		runner.store.Log(alloc, err)
+		runner.client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID})
+		return nil, err
+	}
 	return container, nil
 }
 
-func (runner *FsouzaAllocationRunner) startContainer(alloc *allocations.Allocation, container *docker.Container) {
+func (runner *FsouzaAllocationRunner) startContainer(alloc *allocations.Allocation, container *docker.Container, hostConfig *docker.HostConfig) error {
 	// start
-	err := runner.client.StartContainer(container.ID, alloc.Container.HostConfig)
+	err := runner.client.StartContainer(container.ID, hostConfig)
 	if err != nil {
 		log.Printf("Failed to start container for %v, error was %v", alloc.Name, err)
 		runner.store.Log(alloc, err)
 		runner.client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID})
 		log.Printf("tried to remove container for %v, error was %v", alloc.Name, err)
 		runner.store.Log(alloc, "removed container because", err)
-		return
+		return err
 	}
 	log.Printf("started: %v %v", container.Name, container.ID)
 	runner.store.Log(alloc, "started:", container.Name, container.ID)
+	return nil
+}
 
+type waitResult struct {
+	exitCode int
+	err      error
+}
+
+// block until the container exits, stopping it if it
+// runs for longer than the allocation's timeout. Returns
+// false if we gave up on a container that may still be running
+func (runner *FsouzaAllocationRunner) waitForContainer(alloc *allocations.Allocation, container *docker.Container, run *allocations.Run) bool {
+	done := make(chan waitResult, 1)
+	go func() {
+		exitCode, err := runner.client.WaitContainer(container.ID)
+		done <- waitResult{exitCode, err}
+	}()
+
+	// a nil channel blocks forever, so no timeout means we just wait
+	var timeout <-chan time.Time
+	if alloc.Timeout > 0 {
+		timeout = time.After(alloc.Timeout)
+	}
+
+	select {
+	case result := <-done:
+		if result.err != nil {
+			log.Printf("Failed to wait for container for %v, error was %v", alloc.Name, result.err)
+			runner.store.Log(alloc, "failed waiting for", container.ID, result.err)
+			run.Error = result.err.Error()
+			return false
+		}
+		run.ExitCode = result.exitCode
+		if result.exitCode == 0 {
+			run.Status = allocations.RunSucceeded
+		}
+		log.Printf("exited: %v %v with %v", container.Name, container.ID, result.exitCode)
+		runner.store.Log(alloc, "exited:", container.ID, "status", result.exitCode)
+		return true
+	case <-timeout:
+		log.Printf("Container for %v exceeded timeout of %v, stopping", alloc.Name, alloc.Timeout)
+		runner.store.Log(alloc, "timed out after", alloc.Timeout, container.ID)
+		run.Status = allocations.RunTimedOut
+		run.Error = "timed out after " + alloc.Timeout.String()
+		err := runner.client.StopContainer(container.ID, 10)
+		if err != nil {
+			log.Printf("Failed to stop container for %v, error was %v", alloc.Name, err)
+			runner.store.Log(alloc, "failed to stop", container.ID, err)
+			return false
+		}
+		<-done
+		return true
+	}
+}
+
+func tail(output string, lines int) string {
+	split := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(split) > lines {
+		split = split[len(split)-lines:]
+	}
+	return strings.Join(split, "\n")
 }
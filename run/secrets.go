@@ -0,0 +1,112 @@
+package run
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/allocations"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The options to create one of alloc's containers with, including any
+// secrets it asks for as environment variables. The allocation's own config
+// is left untouched so secret values never end up in the store
+func (runner *FsouzaAllocationRunner) containerOptions(alloc *allocations.Allocation, options allocations.CreateContainerOptions) (docker.CreateContainerOptions, error) {
+	opts := options.ToOptions()
+	if len(alloc.Secrets) == 0 {
+		return opts, nil
+	}
+
+	config := *opts.Config
+	config.Env = append([]string{}, config.Env...)
+	for _, ref := range alloc.Secrets {
+		if ref.Env == "" {
+			continue
+		}
+		value, err := runner.secrets.Get(ref.Name)
+		if err != nil {
+			return opts, err
+		}
+		config.Env = append(config.Env, ref.Env+"="+value)
+	}
+	opts.Config = &config
+	return opts, nil
+}
+
+// Copy secrets that alloc asks for as files into the created container,
+// owned by the User in its config. Uploading rather than bind mounting
+// means this works against a remote docker host and nothing is written
+// to the server's disk
+func (runner *FsouzaAllocationRunner) uploadSecretFiles(alloc *allocations.Allocation, container *docker.Container, config *docker.Config) error {
+	archive := new(bytes.Buffer)
+	writer := tar.NewWriter(archive)
+	files := 0
+
+	for _, ref := range alloc.Secrets {
+		if ref.File == "" {
+			continue
+		}
+		value, err := runner.secrets.Get(ref.Name)
+		if err != nil {
+			return err
+		}
+
+		err = writer.WriteHeader(secretFileHeader(ref, int64(len(value)), config.User))
+		if err != nil {
+			return err
+		}
+		if _, err = writer.Write([]byte(value)); err != nil {
+			return err
+		}
+		files++
+	}
+
+	if files == 0 {
+		return nil
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	err := runner.client.UploadToContainer(container.ID, docker.UploadToContainerOptions{
+		InputStream: archive,
+		Path:        "/",
+	})
+	if err != nil {
+		return fmt.Errorf("uploading secret files: %v", err)
+	}
+	return nil
+}
+
+// The header to upload a secret file with. Only the file's owner can read it
+// unless the reference has a Mode. docker sets an uploaded file's owner from
+// the header's numeric ids, so a User given as uid or uid:gid owns the file,
+// and one given by name needs a Mode that lets it read a file root owns
+func secretFileHeader(ref allocations.SecretReference, size int64, user string) *tar.Header {
+	header := &tar.Header{
+		Name:    strings.TrimPrefix(ref.File, "/"),
+		Mode:    0400,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if mode, err := strconv.ParseUint(ref.Mode, 8, 32); err == nil {
+		// validated when the allocation was stored
+		header.Mode = int64(mode)
+	}
+
+	parts := strings.SplitN(user, ":", 2)
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return header
+	}
+	header.Uid = uid
+	if len(parts) == 2 {
+		if gid, err := strconv.Atoi(parts[1]); err == nil {
+			header.Gid = gid
+		}
+	}
+	return header
+}
@@ -0,0 +1,27 @@
+package run
+
+import (
+	"github.com/horthy/docket/allocations"
+	"testing"
+)
+
+func TestSecretFileHeader(t *testing.T) {
+	ref := allocations.SecretReference{Name: "gcs-key", File: "/etc/backup/key.json"}
+	header := secretFileHeader(ref, 10, "")
+	if header.Name != "etc/backup/key.json" || header.Mode != 0400 || header.Uid != 0 || header.Gid != 0 {
+		t.Errorf("expected a root owned file only root can read but got %+v", header)
+	}
+
+	if header := secretFileHeader(ref, 10, "1000:2000"); header.Uid != 1000 || header.Gid != 2000 {
+		t.Errorf("expected the file to be owned by the container's user but got %v:%v", header.Uid, header.Gid)
+	}
+	if header := secretFileHeader(ref, 10, "1000"); header.Uid != 1000 || header.Gid != 0 {
+		t.Errorf("expected the file to be owned by the container's uid but got %v:%v", header.Uid, header.Gid)
+	}
+
+	// a named user can't be looked up, so the mode has to let it read
+	ref.Mode = "0444"
+	if header := secretFileHeader(ref, 10, "app"); header.Mode != 0444 || header.Uid != 0 {
+		t.Errorf("expected a root owned file with the reference's mode but got %+v", header)
+	}
+}
@@ -0,0 +1,104 @@
+package run
+
+import (
+	"fmt"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/allocations"
+	"log"
+	"time"
+)
+
+// run each of alloc's steps in order with a volume for this run mounted
+// at its Workspace, stopping at the first step that doesn't succeed.
+// The run takes the outcome of the last step attempted
+func (runner *FsouzaAllocationRunner) executeSteps(alloc *allocations.Allocation, run *allocations.Run) {
+	run.Status = allocations.RunFailed
+	run.ExitCode = -1
+
+	volume, err := runner.client.CreateVolume(docker.CreateVolumeOptions{
+		Name:   workspaceName(run),
+		Labels: map[string]string{"docket.namespace": alloc.Namespace, "docket.allocation": alloc.Name, "docket.run": run.ID},
+	})
+	if err != nil {
+		log.Printf("Failed to create workspace for %v, error was %v", alloc.Name, err)
+		runner.store.Log(alloc, "failed to create workspace", err)
+		run.Error = fmt.Sprintf("creating workspace: %v", err)
+		return
+	}
+	runner.store.Log(alloc, "created workspace", volume.Name)
+	defer runner.removeWorkspace(alloc, volume.Name, run)
+
+	for _, step := range alloc.Steps {
+		result := runner.executeStep(alloc, step, volume.Name, run)
+		run.Steps = append(run.Steps, result)
+
+		run.ContainerID = result.ContainerID
+		run.Status = result.Status
+		run.ExitCode = result.ExitCode
+		run.Output = result.Output
+		run.Error = result.Error
+		if result.Status != allocations.RunSucceeded {
+			log.Printf("Step %v of %v did not succeed, skipping the rest", step.Name, alloc.Name)
+			runner.store.Log(alloc, "step", step.Name, "did not succeed, skipping the rest")
+			if run.Error == "" {
+				run.Error = fmt.Sprintf("step %v exited with %v", step.Name, result.ExitCode)
+			}
+			return
+		}
+	}
+}
+
+func (runner *FsouzaAllocationRunner) executeStep(alloc *allocations.Allocation, step allocations.Step, volume string, run *allocations.Run) *allocations.StepResult {
+	log.Printf("Running step %v of %v", step.Name, alloc.Name)
+	runner.store.Log(alloc, "running step", step.Name)
+
+	// the step's own host config, plus the workspace
+	options := step.Container
+	hostConfig := docker.HostConfig{}
+	if options.HostConfig != nil {
+		hostConfig = *options.HostConfig
+	}
+	hostConfig.Binds = append(append([]string{}, hostConfig.Binds...), volume+":"+alloc.Workspace)
+	options.HostConfig = &hostConfig
+
+	stepRun := &allocations.Run{StartedAt: time.Now(), FencingToken: run.FencingToken}
+	runner.executeContainer(alloc, options, stepRun)
+	return &allocations.StepResult{
+		Name:        step.Name,
+		StartedAt:   stepRun.StartedAt,
+		FinishedAt:  time.Now(),
+		ContainerID: stepRun.ContainerID,
+		Status:      stepRun.Status,
+		ExitCode:    stepRun.ExitCode,
+		Output:      stepRun.Output,
+		Error:       stepRun.Error,
+	}
+}
+
+// remove the steps' containers, which would otherwise keep the
+// volume in use, and then the volume
+func (runner *FsouzaAllocationRunner) removeWorkspace(alloc *allocations.Allocation, volume string, run *allocations.Run) {
+	for _, step := range run.Steps {
+		if step.ContainerID == "" {
+			continue
+		}
+		err := runner.client.RemoveContainer(docker.RemoveContainerOptions{ID: step.ContainerID, Force: true})
+		if err != nil {
+			log.Printf("Failed to remove container %v for step %v of %v, error was %v", step.ContainerID, step.Name, alloc.Name, err)
+		}
+	}
+
+	err := runner.client.RemoveVolumeWithOptions(docker.RemoveVolumeOptions{Name: volume, Force: true})
+	if err != nil {
+		log.Printf("Failed to remove workspace %v for %v, error was %v", volume, alloc.Name, err)
+		runner.store.Log(alloc, "failed to remove workspace", volume, err)
+		return
+	}
+	runner.store.Log(alloc, "removed workspace", volume)
+}
+
+// volume names are unique per run. Allocation names can contain
+// characters docker doesn't allow, so they only go in the labels
+func workspaceName(run *allocations.Run) string {
+	return "docket-workspace-" + run.ID
+}
@@ -0,0 +1,75 @@
+// Package seal encrypts small blobs of data at rest, e.g. registry credentials
+// and secrets, with AES-256-GCM under a key that only the server knows
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+type Key [32]byte
+
+// Read a key from a file. The file can hold any passphrase or random
+// bytes, it's hashed down to a 256 bit key, so generating one with
+// `head -c 32 /dev/urandom | base64 > docket.key` is plenty
+func KeyFromFile(path string) (*Key, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := strings.TrimSpace(string(raw))
+	if trimmed == "" {
+		return nil, errors.New("key file " + path + " is empty")
+	}
+
+	key := Key(sha256.Sum256([]byte(trimmed)))
+	return &key, nil
+}
+
+// Encrypt plaintext, the nonce is prepended to the result
+func Seal(key *Key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt data produced by Seal, failing if it was tampered
+// with or sealed under a different key
+func Open(key *Key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("unable to decrypt, wrong key or corrupt data")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key *Key) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
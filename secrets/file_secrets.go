@@ -0,0 +1,116 @@
+package secrets
+
+import (
+	"encoding/json"
+	"github.com/horthy/docket/seal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File creates a secret store persisted to path, encrypted with key.
+// The whole store is re-sealed and rewritten on every change, which is
+// fine for the handful of secrets a docket server deals with
+func File(path string, key *seal.Key) (*FileSecrets, error) {
+	store := &FileSecrets{
+		InMemorySecrets: InMemory(),
+		path:            path,
+		key:             key,
+	}
+
+	sealed, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := seal.Open(key, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(plaintext, &store.secrets)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+type FileSecrets struct {
+	*InMemorySecrets
+	path string
+	key  *seal.Key
+}
+
+// Set and Delete hold the write lock while the store is saved, so
+// concurrent changes are written in order, and undo the change in
+// memory if it couldn't be saved, so nothing is served that would be
+// lost on restart
+func (s *FileSecrets) Set(name string, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	previous, existed := s.secrets[name]
+	s.secrets[name] = secret{Value: value, Updated: time.Now()}
+	err := s.save()
+	if err != nil {
+		s.restore(name, previous, existed)
+	}
+	return err
+}
+
+func (s *FileSecrets) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	previous, existed := s.secrets[name]
+	if !existed {
+		return &NotFoundError{Name: name}
+	}
+	delete(s.secrets, name)
+	err := s.save()
+	if err != nil {
+		s.restore(name, previous, existed)
+	}
+	return err
+}
+
+// put a secret back as it was before a change. must be called while locked
+func (s *FileSecrets) restore(name string, previous secret, existed bool) {
+	if existed {
+		s.secrets[name] = previous
+	} else {
+		delete(s.secrets, name)
+	}
+}
+
+// write to a temp file and rename it into place,
+// so a crash mid-write can't leave a corrupt store.
+// must be called while locked
+func (s *FileSecrets) save() error {
+	plaintext, err := json.Marshal(s.secrets)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := seal.Seal(s.key, plaintext)
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(s.path), ".secrets")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(sealed)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), s.path)
+}
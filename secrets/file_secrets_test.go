@@ -0,0 +1,85 @@
+package secrets
+
+import (
+	"bytes"
+	"github.com/horthy/docket/seal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSecrets(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "docket-secrets")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets")
+	key := &seal.Key{1, 2, 3}
+
+	store, err := File(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("db-password", "hunter2")
+	store.Set("api-token", "abc123")
+	store.Delete("api-token")
+
+	raw, _ := ioutil.ReadFile(path)
+	if bytes.Contains(raw, []byte("hunter2")) || bytes.Contains(raw, []byte("db-password")) {
+		t.Error("expected secrets file to be encrypted")
+	}
+
+	reopened, err := File(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := reopened.Get("db-password")
+	if value != "hunter2" {
+		t.Errorf("expected db-password to survive a reopen but got %v, %v", value, err)
+	}
+
+	list, _ := reopened.List()
+	if len(list) != 1 || list[0].Name != "db-password" {
+		t.Errorf("expected only db-password to be listed but got %v", list)
+	}
+
+	_, err = File(path, &seal.Key{4, 5, 6})
+	if err == nil {
+		t.Error("expected opening with the wrong key to fail")
+	}
+}
+
+func TestFileSecretsRollback(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "docket-secrets")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets")
+	key := &seal.Key{1, 2, 3}
+
+	store, err := File(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("db-password", "hunter2")
+
+	// nowhere left to save to
+	os.RemoveAll(dir)
+	if err := store.Set("db-password", "changed"); err == nil {
+		t.Fatal("expected set to fail when the store can't be saved")
+	}
+	if err := store.Set("api-token", "abc123"); err == nil {
+		t.Fatal("expected set to fail when the store can't be saved")
+	}
+	if err := store.Delete("db-password"); err == nil {
+		t.Fatal("expected delete to fail when the store can't be saved")
+	}
+
+	if value, _ := store.Get("db-password"); value != "hunter2" {
+		t.Errorf("expected failed changes to db-password to be undone but got %v", value)
+	}
+	if _, err := store.Get("api-token"); err == nil {
+		t.Error("expected a secret that couldn't be saved not to be set")
+	}
+	if _, ok := store.Delete("api-token").(*NotFoundError); !ok {
+		t.Error("expected deleting a secret that isn't set to be a *NotFoundError")
+	}
+}
@@ -0,0 +1,98 @@
+// Package secrets stores values, like database passwords, that allocations
+// reference by name. Values are only read by the runner when it creates a
+// container, and are never returned by the API
+package secrets
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// What the API is allowed to say about a secret
+type Info struct {
+	Name    string    `json:"Name"`
+	Updated time.Time `json:"Updated"`
+}
+
+type Store interface {
+	// Create or overwrite a secret
+	Set(name string, value string) error
+
+	// Get a secret's value, will return a *NotFoundError if it can't be found
+	Get(name string) (string, error)
+
+	// List the names of all secrets, without their values
+	List() ([]Info, error)
+
+	// Delete a secret, will return a *NotFoundError if it can't be found
+	Delete(name string) error
+}
+
+// Returned when there's no secret with the name
+type NotFoundError struct {
+	Name string
+}
+
+func (err *NotFoundError) Error() string {
+	return fmt.Sprintf("Secret with name %v not found", err.Name)
+}
+
+type secret struct {
+	Value   string    `json:"Value"`
+	Updated time.Time `json:"Updated"`
+}
+
+// InMemory creates a secret store that keeps values in memory
+// only, so nothing is written to disk and everything is lost
+// when the server stops
+func InMemory() *InMemorySecrets {
+	return &InMemorySecrets{
+		secrets: map[string]secret{},
+		mutex:   &sync.RWMutex{},
+	}
+}
+
+type InMemorySecrets struct {
+	secrets map[string]secret
+	mutex   *sync.RWMutex
+}
+
+func (s *InMemorySecrets) Set(name string, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.secrets[name] = secret{Value: value, Updated: time.Now()}
+	return nil
+}
+
+func (s *InMemorySecrets) Get(name string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	found, ok := s.secrets[name]
+	if !ok {
+		return "", &NotFoundError{Name: name}
+	}
+	return found.Value, nil
+}
+
+func (s *InMemorySecrets) List() ([]Info, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	infos := []Info{}
+	for name, found := range s.secrets {
+		infos = append(infos, Info{Name: name, Updated: found.Updated})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+func (s *InMemorySecrets) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.secrets[name]; !ok {
+		return &NotFoundError{Name: name}
+	}
+	delete(s.secrets, name)
+	return nil
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"errors"
+	"github.com/codegangsta/martini"
+	"github.com/codegangsta/martini-contrib/render"
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/lease"
+	"github.com/horthy/docket/run"
+	"log"
+	"net/http"
+)
+
+// The allocations a bulk request applies to: the one named in the url,
+// or every allocation in the namespace matching the selector query parameter.
+// An empty selector is refused so a typo can't pause or delete everything
+func selected(allocationStore allocations.AllocationStore, params martini.Params, req *http.Request) (allocations.Allocations, error) {
+	if name, ok := params["name"]; ok {
+		allocation, err := allocationStore.Get(namespace(params), name)
+		if err != nil {
+			return nil, err
+		}
+		return allocations.Allocations{allocation}, nil
+	}
+
+	selector, err := allocations.ParseSelector(req.URL.Query().Get("selector"))
+	if err != nil {
+		return nil, err
+	}
+	if selector.Empty() {
+		return nil, errors.New("a selector is required, e.g. ?selector=team=data")
+	}
+	return allocationStore.List(namespace(params), selector)
+}
+
+func names(selected allocations.Allocations) []string {
+	names := []string{}
+	for _, allocation := range selected {
+		names = append(names, allocation.Name)
+	}
+	return names
+}
+
+func handleDeleteSelected(allocationStore allocations.AllocationStore, r render.Render, params martini.Params, req *http.Request) {
+	targets, err := selected(allocationStore, params, req)
+	if err != nil {
+		r.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	for _, allocation := range targets {
+		err := allocationStore.Delete(allocation.Namespace, allocation.Name)
+		if err != nil {
+			r.JSON(500, err)
+			return
+		}
+	}
+	r.JSON(200, map[string][]string{"deleted": names(targets)})
+}
+
+// pause, resume or trigger the selected allocations
+func handleAction(
+	allocationStore allocations.AllocationStore,
+	queue *run.Queue,
+	elector *lease.Elector,
+	r render.Render,
+	params martini.Params,
+	req *http.Request,
+) {
+	action := params["action"]
+	// e.g. {"paused": ["foo", "bar"]}
+	past, ok := map[string]string{"pause": "paused", "resume": "resumed", "trigger": "triggered"}[action]
+	if !ok {
+		r.JSON(404, map[string]string{"error": "unknown action " + action})
+		return
+	}
+
+	targets, err := selected(allocationStore, params, req)
+	if err != nil {
+		r.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// e.g. {"triggered": ["foo"], "skipped": ["bar"]} when bar already had a run queued
+	result := map[string][]string{past: {}}
+	for _, allocation := range targets {
+		switch action {
+		case "pause", "resume":
+			err = allocationStore.SetPaused(allocation.Namespace, allocation.Name, action == "pause")
+			if err != nil {
+				r.JSON(500, err)
+				return
+			}
+			allocationStore.Log(allocation, past)
+		case "trigger":
+			log.Printf("Triggering run of %v/%v", allocation.Namespace, allocation.Name)
+			allocationStore.Log(allocation, past)
+			// manual runs are fenced if this server is scheduling
+			err = queue.Enqueue(allocation, nil, elector.Token())
+			if _, named := params["name"]; named && err != nil {
+				r.JSON(writeError(err))
+				return
+			}
+			if err != nil {
+				result["skipped"] = append(result["skipped"], allocation.Name)
+				continue
+			}
+		}
+		result[past] = append(result[past], allocation.Name)
+	}
+
+	r.JSON(200, result)
+}
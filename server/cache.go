@@ -0,0 +1,124 @@
+package server
+
+import (
+	"github.com/horthy/docket/allocations"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An AllocationStore whose List is answered from a copy of the store
+// kept up to date by watching it, so the scheduler sees changes as
+// they happen without re-listing the whole store every tick. Like
+// watch events, the cached allocations don't carry their runs and logs.
+// Everything else goes straight to the store
+type WatchCache struct {
+	allocations.AllocationStore
+	mutex  sync.RWMutex
+	cached map[string]*allocations.Allocation
+	synced bool
+	// called outside the lock, so it can use the cache
+	onChange func(alloc *allocations.Allocation, deleted bool)
+}
+
+// Start watching store. If it can't be watched the
+// cache just passes List through to the store
+func NewWatchCache(store allocations.AllocationStore) *WatchCache {
+	cache := &WatchCache{
+		AllocationStore: store,
+		cached:          map[string]*allocations.Allocation{},
+	}
+	if watcher, ok := store.(allocations.Watcher); ok {
+		go cache.watch(watcher)
+	} else {
+		log.Printf("Allocation store can't be watched, scheduler will list it every tick")
+	}
+	return cache
+}
+
+func (cache *WatchCache) watch(watcher allocations.Watcher) {
+	var since uint64
+	for {
+		events, cancel, err := watcher.Watch(since)
+		if err == allocations.ErrWatchExpired {
+			since = 0
+			continue
+		}
+		if err != nil {
+			log.Printf("Couldn't watch allocations, error was %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		if since == 0 {
+			// starting over, the watch begins with every current allocation
+			cache.mutex.Lock()
+			cache.cached = map[string]*allocations.Allocation{}
+			cache.synced = true
+			cache.mutex.Unlock()
+		}
+
+		for event := range events {
+			cache.apply(event)
+			since = event.ResourceVersion
+		}
+		// the channel only closes if we fell behind, resume from where we were
+		cancel()
+		log.Printf("Allocation watch closed at version %v, resuming", since)
+	}
+}
+
+// Call changed with each allocation that's created, deleted, or has
+// its specification or pause changed, but not for its runs and logs.
+// Changes the cache saw before it was set aren't reported
+func (cache *WatchCache) OnChange(changed func(alloc *allocations.Allocation, deleted bool)) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.onChange = changed
+}
+
+func (cache *WatchCache) apply(event allocations.WatchEvent) {
+	cache.mutex.Lock()
+	key := event.Allocation.Namespace + "/" + event.Allocation.Name
+	previous := cache.cached[key]
+	deleted := event.Type == allocations.Deleted
+	if deleted {
+		delete(cache.cached, key)
+	} else {
+		cache.cached[key] = event.Allocation
+	}
+	onChange := cache.onChange
+	cache.mutex.Unlock()
+
+	changed := deleted || previous == nil || previous.ResourceVersion != event.Allocation.ResourceVersion
+	if onChange != nil && changed {
+		onChange(event.Allocation, deleted)
+	}
+}
+
+func (cache *WatchCache) List(namespace string, selector allocations.Selector) (allocations.Allocations, error) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	if !cache.synced {
+		return cache.AllocationStore.List(namespace, selector)
+	}
+
+	keys := []string{}
+	for key := range cache.cached {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	matching := allocations.Allocations{}
+	for _, key := range keys {
+		allocation := cache.cached[key]
+		if namespace != allocations.AllNamespaces && allocation.Namespace != namespace {
+			continue
+		}
+		if selector.Matches(allocation.Labels) {
+			matching = append(matching, allocation)
+		}
+	}
+	return matching, nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/horthy/docket/allocations"
+	"log"
+	"time"
+)
+
+// Periodically prunes the run history and logs of every
+// allocation, so a frequent job doesn't grow the store forever,
+// and deletes At allocations that have passed their ExpireAfter
+type Compactor struct {
+	store    allocations.AllocationStore
+	defaults allocations.Retention
+}
+
+func NewCompactor(store allocations.AllocationStore, defaults allocations.Retention) *Compactor {
+	return &Compactor{
+		store:    store,
+		defaults: defaults,
+	}
+}
+
+func (compactor *Compactor) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			compactor.Compact(time.Now())
+		}
+	}()
+}
+
+func (compactor *Compactor) Compact(now time.Time) {
+	removed, err := compactor.store.Compact(compactor.defaults, now)
+	if err != nil {
+		log.Printf("Couldn't compact allocation history, error was %v", err)
+		return
+	}
+	if removed.Runs > 0 || removed.Logs > 0 {
+		log.Printf("Compacted allocation history, removed %v runs and %v log lines", removed.Runs, removed.Logs)
+	}
+
+	compactor.expire(now)
+}
+
+func (compactor *Compactor) expire(now time.Time) {
+	list, err := compactor.store.List(allocations.AllNamespaces, allocations.Everything())
+	if err != nil {
+		log.Printf("Couldn't check for expired allocations, error was %v", err)
+		return
+	}
+	for _, allocation := range list {
+		if !allocation.Expired(now) {
+			continue
+		}
+		err := compactor.store.Delete(allocation.Namespace, allocation.Name)
+		if err != nil {
+			log.Printf("Couldn't delete expired allocation %v/%v, error was %v", allocation.Namespace, allocation.Name, err)
+			continue
+		}
+		log.Printf("Deleted %v/%v, it completed at %v and expires after %v", allocation.Namespace, allocation.Name, allocation.CompletedAt(), allocation.ExpireAfter)
+	}
+}
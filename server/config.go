@@ -0,0 +1,62 @@
+package server
+
+import (
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/calendar"
+	"github.com/horthy/docket/notify"
+	"github.com/horthy/docket/run"
+	"time"
+)
+
+// Server-wide settings, populated from flags
+// and the docket config file by `docket server`
+type Config struct {
+	// Shared secret used to sign outbound webhook payloads.
+	// Payloads are sent unsigned if this is empty
+	WebhookSecret string
+
+	// Relay used for email notifications, disabled if SMTP.Host is empty
+	SMTP notify.SMTPConfig
+
+	// File holding the key used to decrypt sealed files
+	KeyFile string
+
+	// Optional docker config.json to load registry credentials from
+	DockerConfig string
+
+	// Optional registry credentials file encrypted with `docket seal`
+	RegistryCredentials string
+
+	// Where to keep secrets, encrypted with the key in KeyFile.
+	// If empty secrets are only kept in memory
+	SecretsFile string
+
+	// Per-namespace limits on allocations and concurrent runs
+	Namespaces Quotas
+
+	// How much run history and logs to keep for allocations
+	// that don't set their own Retention
+	Retention allocations.Retention
+
+	// How often to prune history according to Retention
+	CompactInterval time.Duration
+
+	// File to keep allocations in, which several servers can share.
+	// The lease that decides which of them runs the scheduler, and
+	// the fencing tokens that keep out the ones it replaced, are kept
+	// in it too. If empty allocations are only kept in memory, and
+	// this server assumes it's the only one and always schedules
+	StoreFile string
+
+	// How long the scheduler lease lasts without being renewed
+	LeaseTTL time.Duration
+
+	// This server's name in the lease, unique among the servers
+	ServerID string
+
+	// Size of the run queue's worker pool and limits on concurrent runs
+	Queue run.Limits
+
+	// Named blackout calendars allocations can refer to
+	Calendars map[string]calendar.Spec
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/run"
+	"github.com/horthy/docket/secrets"
+)
+
+// The status and body to answer a failed write to the store with.
+// Errors the client can do something about get their own status,
+// and a trigger cycle is a 422 on Trigger like any invalid field,
+// though only the store can find one that goes through other allocations
+func writeError(err error) (int, interface{}) {
+	switch typed := err.(type) {
+	case *allocations.CycleError:
+		return 422, map[string]string{"Trigger": typed.Error()}
+	case *allocations.PassedAtError:
+		return 422, map[string]string{"At": typed.Error()}
+	case *allocations.ConflictError:
+		return 409, map[string]interface{}{"error": typed.Error(), "ResourceVersion": typed.Actual}
+	case *allocations.QuotaError:
+		return 403, map[string]string{"error": typed.Error()}
+	case *allocations.NotFoundError:
+		return 404, map[string]string{"error": typed.Error()}
+	case *secrets.NotFoundError:
+		return 404, map[string]string{"error": typed.Error()}
+	case *run.AlreadyQueuedError:
+		return 409, map[string]string{"error": typed.Error()}
+	}
+	return 500, map[string]string{"error": err.Error()}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/run"
+	"github.com/horthy/docket/secrets"
+	"strings"
+	"testing"
+)
+
+func TestWriteErrorCycle(t *testing.T) {
+	store := allocations.InMemory()
+	spec := func(name string, after string) *allocations.AllocationSpecification {
+		return &allocations.AllocationSpecification{
+			Name:    name,
+			Trigger: &allocations.Trigger{After: []string{after}},
+			Container: allocations.CreateContainerOptions{
+				Config: &docker.Config{Image: "busybox:latest"},
+			},
+		}
+	}
+	store.CreateOrUpdate(spec("transform", "extract"), allocations.Change{})
+	store.CreateOrUpdate(spec("load", "transform"), allocations.Change{})
+
+	// each is valid on its own, the loop is only found against the store
+	extract := spec("extract", "load")
+	if problems := extract.Check(); len(problems) != 0 {
+		t.Fatalf("expected extract to be valid on its own but got %v", problems)
+	}
+	_, _, err := store.CreateOrUpdate(extract, allocations.Change{})
+	status, body := writeError(err)
+	if status != 422 {
+		t.Fatalf("expected a trigger cycle to be a 422 but got %v for %v", status, err)
+	}
+	problems, ok := body.(map[string]string)
+	if !ok || !strings.Contains(problems["Trigger"], "extract -> transform -> load -> extract") {
+		t.Errorf("expected the cycle to be reported on Trigger but got %v", body)
+	}
+}
+
+func TestWriteErrorStatuses(t *testing.T) {
+	for err, expected := range map[error]int{
+		&allocations.ConflictError{Expected: 1, Actual: 2}: 409,
+		&allocations.QuotaError{MaxAllocations: 1}:         403,
+		&allocations.NotFoundError{Name: "foo"}:            404,
+		&run.AlreadyQueuedError{Name: "foo"}:               409,
+		&allocations.PassedAtError{Name: "foo"}:            422,
+		&secrets.NotFoundError{Name: "foo"}:                404,
+	} {
+		if status, _ := writeError(err); status != expected {
+			t.Errorf("expected %v for %v but got %v", expected, err, status)
+		}
+	}
+}
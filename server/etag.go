@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The ETag for an allocation is its quoted ResourceVersion
+func etag(version uint64) string {
+	return fmt.Sprintf("%q", strconv.FormatUint(version, 10))
+}
+
+// The ResourceVersion a request's If-Match header requires, 0 if it
+// doesn't have one. "*" is treated as no precondition
+func ifMatch(req *http.Request) (uint64, error) {
+	header := strings.TrimSpace(req.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	tag := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	version, err := strconv.ParseUint(tag, 10, 64)
+	if err != nil || version == 0 {
+		return 0, fmt.Errorf("If-Match must be a single ETag from a previous response, got %v", header)
+	}
+	return version, nil
+}
+
+// Whether a request's If-None-Match header asks for the write to
+// only create the allocation. Only "*" makes sense for a write
+func ifNoneMatch(req *http.Request) (bool, error) {
+	header := strings.TrimSpace(req.Header.Get("If-None-Match"))
+	if header == "" {
+		return false, nil
+	}
+	if header != "*" {
+		return false, fmt.Errorf("If-None-Match must be *, got %v", header)
+	}
+	return true, nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"github.com/codegangsta/martini-contrib/render"
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/archive"
+	"github.com/horthy/docket/calendar"
+	"github.com/horthy/docket/registry"
+	"github.com/horthy/docket/secrets"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// The settings written to exports. Secret values, the
+// webhook secret and the SMTP password are left out
+type exportedSettings struct {
+	Namespaces          Quotas
+	Retention           allocations.Retention
+	Secrets             []string
+	RegistryCredentials []string
+	SMTPHost            string `json:",omitempty"`
+	SMTPFrom            string `json:",omitempty"`
+}
+
+func handleExport(
+	allocationStore allocations.AllocationStore,
+	secretStore secrets.Store,
+	credentials *registry.Credentials,
+	config Config,
+	w http.ResponseWriter,
+	r render.Render,
+) {
+	settings := exportedSettings{
+		Namespaces:          config.Namespaces,
+		Retention:           config.Retention,
+		Secrets:             []string{},
+		RegistryCredentials: credentials.Names(),
+		SMTPHost:            config.SMTP.Host,
+		SMTPFrom:            config.SMTP.From,
+	}
+	list, err := secretStore.List()
+	if err != nil {
+		r.JSON(500, map[string]string{"error": err.Error()})
+		return
+	}
+	for _, info := range list {
+		settings.Secrets = append(settings.Secrets, info.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=docket-%v.tar.gz", time.Now().Format("20060102-150405")))
+	// headers are already sent by the time anything can fail,
+	// so all we can do is cut the archive short
+	err = archive.Write(w, allocationStore, settings)
+	if err != nil {
+		log.Printf("Export failed, error was %v", err)
+	}
+}
+
+// Restore an archive from the request body. ?mode=replace
+// deletes allocations that aren't in the archive, the
+// default ?mode=merge leaves them alone
+func handleImport(
+	allocationStore allocations.AllocationStore,
+	secretStore secrets.Store,
+	credentials *registry.Credentials,
+	calendars calendar.Calendars,
+	req *http.Request,
+	r render.Render,
+) {
+	mode := archive.Mode(req.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = archive.Merge
+	}
+
+	imported, err := archive.Read(req.Body)
+	if err != nil {
+		r.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	result, err := imported.Restore(allocationStore, mode)
+	if err != nil {
+		r.JSON(422, map[string]interface{}{"error": err.Error(), "Result": result})
+		return
+	}
+	log.Printf("Imported %v allocations in %v mode, deleted %v", len(result.Imported), mode, len(result.Deleted))
+
+	r.JSON(200, importResponse{
+		Result:                     *result,
+		MissingSecrets:             missingSecrets(imported, secretStore),
+		MissingRegistryCredentials: missingCredentials(imported, credentials),
+		MissingCalendars:           missingCalendars(imported, calendars),
+	})
+}
+
+// The imported allocations may need things that aren't
+// in archives, tell the caller which ones to set up
+type importResponse struct {
+	archive.Result
+	MissingSecrets             []string `json:"MissingSecrets,omitempty"`
+	MissingRegistryCredentials []string `json:"MissingRegistryCredentials,omitempty"`
+	MissingCalendars           []string `json:"MissingCalendars,omitempty"`
+}
+
+func missingSecrets(imported *archive.Archive, secretStore secrets.Store) []string {
+	missing := map[string]bool{}
+	for _, record := range imported.Records {
+		for _, reference := range record.Allocation.Secrets {
+			if _, err := secretStore.Get(reference.Name); err != nil {
+				missing[reference.Name] = true
+			}
+		}
+	}
+	return sortedKeys(missing)
+}
+
+func missingCredentials(imported *archive.Archive, credentials *registry.Credentials) []string {
+	missing := map[string]bool{}
+	for _, record := range imported.Records {
+		name := record.Allocation.RegistryCredential
+		if name != "" && !credentials.Has(name) {
+			missing[name] = true
+		}
+	}
+	return sortedKeys(missing)
+}
+
+func missingCalendars(imported *archive.Archive, calendars calendar.Calendars) []string {
+	missing := map[string]bool{}
+	for _, record := range imported.Records {
+		for _, name := range record.Allocation.Blackouts {
+			if !calendars.Has(name) {
+				missing[name] = true
+			}
+		}
+	}
+	return sortedKeys(missing)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
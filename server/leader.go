@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/lease"
+	"log"
+	"os"
+	"time"
+)
+
+const defaultLeaseTTL = 15 * time.Second
+
+// A lease that's always held, for a server that doesn't share its store
+type soleLease struct{}
+
+func (soleLease) TryAcquire(holder string, ttl time.Duration) (uint64, bool, error) {
+	return 0, true, nil
+}
+
+func (soleLease) Release(holder string) error {
+	return nil
+}
+
+// Start competing for the scheduler lease in scheduling, which
+// is the store when it's shared with other servers
+func startElector(config Config, scheduling lease.Lease) *lease.Elector {
+	if config.LeaseTTL <= 0 {
+		config.LeaseTTL = defaultLeaseTTL
+	}
+	if config.ServerID == "" {
+		hostname, _ := os.Hostname()
+		config.ServerID = fmt.Sprintf("%v-%v", hostname, os.Getpid())
+	}
+
+	if config.StoreFile != "" {
+		log.Printf("%v competing for the scheduler lease in %v", config.ServerID, config.StoreFile)
+	}
+
+	elector := lease.NewElector(scheduling, config.ServerID, config.LeaseTTL)
+	elector.Start()
+	return elector
+}
+
+// Whether this server should run the scheduler this tick, and the
+// token to run what it schedules with. The leader claims the store
+// with its token first, so a server that has been replaced finds out
+// here instead of starting containers
+func schedulerLease(elector *lease.Elector, store allocations.AllocationStore) (uint64, bool) {
+	token, leading := elector.Term()
+	if !leading {
+		return 0, false
+	}
+	err := store.Fence(token)
+	if err != nil {
+		log.Printf("Not scheduling, error was %v", err)
+		return 0, false
+	}
+	return token, true
+}
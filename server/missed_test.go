@@ -0,0 +1,46 @@
+package server
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/calendar"
+	"github.com/horthy/docket/notify"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingNotifier struct {
+	events []notify.Event
+}
+
+func (n *recordingNotifier) Notify(alloc *allocations.Allocation, event notify.Event) {
+	n.events = append(n.events, event)
+}
+
+func TestReportMissedRunsCountsEveryMissedRun(t *testing.T) {
+	store := allocations.InMemory()
+	_, _, err := store.CreateOrUpdate(&allocations.AllocationSpecification{
+		Name: "every-minute",
+		Cron: "* * * * *",
+		Container: allocations.CreateContainerOptions{
+			Config: &docker.Config{Image: "busybox:latest"},
+		},
+	}, allocations.Change{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := &recordingNotifier{}
+	// half past the next minute. the two checks after it never
+	// happened, so the runs in the two minutes after them didn't start
+	lastCheck := time.Now().Truncate(time.Minute).Add(90 * time.Second)
+	ReportMissedRuns(notifier, store, calendar.Calendars{}, lastCheck, lastCheck.Add(3*time.Minute))
+
+	if len(notifier.events) != 1 {
+		t.Fatalf("expected one missed event but got %v", notifier.events)
+	}
+	if message := notifier.events[0].Message; !strings.Contains(message, "missed 2 runs") {
+		t.Errorf("expected both missed runs to be counted but got %v", message)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"fmt"
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/notify"
+	"log"
+	"time"
+)
+
+// Watches for allocations that haven't succeeded within their ExpectSuccessWithin.
+// It runs on its own ticker, separate from the scheduler, so that it still
+// raises the alarm if the scheduler goroutine stops running things at all
+type OverdueMonitor struct {
+	store    allocations.AllocationStore
+	notifier notify.Notifier
+	// the deadline each overdue allocation has already been alerted for,
+	// so we alert once per missed deadline rather than on every check
+	alerted map[string]time.Time
+	// whether this server should be alerting, so only the
+	// scheduling server of several sends notifications
+	active func() bool
+}
+
+func NewOverdueMonitor(store allocations.AllocationStore, notifier notify.Notifier) *OverdueMonitor {
+	return &OverdueMonitor{
+		store:    store,
+		notifier: notifier,
+		alerted:  map[string]time.Time{},
+		active:   func() bool { return true },
+	}
+}
+
+// Only check while active returns true, e.g. while this server holds the scheduler lease
+func (monitor *OverdueMonitor) OnlyWhile(active func() bool) *OverdueMonitor {
+	monitor.active = active
+	return monitor
+}
+
+func (monitor *OverdueMonitor) Start(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if monitor.active() {
+				monitor.Check(time.Now())
+			}
+		}
+	}()
+}
+
+func (monitor *OverdueMonitor) Check(now time.Time) {
+	overdue, err := Overdue(monitor.store, now)
+	if err != nil {
+		log.Printf("Couldn't check for overdue allocations, error was %v", err)
+		return
+	}
+
+	stillOverdue := map[string]time.Time{}
+	for _, status := range overdue {
+		key := status.Namespace + "/" + status.Name
+		stillOverdue[key] = status.OverdueSince
+		if monitor.alerted[key].Equal(status.OverdueSince) {
+			continue
+		}
+
+		log.Printf("Allocation %v is overdue, expected a success by %v", key, status.OverdueSince)
+		monitor.store.Log(status.allocation, "Overdue, expected a successful run by", status.OverdueSince)
+		monitor.notifier.Notify(status.allocation, notify.Event{
+			Type:       allocations.EventOverdue,
+			Namespace:  status.Namespace,
+			Allocation: status.Name,
+			Message: fmt.Sprintf(
+				"no successful run within %v, last success %v",
+				status.ExpectSuccessWithin,
+				describeLastSuccess(status.LastSuccess),
+			),
+			Time: now,
+		})
+	}
+	monitor.alerted = stillOverdue
+}
+
+func describeLastSuccess(lastSuccess *time.Time) string {
+	if lastSuccess == nil {
+		return "never"
+	}
+	return lastSuccess.String()
+}
+
+// An allocation that is overdue for a successful run, as returned by GET /overdue
+type OverdueStatus struct {
+	Namespace           string     `json:"Namespace"`
+	Name                string     `json:"Name"`
+	ExpectSuccessWithin string     `json:"ExpectSuccessWithin"`
+	LastSuccess         *time.Time `json:"LastSuccess"`
+	OverdueSince        time.Time  `json:"OverdueSince"`
+
+	allocation *allocations.Allocation
+}
+
+func Overdue(store allocations.AllocationStore, now time.Time) ([]OverdueStatus, error) {
+	allAllocations, err := store.List(allocations.AllNamespaces, allocations.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	overdue := []OverdueStatus{}
+	for _, alloc := range allAllocations {
+		since := alloc.OverdueSince(now)
+		if since.IsZero() || alloc.Paused || !alloc.ActiveAt(now) {
+			continue
+		}
+
+		status := OverdueStatus{
+			Namespace:           alloc.Namespace,
+			Name:                alloc.Name,
+			ExpectSuccessWithin: alloc.ExpectSuccessWithin.String(),
+			OverdueSince:        since,
+			allocation:          alloc,
+		}
+		if success := alloc.LastSuccess(); success != nil {
+			status.LastSuccess = &success.FinishedAt
+		}
+		overdue = append(overdue, status)
+	}
+	return overdue, nil
+}
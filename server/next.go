@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/codegangsta/martini"
+	"github.com/codegangsta/martini-contrib/render"
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/calendar"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the most runs a preview will show for each allocation
+const maxPreview = 100
+
+// Preview the next ?count= runs (default 5) of the allocation
+// named by ?name=, or of those matching ?selector=
+func handleGetNext(allocationStore allocations.AllocationStore, calendars calendar.Calendars, r render.Render, params martini.Params, req *http.Request) {
+	query := req.URL.Query()
+	count := 5
+	if raw := query.Get("count"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxPreview {
+			r.JSON(400, map[string]string{"count": "count must be a number from 1 to 100"})
+			return
+		}
+		count = parsed
+	}
+
+	var list allocations.Allocations
+	if name := query.Get("name"); name != "" {
+		allocation, err := allocationStore.Get(namespace(params), name)
+		if err != nil {
+			r.JSON(404, map[string]string{"error": err.Error()})
+			return
+		}
+		list = allocations.Allocations{allocation}
+	} else {
+		selector, err := allocations.ParseSelector(query.Get("selector"))
+		if err != nil {
+			r.JSON(400, map[string]string{"selector": err.Error()})
+			return
+		}
+		list, err = allocationStore.List(namespace(params), selector)
+		if err != nil {
+			r.JSON(500, err)
+			return
+		}
+	}
+
+	now := time.Now()
+	previews := []allocations.Preview{}
+	for _, allocation := range list {
+		preview := allocation.Preview(now, count)
+		for i, planned := range preview.Runs {
+			if reason, blackedOut := calendars.Blackout(allocation.Blackouts, planned.Scheduled); blackedOut {
+				preview.Runs[i].Skipped = reason
+			}
+		}
+		previews = append(previews, preview)
+	}
+	r.JSON(200, previews)
+}
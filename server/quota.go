@@ -0,0 +1,35 @@
+package server
+
+import (
+	"github.com/horthy/docket/allocations"
+)
+
+// Limits for a single namespace, zero means unlimited
+type Quota struct {
+	MaxAllocations    int
+	MaxConcurrentRuns int
+}
+
+// Quotas by namespace. Namespaces without an entry are unlimited
+type Quotas map[string]Quota
+
+// The change a write to the namespace is made as, which the store
+// refuses if it would create an allocation over MaxAllocations. The
+// store checks while it holds its lock, so concurrent creates can't
+// both slip under the limit
+func (quotas Quotas) Change(namespace string, change allocations.Change) allocations.Change {
+	change.MaxAllocations = quotas[namespace].MaxAllocations
+	return change
+}
+
+// Each namespace's MaxConcurrentRuns, as the queue's PerNamespace
+// limits, so runs over the limit wait in the queue
+func (quotas Quotas) ConcurrentRuns() map[string]int {
+	limits := map[string]int{}
+	for namespace, quota := range quotas {
+		if quota.MaxConcurrentRuns > 0 {
+			limits[namespace] = quota.MaxConcurrentRuns
+		}
+	}
+	return limits
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"github.com/codegangsta/martini"
+	"github.com/codegangsta/martini-contrib/render"
+	"github.com/horthy/docket/allocations"
+	"log"
+	"net/http"
+)
+
+// Who a change should be attributed to. The CLI sends the
+// local user's name, anything else shows up as unknown
+func author(req *http.Request) string {
+	if name := req.Header.Get("X-Docket-Author"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+type rollbackRequest struct {
+	To int `json:"To" binding:"required"`
+}
+
+func handleGetRevisions(allocationStore allocations.AllocationStore, r render.Render, params martini.Params) {
+	revisions, err := allocationStore.Revisions(namespace(params), params["name"])
+	if err != nil {
+		r.JSON(404, map[string]string{"error": err.Error()})
+	} else {
+		r.JSON(200, revisions)
+	}
+}
+
+// Restore the spec of an earlier revision. The rollback
+// is itself recorded as a new revision
+func handleRollback(
+	rollback rollbackRequest,
+	allocationStore allocations.AllocationStore,
+	r render.Render,
+	params martini.Params,
+	req *http.Request,
+) {
+	ns, name := namespace(params), params["name"]
+	revisions, err := allocationStore.Revisions(ns, name)
+	if err != nil {
+		r.JSON(404, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var target *allocations.Revision
+	for _, revision := range revisions {
+		if revision.Number == rollback.To {
+			target = revision
+		}
+	}
+	if target == nil {
+		r.JSON(404, map[string]string{"error": fmt.Sprintf("allocation %v/%v has no revision %v", ns, name, rollback.To)})
+		return
+	}
+
+	// the allocation mustn't share its containers with the revision
+	spec := target.Spec.DeepCopy()
+	_, _, err = allocationStore.CreateOrUpdate(&spec, allocations.Change{
+		Author:  author(req),
+		Message: fmt.Sprintf("rollback to revision %v", target.Number),
+	})
+	if err != nil {
+		r.JSON(writeError(err))
+		return
+	}
+
+	log.Printf("Rolled %v/%v back to revision %v", ns, name, target.Number)
+	allocation, err := allocationStore.Get(ns, name)
+	if err != nil {
+		r.JSON(500, map[string]string{"error": err.Error()})
+		return
+	}
+	r.JSON(200, map[string]int{"Revision": allocation.Revision})
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/calendar"
+	"github.com/horthy/docket/run"
+	"log"
+	"sync"
+	"time"
+)
+
+// Starts scheduled runs. Each tick starts a timer for every run due in
+// the minute after it, and the scheduler hears about changes through the
+// watch cache, so an allocation created, changed, paused or deleted
+// mid-minute is rescheduled straight away instead of at the next tick
+type Scheduler struct {
+	runner    run.AllocationRunner
+	store     *WatchCache
+	calendars calendar.Calendars
+
+	mutex *sync.Mutex
+	// the end of the minute the last tick covered, zero
+	// if this server isn't scheduling
+	until time.Time
+	// the fencing token of the last tick
+	token uint64
+	// runs waiting for their time, by allocation
+	pending map[string][]*pendingRun
+}
+
+type pendingRun struct {
+	timer *time.Timer
+}
+
+func NewScheduler(runner run.AllocationRunner, store *WatchCache, calendars calendar.Calendars) *Scheduler {
+	scheduler := &Scheduler{
+		runner:    runner,
+		store:     store,
+		calendars: calendars,
+		mutex:     &sync.Mutex{},
+		pending:   map[string][]*pendingRun{},
+	}
+	store.OnChange(scheduler.changed)
+	return scheduler
+}
+
+// Schedule the runs due in the minute after now, skipping any that fall
+// in a blackout of one of the allocation's calendars. The runs are fenced
+// with token, the scheduler lease term they were scheduled in
+func (s *Scheduler) Tick(now time.Time, token uint64) {
+	allAllocations, err := s.store.List(allocations.AllNamespaces, allocations.Everything())
+	if err != nil {
+		log.Printf("Couldn't get list of allocations, error was %v", err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// carry on from the end of the last minute, so
+	// runs due before it aren't scheduled twice
+	from := now
+	if s.until.After(from) {
+		from = s.until
+	}
+	s.until = now.Add(1 * time.Minute)
+	s.token = token
+	for _, alloc := range allAllocations {
+		s.schedule(alloc, from)
+	}
+}
+
+// Call off every waiting run, e.g. because this server lost the lease
+func (s *Scheduler) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key := range s.pending {
+		s.cancel(key)
+	}
+	s.until = time.Time{}
+}
+
+// Reschedule an allocation whose specification changed, for the rest of
+// the minute the last tick covered
+func (s *Scheduler) changed(alloc *allocations.Allocation, deleted bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cancel(alloc.Namespace + "/" + alloc.Name)
+	if deleted || s.until.IsZero() {
+		return
+	}
+	s.schedule(alloc, time.Now())
+}
+
+// Start a timer for each run of alloc due from from until the end of the
+// minute, at its time plus its offset. A run in a blackout gets a timer
+// too, at its time, that logs the skip, so it's logged once however often
+// the allocation is rescheduled. must be called while locked
+func (s *Scheduler) schedule(alloc *allocations.Allocation, from time.Time) {
+	schedule := alloc.Schedule()
+	if alloc.Paused || schedule == nil {
+		return
+	}
+
+	key := alloc.Namespace + "/" + alloc.Name
+	token := s.token
+	for next := schedule.Next(from.Add(-1 * time.Nanosecond)); !next.IsZero() && next.Before(s.until); next = schedule.Next(next) {
+		if !alloc.ActiveAt(next) {
+			continue
+		}
+		scheduled := next
+		_, blackedOut := s.calendars.Blackout(alloc.Blackouts, scheduled)
+		delay := scheduled.Sub(time.Now())
+		if !blackedOut {
+			delay = scheduled.Add(alloc.Offset()).Sub(time.Now())
+			log.Printf("Starting %v/%v in %v", alloc.Namespace, alloc.Name, delay)
+		}
+		pending := &pendingRun{}
+		pending.timer = time.AfterFunc(delay, func() {
+			s.mutex.Lock()
+			due := s.remove(key, pending)
+			s.mutex.Unlock()
+			if !due {
+				return
+			}
+			if blackedOut {
+				skipBlackout(s.store, s.calendars, alloc, scheduled)
+				return
+			}
+			s.runner.RunAllocation(alloc, nil, token)
+		})
+		s.pending[key] = append(s.pending[key], pending)
+	}
+}
+
+// must be called while locked
+func (s *Scheduler) cancel(key string) {
+	for _, pending := range s.pending[key] {
+		pending.timer.Stop()
+	}
+	delete(s.pending, key)
+}
+
+// Forget a run that's due, returning false if it was
+// called off while waiting for the lock. must be called while locked
+func (s *Scheduler) remove(key string, run *pendingRun) bool {
+	runs := s.pending[key]
+	for i, pending := range runs {
+		if pending == run {
+			s.pending[key] = append(runs[:i:i], runs[i+1:]...)
+			if len(s.pending[key]) == 0 {
+				delete(s.pending, key)
+			}
+			return true
+		}
+	}
+	return false
+}
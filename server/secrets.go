@@ -0,0 +1,43 @@
+package server
+
+import (
+	"github.com/codegangsta/martini"
+	"github.com/codegangsta/martini-contrib/render"
+	"github.com/horthy/docket/secrets"
+	"log"
+)
+
+// The body of PUT /secrets/:name. Values go in
+// but there is deliberately no way to get them back out
+type secretValue struct {
+	Value string `json:"Value" binding:"required"`
+}
+
+func handleListSecrets(secretStore secrets.Store, r render.Render) {
+	list, err := secretStore.List()
+	if err != nil {
+		r.JSON(500, err)
+	} else {
+		r.JSON(200, list)
+	}
+}
+
+func handlePutSecret(secret secretValue, secretStore secrets.Store, r render.Render, params martini.Params) {
+	err := secretStore.Set(params["name"], secret.Value)
+	if err != nil {
+		log.Printf("Failed to store secret %v, error was %v", params["name"], err)
+		r.JSON(500, err)
+	} else {
+		log.Printf("Stored secret %v", params["name"])
+		r.JSON(200, map[string]bool{"stored": true})
+	}
+}
+
+func handleDeleteSecret(secretStore secrets.Store, r render.Render, params martini.Params) {
+	err := secretStore.Delete(params["name"])
+	if err != nil {
+		r.JSON(writeError(err))
+	} else {
+		r.JSON(200, map[string]bool{"deleted": true})
+	}
+}
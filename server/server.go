@@ -2,75 +2,312 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/codegangsta/martini"
 	"github.com/codegangsta/martini-contrib/binding"
 	"github.com/codegangsta/martini-contrib/render"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/calendar"
+	"github.com/horthy/docket/lease"
+	"github.com/horthy/docket/notify"
+	"github.com/horthy/docket/registry"
 	"github.com/horthy/docket/run"
+	"github.com/horthy/docket/seal"
+	"github.com/horthy/docket/secrets"
 	"log"
+	"net/http"
 	"time"
 )
 
-func Start() {
+func Start(config Config) {
 
-	// TODO: env vars to configure storage backend, for now default to InMemory
-	store := allocations.InMemory()
+	store, scheduling, err := openStore(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	credentials, err := loadCredentials(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	secretStore, err := openSecrets(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	calendars, err := calendar.Load(config.Calendars)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(calendars) > 0 {
+		log.Printf("Loaded blackout calendars %v", calendars.Names())
+	}
+
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	elector := startElector(config, scheduling)
+
+	triggers := NewTriggerNotifier(store)
+	notifier := notify.NewDispatcher(append(notifiers(config, store), triggers)...)
+	fsouza := run.NewFsouza(client, store, notifier, credentials, secretStore)
+	limits := config.Queue
+	limits.PerNamespace = config.Namespaces.ConcurrentRuns()
+	queue := run.NewQueue(fsouza, store, limits)
+	queue.Start()
+	var runner run.AllocationRunner = queue
+	triggers.SetRunner(runner)
 
 	m := martini.Classic()
 	m.Use(render.Renderer())
 	m.Use(func(c martini.Context) {
 		c.MapTo(store, (*allocations.AllocationStore)(nil))
+		c.MapTo(runner, (*run.AllocationRunner)(nil))
+		c.Map(credentials)
+		c.Map(calendars)
+		c.MapTo(secretStore, (*secrets.Store)(nil))
+		c.Map(config.Namespaces)
+		c.Map(config)
+		c.Map(queue)
+		c.Map(elector)
 	})
 
+	m.Get("/overdue", handleGetOverdue)
+	m.Get("/next", handleGetNext)
+	m.Get("/queue", handleGetQueue)
+	m.Get("/graph", handleGetGraph)
+	m.Get("/export", handleExport)
+	m.Post("/import", handleImport)
+	m.Get("/secrets", handleListSecrets)
+	m.Put("/secrets/:name", binding.Bind(secretValue{}), handlePutSecret)
+	m.Delete("/secrets/:name", handleDeleteSecret)
+
+	m.Get("/namespaces/:ns/allocations", handleGet)
+	m.Post("/namespaces/:ns/allocations", binding.Bind(allocations.AllocationSpecification{}), handlePost)
+	m.Delete("/namespaces/:ns/allocations", handleDeleteSelected)
+	m.Get("/namespaces/:ns/allocations/:name", handleGetAllocation)
+	m.Put("/namespaces/:ns/allocations/:name", binding.Bind(allocations.AllocationSpecification{}), handlePost)
+	m.Delete("/namespaces/:ns/allocations/:name", handleDeleteAllocation)
+	m.Get("/namespaces/:ns/allocations/:name/revisions", handleGetRevisions)
+	m.Post("/namespaces/:ns/allocations/:name/rollback", binding.Bind(rollbackRequest{}), handleRollback)
+	m.Post("/namespaces/:ns/allocations/:name/:action", handleAction)
+	m.Get("/namespaces/:ns/next", handleGetNext)
+	m.Get("/namespaces/:ns/graph", handleGetGraph)
+	m.Post("/namespaces/:ns/:action", handleAction)
+
+	// the original routes, kept so older clients keep
+	// working, act on the default namespace
 	m.Get("/", handleGet)
 	m.Get("/:name", handleGetAllocation)
+	m.Get("/:name/revisions", handleGetRevisions)
 	m.Delete("/:name", handleDeleteAllocation)
 	m.Post("/", binding.Bind(allocations.AllocationSpecification{}), handlePost)
 
-	client, err := docker.NewClientFromEnv()
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	// TODO
 	// using ticker feels kinda janky -- even if we continue to maintain our own collection
 	// of Allocations, we can still use a cron library to manage scheduling our checks
+	scheduled := NewWatchCache(store)
+	scheduler := NewScheduler(runner, scheduled, calendars)
 	ticker := time.NewTicker(1 * time.Minute)
-	runner := run.NewFsouza(client, store)
 	go func() {
+		lastCheck := time.Now()
 		for range ticker.C {
-			RunAnyScheduledContainers(runner, store)
+			// not the tick's own time, a tick that was buffered
+			// while a slow run finished can be a minute or more old
+			now := time.Now()
+			token, leading := schedulerLease(elector, store)
+			if !leading {
+				scheduler.Stop()
+				lastCheck = now
+				continue
+			}
+			ReportMissedRuns(notifier, scheduled, calendars, lastCheck, now)
+			scheduler.Tick(now, token)
+			lastCheck = now
 		}
 	}()
 
+	NewOverdueMonitor(store, notifier).OnlyWhile(elector.Leading).Start(1 * time.Minute)
+	if config.CompactInterval > 0 {
+		NewCompactor(store, config.Retention).Start(config.CompactInterval)
+	}
+
 	// TODO/nice to have: watch docker event stream, add exit codes to Allocation Logs
 
 	m.Run()
 }
 
+func loadCredentials(config Config) (*registry.Credentials, error) {
+	credentials := registry.NewCredentials()
+
+	if config.DockerConfig != "" {
+		err := credentials.LoadDockerConfig(config.DockerConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.RegistryCredentials != "" {
+		if config.KeyFile == "" {
+			return nil, errors.New("a key file is required to load sealed registry credentials")
+		}
+		key, err := seal.KeyFromFile(config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		err = credentials.LoadSealedFile(config.RegistryCredentials, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Loaded registry credentials %v", credentials.Names())
+	return credentials, nil
+}
+
+// Keep allocations in the store file if there is one, where the other
+// servers sharing it compete for the scheduler lease, or in memory
+func openStore(config Config) (allocations.AllocationStore, lease.Lease, error) {
+	if config.StoreFile == "" {
+		log.Printf("No store file configured, allocations will only be kept in memory")
+		return allocations.InMemory(), soleLease{}, nil
+	}
+
+	store, err := allocations.File(config.StoreFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("Keeping allocations in %v", config.StoreFile)
+	return store, store, nil
+}
+
+func openSecrets(config Config) (secrets.Store, error) {
+	if config.SecretsFile == "" {
+		log.Printf("No secrets file configured, secrets will only be kept in memory")
+		return secrets.InMemory(), nil
+	}
+
+	if config.KeyFile == "" {
+		return nil, errors.New("a key file is required to encrypt the secrets file")
+	}
+	key, err := seal.KeyFromFile(config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return secrets.File(config.SecretsFile, key)
+}
+
+func notifiers(config Config, store allocations.AllocationStore) []notify.Notifier {
+	notifiers := []notify.Notifier{notify.NewWebhookNotifier(store, config.WebhookSecret)}
+	if config.SMTP.Host != "" {
+		log.Printf("Sending email notifications through %v:%v", config.SMTP.Host, config.SMTP.Port)
+		notifiers = append(notifiers, notify.NewEmailNotifier(store, config.SMTP))
+	}
+	return notifiers
+}
+
+// The namespace a request is for, routes without
+// a namespace are for the default namespace
+func namespace(params martini.Params) string {
+	return allocations.NamespaceOrDefault(params["ns"])
+}
+
 func handlePost(
 	allocation allocations.AllocationSpecification,
 	allocationStore allocations.AllocationStore,
+	credentials *registry.Credentials,
+	calendars calendar.Calendars,
+	quotas Quotas,
 	r render.Render,
+	params martini.Params,
+	req *http.Request,
+	w http.ResponseWriter,
 ) {
 
+	ns := namespace(params)
+	if !allocations.ValidNamespace(ns) {
+		r.JSON(422, map[string]string{"Namespace": "Namespace must be lower case letters, digits and dashes"})
+		return
+	}
+	if allocation.Namespace == "" {
+		allocation.Namespace = ns
+	}
+	if allocation.Namespace != ns {
+		r.JSON(422, map[string]string{"Namespace": fmt.Sprintf("allocation is for namespace %v but was posted to %v", allocation.Namespace, ns)})
+		return
+	}
+
+	// a PUT names the allocation in its url
+	if name, ok := params["name"]; ok && name != allocation.Name {
+		r.JSON(422, map[string]string{"Name": fmt.Sprintf("allocation is named %v but was put to %v", allocation.Name, name)})
+		return
+	}
+
+	version, err := ifMatch(req)
+	if err != nil {
+		r.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+	if version != 0 {
+		if allocation.ResourceVersion != 0 && allocation.ResourceVersion != version {
+			r.JSON(400, map[string]string{"error": "If-Match and ResourceVersion disagree"})
+			return
+		}
+		allocation.ResourceVersion = version
+	}
+	createOnly, err := ifNoneMatch(req)
+	if err != nil {
+		r.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if allocation.RegistryCredential != "" && !credentials.Has(allocation.RegistryCredential) {
+		r.JSON(422, map[string]string{"RegistryCredential": fmt.Sprintf("registry credential %v not found", allocation.RegistryCredential)})
+		return
+	}
+
+	for _, name := range allocation.Blackouts {
+		if !calendars.Has(name) {
+			r.JSON(422, map[string]string{"Blackouts": fmt.Sprintf("blackout calendar %v not found", name)})
+			return
+		}
+	}
+
 	allocation.ProvisionDefaults()
-	pretty, err := json.MarshalIndent(allocation, "", "    ")
+	pretty, _ := json.MarshalIndent(allocation, "", "    ")
 	log.Printf("Received new allocation %v", string(pretty))
 
-	created, err := allocationStore.CreateOrUpdate(&allocation)
+	change := quotas.Change(allocation.Namespace, allocations.Change{Author: author(req), CreateOnly: createOnly})
+	created, version, err := allocationStore.CreateOrUpdate(&allocation, change)
 	if err != nil {
-		log.Printf("Failed to store allocation %v, error was %v", pretty, err)
-		r.JSON(500, err)
-	} else {
-		log.Printf("Stored allocation %v", allocation.Name)
-		r.JSON(200, map[string]bool{"created": created})
+		log.Printf("Failed to store allocation %v/%v, error was %v", allocation.Namespace, allocation.Name, err)
+		r.JSON(writeError(err))
+		return
 	}
+
+	log.Printf("Stored allocation %v/%v", allocation.Namespace, allocation.Name)
+	w.Header().Set("ETag", etag(version))
+	r.JSON(200, map[string]bool{"created": created})
 }
 
-func handleGet(allocationStore allocations.AllocationStore, r render.Render) {
-	list, err := allocationStore.List()
+func handleGet(allocationStore allocations.AllocationStore, r render.Render, params martini.Params, req *http.Request, w http.ResponseWriter) {
+	selector, err := allocations.ParseSelector(req.URL.Query().Get("selector"))
+	if err != nil {
+		r.JSON(400, map[string]string{"selector": err.Error()})
+		return
+	}
+
+	if req.URL.Query().Get("watch") == "true" {
+		handleWatch(allocationStore, namespace(params), selector, r, w, req)
+		return
+	}
+
+	list, err := allocationStore.List(namespace(params), selector)
 	if err != nil {
 		r.JSON(500, err)
 	} else {
@@ -78,38 +315,111 @@ func handleGet(allocationStore allocations.AllocationStore, r render.Render) {
 	}
 }
 
-func handleGetAllocation(allocationStore allocations.AllocationStore, r render.Render, params martini.Params) {
-	allocation, err := allocationStore.Get(params["name"])
+func handleGetQueue(queue *run.Queue, r render.Render) {
+	r.JSON(200, queue.Status())
+}
+
+func handleGetOverdue(allocationStore allocations.AllocationStore, r render.Render) {
+	overdue, err := Overdue(allocationStore, time.Now())
 	if err != nil {
 		r.JSON(500, err)
 	} else {
+		r.JSON(200, overdue)
+	}
+}
+
+func handleGetAllocation(allocationStore allocations.AllocationStore, r render.Render, params martini.Params, w http.ResponseWriter) {
+	allocation, err := allocationStore.Get(namespace(params), params["name"])
+	if _, missing := err.(*allocations.NotFoundError); missing {
+		r.JSON(404, map[string]string{"error": err.Error()})
+	} else if err != nil {
+		r.JSON(500, err)
+	} else {
+		w.Header().Set("ETag", etag(allocation.ResourceVersion))
 		r.JSON(200, allocation)
 	}
 }
 
 func handleDeleteAllocation(allocationStore allocations.AllocationStore, r render.Render, params martini.Params) {
 
-	err := allocationStore.Delete(params["name"])
+	err := allocationStore.Delete(namespace(params), params["name"])
 	if err != nil {
-		r.JSON(500, err)
+		r.JSON(writeError(err))
 	} else {
 		r.JSON(200, map[string]bool{"deleted": true})
 	}
 }
 
-// Check the list of allocations, and create+start any
-// containers that are scheduled at the time the method is called
-func RunAnyScheduledContainers(runner run.AllocationRunner, allocationStore allocations.AllocationStore) {
-	allAllocations, err := allocationStore.List()
+// Whether the run of alloc scheduled for a time is in a blackout,
+// in which case the skip is logged on the allocation with its reason
+func skipBlackout(allocationStore allocations.AllocationStore, calendars calendar.Calendars, alloc *allocations.Allocation, scheduled time.Time) bool {
+	reason, blackedOut := calendars.Blackout(alloc.Blackouts, scheduled)
+	if !blackedOut {
+		return false
+	}
+	log.Printf("Skipping run of %v/%v scheduled for %v, %v", alloc.Namespace, alloc.Name, scheduled, reason)
+	allocationStore.Log(alloc, "Skipped run scheduled for", scheduled, "because of", reason)
+	return true
+}
+
+// how late a check can be before we consider the runs in between missed,
+// so ordinary ticker jitter doesn't get reported
+const missedRunGrace = 5 * time.Second
+
+// Each check covers the minute following it, so if the previous check was more
+// than a minute ago (a slow run, or a stalled host) anything scheduled in between
+// was never started. Log and notify once about each allocation that missed runs,
+// with how many it missed and when the first and last were due.
+func ReportMissedRuns(notifier notify.Notifier, allocationStore allocations.AllocationStore, calendars calendar.Calendars, lastCheck time.Time, now time.Time) {
+	missedFrom := lastCheck.Add(1 * time.Minute)
+	if now.Sub(missedFrom) < missedRunGrace {
+		return
+	}
 
+	allAllocations, err := allocationStore.List(allocations.AllNamespaces, allocations.Everything())
 	if err != nil {
 		log.Printf("Couldn't get list of allocations, error was %v", err)
 		return
 	}
 
 	for _, alloc := range allAllocations {
-		if alloc.ShouldRunAt(time.Now()) {
-			runner.RunAllocation(alloc)
+		schedule := alloc.Schedule()
+		if alloc.Paused || schedule == nil {
+			continue
+		}
+
+		var first, last time.Time
+		missed := 0
+		for next := schedule.Next(missedFrom.Add(-1 * time.Nanosecond)); !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+			if !alloc.ActiveAt(next) {
+				continue
+			}
+			// it wouldn't have run anyway
+			if _, blackedOut := calendars.Blackout(alloc.Blackouts, next); blackedOut {
+				continue
+			}
+			if missed == 0 {
+				first = next
+			}
+			last = next
+			missed++
+		}
+		if missed == 0 {
+			continue
+		}
+
+		message := fmt.Sprintf("missed run scheduled for %v", first)
+		if missed > 1 {
+			message = fmt.Sprintf("missed %v runs scheduled from %v to %v", missed, first, last)
 		}
+		log.Printf("Allocation %v/%v %v", alloc.Namespace, alloc.Name, message)
+		allocationStore.Log(alloc, "Scheduler", message)
+		notifier.Notify(alloc, notify.Event{
+			Type:       allocations.EventMissed,
+			Namespace:  alloc.Namespace,
+			Allocation: alloc.Name,
+			Message:    fmt.Sprintf("%v, scheduler did not check between %v and %v", message, missedFrom, now),
+			Time:       now,
+		})
 	}
 }
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"github.com/codegangsta/martini"
+	"github.com/codegangsta/martini-contrib/render"
+	"github.com/horthy/docket/allocations"
+	"github.com/horthy/docket/notify"
+	"github.com/horthy/docket/run"
+	"log"
+)
+
+// Starts the runs of allocations whose Trigger is waiting on an
+// allocation that just finished. It hears about finished runs as a
+// notify.Notifier, so it only sees runs that were actually recorded
+type TriggerNotifier struct {
+	store  allocations.AllocationStore
+	runner run.AllocationRunner
+}
+
+func NewTriggerNotifier(store allocations.AllocationStore) *TriggerNotifier {
+	return &TriggerNotifier{store: store}
+}
+
+// Set the runner triggered runs go to. The runner usually reports to
+// the notifier this is part of, so it can't be passed to the constructor
+func (t *TriggerNotifier) SetRunner(runner run.AllocationRunner) {
+	t.runner = runner
+}
+
+func (t *TriggerNotifier) Notify(alloc *allocations.Allocation, event notify.Event) {
+	var succeeded bool
+	switch event.Type {
+	case allocations.EventSuccess:
+		succeeded = true
+	case allocations.EventFailure, allocations.EventTimeout:
+		succeeded = false
+	default:
+		return
+	}
+	if t.runner == nil {
+		return
+	}
+
+	downstream, err := t.store.List(alloc.Namespace, allocations.Everything())
+	if err != nil {
+		log.Printf("Couldn't list allocations triggered by %v/%v, error was %v", alloc.Namespace, alloc.Name, err)
+		return
+	}
+
+	upstream := &allocations.Upstream{Namespace: alloc.Namespace, Allocation: alloc.Name, RunID: event.RunID}
+	token := t.fencingToken(alloc, event.RunID)
+	for _, triggered := range downstream {
+		if triggered.Paused || !triggered.TriggeredBy(alloc, succeeded) {
+			continue
+		}
+		log.Printf("Run %v of %v/%v triggered %v/%v", event.RunID, alloc.Namespace, alloc.Name, triggered.Namespace, triggered.Name)
+		t.store.Log(triggered, "Triggered by run", event.RunID, "of", alloc.Name)
+		t.runner.RunAllocation(triggered, upstream, token)
+	}
+}
+
+// The token the run was fenced with, which the runs it triggers inherit
+// so a deposed leader's chain of runs is refused along with it. alloc
+// can be a snapshot from before the run, so the run is found in the store
+func (t *TriggerNotifier) fencingToken(alloc *allocations.Allocation, runID string) uint64 {
+	stored, err := t.store.Get(alloc.Namespace, alloc.Name)
+	if err != nil {
+		return 0
+	}
+	for i := len(stored.Runs) - 1; i >= 0; i-- {
+		if stored.Runs[i].ID == runID {
+			return stored.Runs[i].FencingToken
+		}
+	}
+	return 0
+}
+
+// The trigger dependencies between allocations, across every
+// namespace for /graph, or in one for /namespaces/:ns/graph
+func handleGetGraph(allocationStore allocations.AllocationStore, r render.Render, params martini.Params) {
+	ns := allocations.AllNamespaces
+	if _, ok := params["ns"]; ok {
+		ns = namespace(params)
+	}
+
+	list, err := allocationStore.List(ns, allocations.Everything())
+	if err != nil {
+		r.JSON(500, err)
+		return
+	}
+	r.JSON(200, allocations.NewGraph(list))
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/codegangsta/martini-contrib/render"
+	"github.com/horthy/docket/allocations"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Stream changes to the allocations in ns that match selector as
+// newline delimited JSON WatchEvents, until the client goes away.
+// ?resourceVersion=N resumes after version N
+func handleWatch(
+	allocationStore allocations.AllocationStore,
+	ns string,
+	selector allocations.Selector,
+	r render.Render,
+	w http.ResponseWriter,
+	req *http.Request,
+) {
+	watcher, ok := allocationStore.(allocations.Watcher)
+	if !ok {
+		r.JSON(501, map[string]string{"error": "this server's store doesn't support watches"})
+		return
+	}
+
+	var since uint64
+	if raw := req.URL.Query().Get("resourceVersion"); raw != "" {
+		var err error
+		since, err = strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			r.JSON(400, map[string]string{"resourceVersion": err.Error()})
+			return
+		}
+	}
+
+	events, cancel, err := watcher.Watch(since)
+	if err == allocations.ErrWatchExpired {
+		r.JSON(410, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		r.JSON(500, map[string]string{"error": err.Error()})
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(200)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case event, open := <-events:
+			if !open {
+				// fell behind, the client resumes from the last version it saw
+				return
+			}
+			if ns != allocations.AllNamespaces && event.Allocation.Namespace != ns {
+				continue
+			}
+			if !selector.Matches(event.Allocation.Labels) {
+				continue
+			}
+			if err := encoder.Encode(event); err != nil {
+				log.Printf("Stopped watch, error was %v", err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}